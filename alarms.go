@@ -0,0 +1,320 @@
+package main
+
+import (
+	"awake-bot/clock"
+	"awake-bot/i18n"
+	"awake-bot/logging"
+	"awake-bot/outbox"
+	"awake-bot/persona"
+	"awake-bot/schedule"
+	"awake-bot/sleep"
+	"awake-bot/store"
+	"awake-bot/timeout"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+var (
+	alarmsMu    sync.Mutex
+	alarmTimers = map[string]clock.Timer{} // userId
+)
+
+// userLocation is the user's timezone, or the configured one.
+func userLocation(u store.User) *time.Location {
+	if u.TimeZone != "" {
+		if loc, err := time.LoadLocation(u.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return conf.Location()
+}
+
+func scheduleAlarms() {
+	for _, u := range users.Users() {
+		scheduleAlarm(u)
+	}
+}
+
+// (re)arms the timer for the user's next alarm
+func scheduleAlarm(u store.User) {
+	scheduleAlarmAfter(u, clk.Now())
+}
+
+// arms the first alarm after t, which is later than now once an alarm with
+// a window rang early
+func scheduleAlarmAfter(u store.User, t time.Time) {
+	alarmsMu.Lock()
+	defer alarmsMu.Unlock()
+
+	if timer, ok := alarmTimers[u.Id]; ok {
+		timer.Stop()
+		delete(alarmTimers, u.Id)
+	}
+	alarm, latest := nextAlarm(u, t)
+	if alarm == nil {
+		return
+	}
+	// paused while the user blocks the bot or it is out of the room
+	if users.Room(u.Id).Unreachable || users.Room(alarmRoom(u, alarm)).Unreachable {
+		logger.Debug("alarm paused", "user_id", u.Id)
+		return
+	}
+
+	now := clk.Now()
+	at := wakeTime(u, alarm, latest)
+	if at.Before(now) {
+		at = latest
+	}
+	userId, once := u.Id, alarm.Once()
+	alarmTimers[userId] = clk.AfterFunc(at.Sub(now), func() { ringAlarm(userId, once, latest) })
+
+	logger.Debug("alarm scheduled", "user_id", userId, "at", at, "latest", latest, "once", once)
+}
+
+// the smart alarm: with a window and a bedtime, the end of the last sleep
+// cycle before latest
+func wakeTime(u store.User, a *schedule.Alarm, latest time.Time) time.Time {
+	if a.Window <= 0 || u.BedAt == nil || latest.Sub(*u.BedAt) > sleep.MaxNight {
+		return latest
+	}
+	return sleep.WakeTime(*u.BedAt, latest, time.Duration(a.Window)*time.Minute, conf.Sleep.Onset, conf.Sleep.Cycle)
+}
+
+// the one-off or the recurring alarm, whichever rings first after now and
+// out of the user's pause
+func nextAlarm(u store.User, now time.Time) (*schedule.Alarm, time.Time) {
+	loc := userLocation(u)
+	var alarm *schedule.Alarm
+	var next time.Time
+	for _, a := range []*schedule.Alarm{u.Once, u.Alarm} {
+		if a == nil {
+			continue
+		}
+		at := a.Next(now, loc)
+		if paused(u, at) {
+			at = a.Next(u.Pause.Until.Add(-time.Nanosecond), loc)
+		}
+		if !at.IsZero() && (alarm == nil || at.Before(next)) {
+			alarm, next = a, at
+		}
+	}
+	return alarm, next
+}
+
+// where the alarm rings, the user's 1:1 chat unless set in a group
+func alarmRoom(u store.User, a *schedule.Alarm) string {
+	if a == nil || a.RoomId == "" {
+		return u.Id
+	}
+	return a.RoomId
+}
+
+// latest is when the alarm is set for, it may ring earlier in its window
+func ringAlarm(userId string, once bool, latest time.Time) {
+	inflight.Add()
+	defer inflight.Done()
+
+	u := users.User(userId)
+	alarm := u.Alarm
+	if once {
+		alarm = u.Once
+	}
+	if alarm == nil {
+		return
+	}
+	roomId := alarmRoom(u, alarm)
+	l := logger.With("user_id", userId, "room_id", roomId)
+
+	if once {
+		// gone before ringing, so it never rings twice
+		updated, err := users.UpdateUser(userId, func(u *store.User) { u.Once = nil })
+		if err != nil {
+			l.Error("failed to clear the one-off alarm", "err", err)
+		} else {
+			u = updated
+		}
+	}
+	defer scheduleAlarmAfter(u, latest)
+
+	if paused(u, clk.Now()) {
+		l.Info("the user is paused. alarm skipped.")
+		return
+	}
+
+	loc := userLocation(u)
+	if alarm.SkipHolidays && isHolidayToday(loc) {
+		l.Info("today is holiday. alarm skipped.")
+		recordOutcome(nil, roomId, userId, "skipped")
+		holidaySkips.Inc()
+		return
+	}
+
+	if to := wakeUp(l, u, roomId, true); to != nil && alarm.Window > 0 {
+		to.SetWakeWindow(to.StartedAt, latest)
+		l.Info("smart alarm", "session_id", to.Id, "early", latest.Sub(to.StartedAt))
+	}
+}
+
+// starts a session for the user in the room and says it's time to get up,
+// nil when the room has one already
+func wakeUp(l *logging.Logger, u store.User, roomId string, withForecast bool) *timeout.Timeout {
+	to, ok := startSession(l, roomId, u.Id, "", alarmTimeout(u))
+	if !ok {
+		l.Warn("snooze for the room already exists.")
+		return nil
+	}
+
+	name, lc := lookupUser(u.Id)
+	today := clk.Now().In(userLocation(u))
+	vars := persona.Vars{Name: name, Date: formatDate(lc, today), Holiday: holidayName(today)}
+	messages := sayWithSticker(roomPersona(roomId, lc), "alarm", vars)
+	if withForecast {
+		if f, ok := forecastMessage(roomId, u.Id); ok {
+			messages = append(messages, f)
+		}
+	}
+	if text, ok := welcomeBack(l, u.Id); ok {
+		messages = append(messages, newTextMessage(text))
+	}
+	if err := send(roomId, outbox.High, messages...); err != nil {
+		l.Error("failed to push alarm", "err", err)
+	}
+	return to
+}
+
+// /tz [Area/City]
+func onTimeZoneCommand(event *linebot.Event, args []string, lc *i18n.Locale) string {
+	u := users.User(event.Source.UserID)
+
+	if len(args) == 0 {
+		loc := userLocation(u)
+		return lc.T("tz.current", "TimeZone", loc, "Now", formatDateTime(lc, clk.Now().In(loc)))
+	}
+
+	loc, err := time.LoadLocation(args[0])
+	if err != nil || args[0] == "" || strings.EqualFold(args[0], "local") {
+		return lc.T("tz.unknown", "TimeZone", args[0])
+	}
+
+	u, err = users.UpdateUser(u.Id, func(u *store.User) { u.TimeZone = loc.String() })
+	if err != nil {
+		logger.Error("failed to save timezone", "user_id", u.Id, "err", err)
+		return lc.T("save_failed")
+	}
+	scheduleAlarm(u)
+
+	return lc.T("tz.set", "TimeZone", loc, "Now", formatDateTime(lc, clk.Now().In(loc)))
+}
+
+// /alarm [H:MM [平日|毎日]] or /alarm off
+func onAlarmCommand(event *linebot.Event, args []string, lc *i18n.Locale) string {
+	u := users.User(event.Source.UserID)
+	loc := userLocation(u)
+	now := clk.Now()
+
+	if len(args) == 0 {
+		alarm, next := nextAlarm(u, now)
+		if alarm == nil {
+			return lc.T("alarm.none")
+		}
+		return lc.T("alarm.current", "Alarm", formatAlarm(lc, alarm), "TimeZone", loc,
+			"Next", formatDateTime(lc, next), "Until", formatUntil(lc, next.Sub(now)))
+	}
+
+	if args[0] == "off" {
+		u, err := users.UpdateUser(u.Id, func(u *store.User) { u.Alarm, u.Once = nil, nil })
+		if err != nil {
+			return lc.T("save_failed")
+		}
+		scheduleAlarm(u)
+		return lc.T("alarm.off")
+	}
+
+	if args[0] == "window" {
+		return onAlarmWindow(u, args[1:], lc)
+	}
+
+	alarm := &schedule.Alarm{Weekdays: schedule.Weekdays, SkipHolidays: true}
+	var err error
+	if alarm.Hour, alarm.Minute, err = schedule.ParseClock(args[0]); err != nil {
+		if alarm.Sun, alarm.Offset, err = schedule.ParseSun(args[0]); err != nil {
+			return lc.T("alarm.bad_time")
+		}
+	}
+	for _, arg := range args[1:] {
+		switch arg {
+		case "平日", "weekdays":
+		case "毎日", "everyday":
+			alarm.Weekdays, alarm.SkipHolidays = nil, false
+		default:
+			if alarm.Sun == "" || !strings.Contains(arg, ":") {
+				return lc.T("alarm.bad_days")
+			}
+			if alarm.Earliest, alarm.Latest, err = schedule.ParseRange(arg); err != nil {
+				return lc.T("alarm.bad_range")
+			}
+		}
+	}
+	placeSunAlarm(u, alarm)
+	if roomId := sourceId(event.Source); roomId != u.Id {
+		alarm.RoomId = roomId
+	}
+	if u.Alarm != nil {
+		alarm.Window = u.Alarm.Window
+	}
+
+	u, err = users.UpdateUser(u.Id, func(u *store.User) { u.Alarm = alarm })
+	if err != nil {
+		logger.Error("failed to save alarm", "user_id", u.Id, "err", err)
+		return lc.T("save_failed")
+	}
+	scheduleAlarm(u)
+
+	next := alarm.Next(now, loc)
+	return lc.T("alarm.set", "Alarm", formatAlarm(lc, alarm), "TimeZone", loc,
+		"Next", formatDateTime(lc, next), "Until", formatUntil(lc, next.Sub(now)))
+}
+
+// /alarm window [minutes]: how much earlier than set the alarm may ring to
+// wake the user at the end of a sleep cycle, 0 to ring on time
+func onAlarmWindow(u store.User, args []string, lc *i18n.Locale) string {
+	if u.Alarm == nil {
+		return lc.T("alarm.none")
+	}
+	max := int(conf.Sleep.MaxWindow.Minutes())
+	if len(args) == 0 {
+		return lc.T("alarm.window", "Minutes", u.Alarm.Window, "Max", max)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSuffix(args[0], "分"))
+	if err != nil || n < 0 || n > max {
+		return lc.T("alarm.bad_window", "Max", max)
+	}
+	removed := false // by another event since u was read
+	u, err = users.UpdateUser(u.Id, func(u *store.User) {
+		if u.Alarm == nil {
+			removed = true
+			return
+		}
+		a := *u.Alarm
+		a.Window = n
+		u.Alarm = &a
+	})
+	if err != nil {
+		logger.Error("failed to save alarm", "user_id", u.Id, "err", err)
+		return lc.T("save_failed")
+	}
+	if removed {
+		return lc.T("alarm.none")
+	}
+	scheduleAlarm(u)
+
+	if n == 0 {
+		return lc.T("alarm.window_off", "Alarm", formatAlarm(lc, u.Alarm))
+	}
+	return lc.T("alarm.window_set", "Alarm", formatAlarm(lc, u.Alarm), "Minutes", n)
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC)
+
+func TestSignAndVerify(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+	token := s.Sign("login", "U1", now.Add(10*time.Minute))
+
+	c, err := s.Verify("login", token, now.Add(9*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject != "U1" || c.Kind != "login" || c.Nonce == "" || !c.Expires.Equal(now.Add(10*time.Minute)) {
+		t.Errorf("claims = %+v", c)
+	}
+
+	if other := s.Sign("login", "U1", now.Add(10*time.Minute)); other == token {
+		t.Error("two tokens are the same")
+	}
+	if _, err := s.Verify("login", token, now.Add(10*time.Minute)); err != ErrExpired {
+		t.Errorf("err = %v, want ErrExpired", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+	token := s.Sign("login", "U1", now.Add(time.Hour))
+	payload := strings.Split(token, ".")[0]
+
+	forged := NewSigner([]byte("other")).Sign("login", "U1", now.Add(time.Hour))
+	tampered := encoding.EncodeToString([]byte("login\nU2\n9999999999\nabc")) + "." + strings.Split(token, ".")[1]
+
+	for name, tt := range map[string]struct{ kind, token string }{
+		"other kind":  {"session", token},
+		"other key":   {"login", forged},
+		"tampered":    {"login", tampered},
+		"no mac":      {"login", payload},
+		"empty":       {"login", ""},
+		"not base64":  {"login", "!!.!!"},
+		"extra parts": {"login", token + ".x"},
+	} {
+		if _, err := s.Verify(tt.kind, tt.token, now); err != ErrInvalid {
+			t.Errorf("%s: err = %v, want ErrInvalid", name, err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"awake-bot/outbox"
+	"awake-bot/quota"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+var (
+	quotaClient *quota.Client
+	budget      = quota.NewTracker(nil)
+
+	replyTokensMu sync.Mutex
+	replyTokens   = map[string]replyToken{} // roomId
+)
+
+type replyToken struct {
+	token      string
+	receivedAt time.Time
+}
+
+// keeps the token of an event nobody replied to, so the next message to the
+// chat can be a free reply instead of a push
+func keepReplyToken(roomId string, token string) {
+	if token == "" || conf.Quota.ReplyTokenTTL <= 0 {
+		return
+	}
+
+	replyTokensMu.Lock()
+	defer replyTokensMu.Unlock()
+	replyTokens[roomId] = replyToken{token, clk.Now()}
+}
+
+// takes the room's reply token if it is still fresh; each one is used once
+func takeReplyToken(roomId string) (string, bool) {
+	replyTokensMu.Lock()
+	defer replyTokensMu.Unlock()
+
+	t, ok := replyTokens[roomId]
+	delete(replyTokens, roomId)
+	if !ok || clk.Now().Sub(t.receivedAt) >= conf.Quota.ReplyTokenTTL {
+		return "", false
+	}
+	return t.token, true
+}
+
+// send delivers messages to roomId in one call, as cheaply as the quota
+// allows: a reply when the chat just talked to us, otherwise a push through
+// the outbox. outbox.ErrQueued means the push failed and will be retried.
+func send(roomId string, p outbox.Priority, messages ...linebot.SendingMessage) error {
+	if users.Room(roomId).Unreachable {
+		return errUnreachable
+	}
+
+	if token, ok := takeReplyToken(roomId); ok {
+		_, err := bot.ReplyMessage(token, messages...).Do()
+		if err == nil {
+			pushesSaved.Inc("reply")
+			return nil
+		}
+		logger.Warn("failed to reply. pushing instead.", "room_id", roomId, "err", err)
+	}
+
+	if onlyStickers(messages) && budget.Usage().Ratio() >= conf.Quota.CutStickersAt {
+		logger.Info("quota is nearly used up. sticker dropped.", "room_id", roomId)
+		pushesSaved.Inc("sticker")
+		return nil
+	}
+
+	raw, err := encodeMessages(messages)
+	if err != nil {
+		return err
+	}
+	err = outgoing.Send(roomId, p, raw...)
+	updateOutboxMetrics()
+	return err
+}
+
+func onlyStickers(messages []linebot.SendingMessage) bool {
+	for _, m := range messages {
+		if _, ok := m.(*linebot.StickerMessage); !ok {
+			return false
+		}
+	}
+	return len(messages) > 0
+}
+
+// reads the quota from LINE, then every conf.Quota.SyncInterval
+func syncQuota() {
+	u, err := quotaClient.Fetch()
+	if err != nil {
+		logger.Warn("failed to fetch quota", "err", err)
+	} else {
+		reached := budget.Sync(u, clk.Now().In(conf.Location()))
+		updateQuotaMetrics()
+		warnQuota(reached)
+	}
+
+	clk.AfterFunc(conf.Quota.SyncInterval, syncQuota)
+}
+
+func updateQuotaMetrics() {
+	u := budget.Usage()
+	quotaUsed.Set(float64(u.Used))
+	quotaLimit.Set(float64(u.Limit))
+}
+
+// tells the admin room about the highest threshold reached
+func warnQuota(reached []float64) {
+	if len(reached) == 0 {
+		return
+	}
+
+	u := budget.Usage()
+	percent := int(reached[len(reached)-1] * 100)
+	logger.Warn("quota threshold reached", "percent", percent, "used", u.Used, "limit", u.Limit)
+
+	if conf.Quota.AdminRoom == "" {
+		return
+	}
+	text := locales.Get("").T("quota.warning", "Percent", percent, "Used", u.Used, "Limit", u.Limit)
+	if err := send(conf.Quota.AdminRoom, outbox.Low, newTextMessage(text)); err != nil {
+		logger.Error("failed to warn about quota", "err", err)
+	}
+}
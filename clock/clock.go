@@ -0,0 +1,80 @@
+// Package clock abstracts time so snoozes and holiday checks can be tested
+// without real waits.
+package clock
+
+import (
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type realClock struct{}
+
+type realTimer struct {
+	*time.Timer
+}
+
+// Real returns the wall clock.
+func Real() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+type scaledClock struct {
+	Clock
+	speed float64
+}
+
+// Scaled returns a clock whose timers fire speed times sooner than c's.
+func Scaled(c Clock, speed float64) Clock {
+	if speed <= 0 || speed == 1 {
+		return c
+	}
+	return scaledClock{c, speed}
+}
+
+func (c scaledClock) scale(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / c.speed)
+}
+
+func (c scaledClock) NewTimer(d time.Duration) Timer {
+	return scaledTimer{c.Clock.NewTimer(c.scale(d)), c}
+}
+
+func (c scaledClock) AfterFunc(d time.Duration, f func()) Timer {
+	return scaledTimer{c.Clock.AfterFunc(c.scale(d), f), c}
+}
+
+type scaledTimer struct {
+	Timer
+	c scaledClock
+}
+
+func (t scaledTimer) Reset(d time.Duration) bool {
+	return t.Timer.Reset(t.c.scale(d))
+}
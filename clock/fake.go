@@ -0,0 +1,135 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a clock which only moves when told to.
+type Fake struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock  *Fake
+	when   time.Time
+	f      func()
+	c      chan time.Time
+	active bool
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (c *Fake) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	c.schedule(t, d)
+	return t
+}
+
+func (c *Fake) AfterFunc(d time.Duration, f func()) Timer {
+	t := &fakeTimer{clock: c, f: f}
+	c.schedule(t, d)
+	return t
+}
+
+// Advance moves the clock forward by d, firing due timers in order.
+// AfterFunc callbacks run synchronously at their own fire time.
+func (c *Fake) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to t, firing due timers in order.
+func (c *Fake) Set(t time.Time) {
+	for {
+		c.mu.Lock()
+		next := c.next(t)
+		if next == nil {
+			if t.After(c.now) {
+				c.now = t
+			}
+			c.mu.Unlock()
+			return
+		}
+
+		next.active = false
+		c.now = next.when
+		c.mu.Unlock()
+
+		if next.f != nil {
+			next.f()
+		} else {
+			next.c <- next.when
+		}
+	}
+}
+
+// Pending returns how many timers have not fired yet.
+func (c *Fake) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for _, t := range c.timers {
+		if t.active {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *Fake) schedule(t *fakeTimer, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t.when = c.now.Add(d)
+	t.active = true
+	c.timers = append(c.timers, t)
+}
+
+// returns the earliest active timer due until t, dropping inactive ones
+func (c *Fake) next(t time.Time) *fakeTimer {
+	var next *fakeTimer
+	timers := c.timers[:0]
+
+	for _, ft := range c.timers {
+		if !ft.active {
+			continue
+		}
+		timers = append(timers, ft)
+		if !ft.when.After(t) && (next == nil || ft.when.Before(next.when)) {
+			next = ft
+		}
+	}
+
+	c.timers = timers
+	return next
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	active := t.active
+	t.active = false
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	active := t.Stop()
+	t.clock.schedule(t, d)
+	return active
+}
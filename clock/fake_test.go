@@ -0,0 +1,59 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeFiresInOrder(t *testing.T) {
+	start := time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+
+	fired := []time.Duration{}
+	record := func() { fired = append(fired, c.Now().Sub(start)) }
+
+	c.AfterFunc(3*time.Minute, record)
+	c.AfterFunc(1*time.Minute, func() {
+		record()
+		// timers scheduled by a callback fire within the same Advance
+		c.AfterFunc(time.Minute, record)
+	})
+	stopped := c.AfterFunc(2*time.Minute, record)
+	stopped.Stop()
+
+	c.Advance(5 * time.Minute)
+
+	want := []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute}
+	if len(fired) != len(want) {
+		t.Fatalf("fired = %v, want %v", fired, want)
+	}
+	for i := range want {
+		if fired[i] != want[i] {
+			t.Errorf("fired = %v, want %v", fired, want)
+		}
+	}
+
+	if now := c.Now(); !now.Equal(start.Add(5 * time.Minute)) {
+		t.Errorf("now = %v", now)
+	}
+	if c.Pending() != 0 {
+		t.Errorf("pending = %d", c.Pending())
+	}
+}
+
+func TestFakeTimerChannel(t *testing.T) {
+	start := time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+
+	timer := c.NewTimer(time.Second)
+	c.Advance(time.Second)
+
+	select {
+	case at := <-timer.C():
+		if !at.Equal(start.Add(time.Second)) {
+			t.Errorf("fired at %v", at)
+		}
+	default:
+		t.Error("timer did not fire")
+	}
+}
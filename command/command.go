@@ -0,0 +1,169 @@
+// Package command routes chat commands like "/alarm 7:00" to handlers,
+// checking arguments and who may run them.
+package command
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Role is what a user may do in a chat. Each role may do what the ones
+// below it may.
+type Role int
+
+const (
+	Anyone Role = iota
+	Owner       // of the room: the 1:1 chat's user, or who claimed the group
+	Admin       // of the bot
+)
+
+func (r Role) String() string {
+	switch r {
+	case Owner:
+		return "owner"
+	case Admin:
+		return "admin"
+	}
+	return "anyone"
+}
+
+var (
+	// ErrNotCommand is returned for text which does not start with a slash.
+	ErrNotCommand = errors.New("command: not a command")
+	ErrUnknown    = errors.New("command: unknown command")
+	ErrForbidden  = errors.New("command: forbidden")
+)
+
+// UsageError is returned when the arguments do not fit the command.
+type UsageError struct {
+	Command *Command
+}
+
+func (e *UsageError) Error() string {
+	return "command: wrong arguments for " + e.Command.Name
+}
+
+// Request is one command as sent by a user. Data is whatever the caller
+// wants to hand to the handler, e.g. the event and the user's locale.
+type Request struct {
+	Name string // as registered, even when an alias was used
+	Args []string
+	Role Role
+	Data interface{}
+}
+
+type Handler func(req *Request) string
+
+type Command struct {
+	Name    string   // with the slash, e.g. "/alarm"
+	Aliases []string // e.g. "/アラーム"
+	Role    Role     // who may run it
+	MinArgs int
+	MaxArgs int // -1 for no limit
+	Handler Handler
+}
+
+type Router struct {
+	commands []*Command
+	names    map[string]*Command // names and aliases
+}
+
+func NewRouter() *Router {
+	return &Router{names: map[string]*Command{}}
+}
+
+// Register adds c. Names are matched case-insensitively; registering one
+// twice is a bug and panics.
+func (r *Router) Register(c Command) {
+	cmd := &c
+	for _, name := range append([]string{c.Name}, c.Aliases...) {
+		key := strings.ToLower(name)
+		if _, dup := r.names[key]; dup {
+			panic("command: " + name + " registered twice")
+		}
+		r.names[key] = cmd
+	}
+	r.commands = append(r.commands, cmd)
+}
+
+// Lookup finds a command by its name or an alias.
+func (r *Router) Lookup(name string) (*Command, bool) {
+	c, ok := r.names[strings.ToLower(name)]
+	return c, ok
+}
+
+// Commands returns the commands role may run, ordered by name.
+func (r *Router) Commands(role Role) []*Command {
+	list := []*Command{}
+	for _, c := range r.commands {
+		if c.Role <= role {
+			list = append(list, c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Dispatch parses text and runs the command, returning its reply. role is
+// the sender's and data is passed to the handler as is.
+func (r *Router) Dispatch(text string, role Role, data interface{}) (string, error) {
+	name, args, ok := Parse(text)
+	if !ok {
+		return "", ErrNotCommand
+	}
+
+	c, ok := r.Lookup(name)
+	if !ok {
+		return "", ErrUnknown
+	}
+	if c.Role > role {
+		return "", ErrForbidden
+	}
+	if len(args) < c.MinArgs || (c.MaxArgs >= 0 && len(args) > c.MaxArgs) {
+		return "", &UsageError{c}
+	}
+
+	return c.Handler(&Request{Name: c.Name, Args: args, Role: role, Data: data}), nil
+}
+
+// Parse splits "/name arg "quoted arg"" into the name and its arguments. A
+// full-width slash works too, as Japanese keyboards often type one.
+func Parse(text string) (string, []string, bool) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "／") {
+		text = "/" + strings.TrimPrefix(text, "／")
+	}
+	if !strings.HasPrefix(text, "/") {
+		return "", nil, false
+	}
+
+	fields := []string{}
+	var field strings.Builder
+	inField, quoted := false, false
+	for _, r := range text {
+		switch {
+		case r == '"' || r == '“' || r == '”':
+			quoted = !quoted
+			inField = true
+		case !quoted && unicode.IsSpace(r):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+
+	if len(fields) == 0 || fields[0] == "/" {
+		return "", nil, false
+	}
+	return fields[0], fields[1:], true
+}
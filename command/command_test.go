@@ -0,0 +1,104 @@
+package command
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text string
+		name string
+		args []string
+		ok   bool
+	}{
+		{"/alarm 7:00 平日", "/alarm", []string{"7:00", "平日"}, true},
+		{"  /id  ", "/id", []string{}, true},
+		{"／アラーム　7:00", "/アラーム", []string{"7:00"}, true},
+		{`/remind "ゴミ出し 忘れずに" 7:30`, "/remind", []string{"ゴミ出し 忘れずに", "7:30"}, true},
+		{`/say “”`, "/say", []string{""}, true},
+		{"おはよう", "", nil, false},
+		{"/", "", nil, false},
+		{"", "", nil, false},
+	}
+
+	for _, tt := range tests {
+		name, args, ok := Parse(tt.text)
+		if ok != tt.ok || name != tt.name || strings.Join(args, "|") != strings.Join(tt.args, "|") || len(args) != len(tt.args) {
+			t.Errorf("Parse(%q) = %q, %q, %v, want %q, %q, %v", tt.text, name, args, ok, tt.name, tt.args, tt.ok)
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	r := NewRouter()
+	echo := func(req *Request) string {
+		return req.Name + " " + strings.Join(req.Args, ",") + " " + req.Data.(string)
+	}
+	r.Register(Command{Name: "/alarm", Aliases: []string{"/アラーム"}, MaxArgs: 2, Handler: echo})
+	r.Register(Command{Name: "/persona", Role: Owner, MaxArgs: 1, Handler: echo})
+	r.Register(Command{Name: "/quota", Role: Admin, Handler: echo})
+	r.Register(Command{Name: "/tz", MinArgs: 1, MaxArgs: -1, Handler: echo})
+
+	tests := []struct {
+		text  string
+		role  Role
+		reply string
+		err   error
+	}{
+		{"/alarm 7:00", Anyone, "/alarm 7:00 data", nil},
+		{"/ALARM", Anyone, "/alarm  data", nil},
+		{"/アラーム 7:00 毎日", Anyone, "/alarm 7:00,毎日 data", nil},
+		{"/persona gentle", Anyone, "", ErrForbidden},
+		{"/persona gentle", Owner, "/persona gentle data", nil},
+		{"/persona gentle", Admin, "/persona gentle data", nil},
+		{"/quota", Owner, "", ErrForbidden},
+		{"/quota", Admin, "/quota  data", nil},
+		{"/nope", Admin, "", ErrUnknown},
+		{"hello", Admin, "", ErrNotCommand},
+		{"/tz a b c", Anyone, "/tz a,b,c data", nil},
+	}
+
+	for _, tt := range tests {
+		reply, err := r.Dispatch(tt.text, tt.role, "data")
+		if reply != tt.reply || err != tt.err {
+			t.Errorf("Dispatch(%q, %s) = %q, %v, want %q, %v", tt.text, tt.role, reply, err, tt.reply, tt.err)
+		}
+	}
+
+	for _, text := range []string{"/alarm 1 2 3", "/tz", "/quota now"} {
+		if _, err := r.Dispatch(text, Admin, "data"); err == nil {
+			t.Errorf("%q is accepted", text)
+		} else if _, ok := err.(*UsageError); !ok {
+			t.Errorf("%q: err = %v, want a UsageError", text, err)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	r := NewRouter()
+	r.Register(Command{Name: "/tz"})
+	r.Register(Command{Name: "/quota", Role: Admin})
+	r.Register(Command{Name: "/alarm", Aliases: []string{"/a"}})
+
+	names := func(role Role) string {
+		s := []string{}
+		for _, c := range r.Commands(role) {
+			s = append(s, c.Name)
+		}
+		return strings.Join(s, " ")
+	}
+	if got := names(Anyone); got != "/alarm /tz" {
+		t.Errorf("anyone may run %s", got)
+	}
+	if got := names(Admin); got != "/alarm /quota /tz" {
+		t.Errorf("admin may run %s", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("an alias registered twice does not panic")
+		}
+	}()
+	r.Register(Command{Name: "/A"})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"awake-bot/command"
+	"awake-bot/i18n"
+	"awake-bot/store"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+var commands = command.NewRouter()
+
+// what handlers get besides their arguments
+type commandContext struct {
+	event *linebot.Event
+	lc    *i18n.Locale
+}
+
+// /help lists the commands, so they are registered once the router exists
+func init() {
+	r := commands
+	r.Register(command.Command{Name: "/help", Aliases: []string{"/?", "/ヘルプ"}, Handler: onHelpCommand})
+	r.Register(command.Command{Name: "/id", Handler: handle(onIdCommand)})
+	r.Register(command.Command{Name: "/tz", Aliases: []string{"/timezone"}, MaxArgs: 1, Handler: handle(onTimeZoneCommand)})
+	r.Register(command.Command{Name: "/alarm", Aliases: []string{"/アラーム"}, MaxArgs: 3, Handler: handle(onAlarmCommand)})
+	r.Register(command.Command{Name: "/nap", Aliases: []string{"/昼寝"}, MaxArgs: 1, Handler: handle(onNapCommand)})
+	r.Register(command.Command{Name: "/timer", Aliases: []string{"/タイマー"}, MinArgs: 1, MaxArgs: -1, Handler: handle(onTimerCommand)})
+	r.Register(command.Command{Name: "/remind", Aliases: []string{"/リマインド"}, MinArgs: 2, MaxArgs: -1, Handler: handle(onRemindCommand)})
+	r.Register(command.Command{Name: "/timers", MaxArgs: 2, Handler: handle(onTimersCommand)})
+	r.Register(command.Command{Name: "/vacation", Aliases: []string{"/休暇"}, MaxArgs: 1, Handler: handle(onVacationCommand)})
+	r.Register(command.Command{Name: "/pause", Aliases: []string{"/一時停止"}, MaxArgs: 1, Handler: handle(onPauseCommand)})
+	r.Register(command.Command{Name: "/resume", Aliases: []string{"/再開"}, Handler: handle(onResumeCommand)})
+	r.Register(command.Command{Name: "/sleep", Aliases: []string{"/おやすみ"}, MaxArgs: 2, Handler: handle(onSleepCommand)})
+	r.Register(command.Command{Name: "/persona", Aliases: []string{"/キャラ"}, Role: command.Owner, MaxArgs: 1, Handler: handle(onPersonaCommand)})
+	r.Register(command.Command{Name: "/claim", Aliases: []string{"/オーナー"}, Handler: handle(onClaimCommand)})
+	r.Register(command.Command{Name: "/lang", Aliases: []string{"/language"}, MaxArgs: 1, Handler: handle(onLangCommand)})
+	r.Register(command.Command{Name: "/login", Handler: handle(onLoginCommand)})
+	r.Register(command.Command{Name: "/quota", Role: command.Admin, Handler: handle(onQuotaCommand)})
+}
+
+// adapts the handlers which only need the event and the locale
+func handle(f func(event *linebot.Event, args []string, lc *i18n.Locale) string) command.Handler {
+	return func(req *command.Request) string {
+		c := req.Data.(*commandContext)
+		return f(c.event, req.Args, c.lc)
+	}
+}
+
+// runs text as a command. false when it is not one.
+func runCommand(event *linebot.Event, text string) (string, bool) {
+	name, _, ok := command.Parse(text)
+	if !ok {
+		return "", false
+	}
+
+	_, lc := lookupUser(event.Source.UserID)
+	if _, ok := commands.Lookup(name); !ok {
+		return lc.T("command.unknown", "Name", name), true
+	}
+
+	reply, err := commands.Dispatch(text, userRole(event.Source), &commandContext{event, lc})
+	switch e := err.(type) {
+	case nil:
+		return reply, true
+	case *command.UsageError:
+		return lc.T("command.usage", "Usage", lc.T(helpKey(e.Command))), true
+	}
+	if err == command.ErrForbidden {
+		return lc.T("command.forbidden", "Name", name), true
+	}
+	return "", false
+}
+
+// what the sender may do in the chat. A group is owned by whoever ran /claim
+// first after the bot joined.
+func userRole(s *linebot.EventSource) command.Role {
+	if isAdmin(s.UserID) {
+		return command.Admin
+	}
+
+	roomId := sourceId(s)
+	if roomId == s.UserID {
+		return command.Owner
+	}
+	if s.UserID != "" && users.Room(roomId).Owner == s.UserID {
+		return command.Owner
+	}
+	return command.Anyone
+}
+
+// /claim makes the sender the owner of a group which has none
+func onClaimCommand(event *linebot.Event, args []string, lc *i18n.Locale) string {
+	userId := event.Source.UserID
+	roomId := sourceId(event.Source)
+	if roomId == userId {
+		return lc.T("claim.direct")
+	}
+	if userId == "" {
+		return lc.T("claim.no_user")
+	}
+
+	r, err := users.UpdateRoom(roomId, func(r *store.Room) {
+		if r.Owner == "" {
+			r.Owner = userId
+		}
+	})
+	if err != nil {
+		logger.Error("failed to save room owner", "room_id", roomId, "err", err)
+		return lc.T("save_failed")
+	}
+	if r.Owner != userId {
+		return lc.T("claim.taken")
+	}
+	logger.Info("room claimed", "room_id", roomId, "user_id", userId)
+	return lc.T("claim.done")
+}
+
+func helpKey(c *command.Command) string {
+	return "help." + strings.TrimPrefix(c.Name, "/")
+}
+
+// /help lists what the sender may run
+func onHelpCommand(req *command.Request) string {
+	lc := req.Data.(*commandContext).lc
+
+	lines := []string{lc.T("help.header")}
+	for _, c := range commands.Commands(req.Role) {
+		lines = append(lines, lc.T(helpKey(c)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// /id
+func onIdCommand(event *linebot.Event, args []string, lc *i18n.Locale) string {
+	return lc.T("id", "UserId", event.Source.UserID, "GroupId", event.Source.GroupID)
+}
+
+// /quota
+func onQuotaCommand(event *linebot.Event, args []string, lc *i18n.Locale) string {
+	u := budget.Usage()
+	return lc.T("quota.status", "Used", u.Used, "Limit", u.Limit,
+		"Pending", len(outgoing.Pending()), "Dead", len(outgoing.Dead()))
+}
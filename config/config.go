@@ -0,0 +1,457 @@
+// Package config loads the bot configuration from a TOML file and the
+// environment, and validates it as a whole.
+package config
+
+import (
+	"awake-bot/logging"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPath is read when AWAKE_BOT_CONFIG is not set. It may be missing.
+const DefaultPath = "awake-bot.toml"
+
+type Config struct {
+	Port            string        `toml:"port" env:"PORT"`
+	TimeZone        string        `toml:"timezone" env:"TZ"`
+	StateFile       string        `toml:"state_file" env:"AWAKE_BOT_STATE_FILE"`
+	StoreFile       string        `toml:"store_file" env:"AWAKE_BOT_STORE_FILE"`
+	ShutdownTimeout time.Duration `toml:"shutdown_timeout"`
+	Admins          []string      `toml:"admins" env:"AWAKE_BOT_ADMINS"` // user ids allowed to run admin commands
+
+	Line      LineConfig      `toml:"line"`
+	Push      PushConfig      `toml:"push"`
+	Dashboard DashboardConfig `toml:"dashboard"`
+	Web       WebConfig       `toml:"web"`
+	KeepAwake KeepAwakeConfig `toml:"keep_awake"`
+	Snooze    SnoozeConfig    `toml:"snooze"`
+	Alarm     AlarmConfig     `toml:"alarm"`
+	Persona   PersonaConfig   `toml:"persona"`
+	Locale    LocaleConfig    `toml:"locale"`
+	Quota     QuotaConfig     `toml:"quota"`
+	Outbox    OutboxConfig    `toml:"outbox"`
+	Webhook   WebhookConfig   `toml:"webhook"`
+	Stream    StreamConfig    `toml:"stream"`
+	Sleep     SleepConfig     `toml:"sleep"`
+	Forecast  ForecastConfig  `toml:"forecast"`
+	Log       LogConfig       `toml:"log"`
+}
+
+type LineConfig struct {
+	ChannelSecret string `toml:"channel_secret" env:"LINE_CHANNEL_SECRET" secret:"true"`
+	ChannelToken  string `toml:"channel_token" env:"LINE_CHANNEL_TOKEN" secret:"true"`
+	EndpointBase  string `toml:"endpoint_base" env:"LINE_ENDPOINT_BASE"`
+}
+
+type PushConfig struct {
+	Token string `toml:"token" env:"AWAKE_BOT_TOKEN" secret:"true"`
+}
+
+// the admin pages at /admin, disabled without a password
+type DashboardConfig struct {
+	User     string `toml:"user"`
+	Password string `toml:"password" env:"AWAKE_BOT_DASHBOARD_PASSWORD" secret:"true"`
+}
+
+// pages users open through a link the bot sends for /login
+type WebConfig struct {
+	BaseURL    string        `toml:"base_url" env:"AWAKE_BOT_BASE_URL"`               // where users reach the bot, /login is disabled without it
+	Secret     string        `toml:"secret" env:"AWAKE_BOT_WEB_SECRET" secret:"true"` // signs links and cookies, the channel secret when empty
+	LinkTTL    time.Duration `toml:"link_ttl"`
+	SessionTTL time.Duration `toml:"session_ttl"`
+}
+
+type KeepAwakeConfig struct {
+	IFTTTToken string `toml:"ifttt_token" env:"IFTTT_WEBHOOK_TOKEN" secret:"true"`
+	Delay      int    `toml:"delay"` // sec
+}
+
+type SnoozeConfig struct {
+	MaxRepeats    int     `toml:"max_repeats"`
+	AckPattern    string  `toml:"ack_pattern"`
+	Sticker       Sticker `toml:"sticker"`
+	GiveUpSticker Sticker `toml:"give_up_sticker"`
+	AwakeSticker  Sticker `toml:"awake_sticker"`
+}
+
+// for alarms users set with /alarm
+type AlarmConfig struct {
+	Timeout int `toml:"timeout"` // sec
+}
+
+// message catalogs, see personas/<locale>/
+type PersonaConfig struct {
+	Dir     string `toml:"dir"`
+	Default string `toml:"default"`
+}
+
+// see locales/
+type LocaleConfig struct {
+	Dir     string `toml:"dir"`
+	Default string `toml:"default"` // for users whose LINE language is not available
+}
+
+// monthly message quota of the LINE plan
+type QuotaConfig struct {
+	AdminRoom     string        `toml:"admin_room" env:"AWAKE_BOT_ADMIN_ROOM"` // warned at thresholds
+	Thresholds    []float64     `toml:"thresholds"`
+	CutStickersAt float64       `toml:"cut_stickers_at"` // no sticker-only pushes from this ratio
+	SyncInterval  time.Duration `toml:"sync_interval"`
+	ReplyTokenTTL time.Duration `toml:"reply_token_ttl"` // how long a reply token is used instead of a push
+}
+
+// pushes waiting to be retried
+type OutboxConfig struct {
+	File        string        `toml:"file" env:"AWAKE_BOT_OUTBOX_FILE"`
+	Rate        float64       `toml:"rate"` // pushes per second
+	Burst       int           `toml:"burst"`
+	MaxAttempts int           `toml:"max_attempts"`
+	BaseBackoff time.Duration `toml:"base_backoff"` // doubled on every attempt
+	MaxBackoff  time.Duration `toml:"max_backoff"`
+	MaxAge      time.Duration `toml:"max_age"` // older messages are given up on
+	Timeout     time.Duration `toml:"timeout"` // per attempt
+}
+
+// events from LINE are queued and handled by workers, in order per chat
+type WebhookConfig struct {
+	Workers     int           `toml:"workers"`
+	QueueSize   int           `toml:"queue_size"`   // per worker, LINE is asked to redeliver when full
+	DedupWindow time.Duration `toml:"dedup_window"` // redelivered events are dropped within this
+}
+
+// session events for the dashboard, served at /api/v1/events
+type StreamConfig struct {
+	Buffer       int           `toml:"buffer"`        // events kept for clients resuming with Last-Event-ID
+	ClientBuffer int           `toml:"client_buffer"` // events waiting for a client before it is dropped
+	Heartbeat    time.Duration `toml:"heartbeat"`
+}
+
+// bedtimes told by おやすみ or /sleep, and the sleep until the wake-up reply
+type SleepConfig struct {
+	Target      time.Duration `toml:"target"`       // a night, users set their own with /sleep target
+	BedPattern  string        `toml:"bed_pattern"`  // text telling the bot the user goes to bed
+	BedStickers []Sticker     `toml:"bed_stickers"` // stickers telling it the same
+	Nights      int           `toml:"nights"`       // kept per user
+	Remind      bool          `toml:"remind"`       // tell users still chatting past their bedtime
+	Onset       time.Duration `toml:"onset"`        // from bed to asleep
+	Cycle       time.Duration `toml:"cycle"`        // alarms with a window ring at the end of one
+	MaxWindow   time.Duration `toml:"max_window"`   // users choose theirs up to this
+}
+
+type ForecastConfig struct {
+	City int `toml:"city"`
+
+	// where the sun rises for cities not in forecast.Cities
+	Latitude  float64 `toml:"latitude"`
+	Longitude float64 `toml:"longitude"`
+}
+
+type LogConfig struct {
+	Level  string `toml:"level" env:"LOG_LEVEL"`
+	Format string `toml:"format" env:"LOG_FORMAT"`
+	Redact string `toml:"redact" env:"LOG_REDACT"`
+}
+
+// Sticker is written as "packageId/stickerId".
+type Sticker struct {
+	PackageId string
+	StickerId string
+}
+
+func (s *Sticker) UnmarshalText(b []byte) error {
+	parts := strings.Split(string(b), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("sticker must be packageId/stickerId, got %q", string(b))
+	}
+	s.PackageId, s.StickerId = parts[0], parts[1]
+	return nil
+}
+
+func (s Sticker) String() string {
+	return s.PackageId + "/" + s.StickerId
+}
+
+// Default returns the values the bot has always used.
+func Default() *Config {
+	return &Config{
+		TimeZone:        "Asia/Tokyo",
+		StateFile:       "sessions.json",
+		StoreFile:       "users.json",
+		ShutdownTimeout: 25 * time.Second, // Heroku kills the dyno 30 sec after SIGTERM
+		Dashboard:       DashboardConfig{User: "admin"},
+		Web:             WebConfig{LinkTTL: 10 * time.Minute, SessionTTL: 7 * 24 * time.Hour},
+		KeepAwake:       KeepAwakeConfig{Delay: 1200},
+		Snooze: SnoozeConfig{
+			MaxRepeats:    5,
+			AckPattern:    `^おはよ.`,
+			Sticker:       Sticker{"11537", "52002744"},
+			GiveUpSticker: Sticker{"3", "193"},
+			AwakeSticker:  Sticker{"11537", "52002764"},
+		},
+		Alarm:    AlarmConfig{Timeout: 300},
+		Persona:  PersonaConfig{Dir: "personas", Default: "tsundere"},
+		Locale:   LocaleConfig{Dir: "locales", Default: "ja"},
+		Forecast: ForecastConfig{City: 130010, Latitude: 35.6895, Longitude: 139.6917}, // tokyo
+		Log:      LogConfig{Level: "info", Format: "logfmt", Redact: logging.DefaultRules},
+		Quota: QuotaConfig{
+			Thresholds:    []float64{0.5, 0.8, 0.9, 1},
+			CutStickersAt: 0.9,
+			SyncInterval:  time.Hour,
+			ReplyTokenTTL: 50 * time.Second, // LINE accepts them for about a minute
+		},
+		Outbox: OutboxConfig{
+			File:        "outbox.json",
+			Rate:        10,
+			Burst:       20,
+			MaxAttempts: 8,
+			BaseBackoff: time.Second,
+			MaxBackoff:  5 * time.Minute,
+			MaxAge:      30 * time.Minute, // a wake-up call an hour late is no use
+			Timeout:     10 * time.Second,
+		},
+		Webhook: WebhookConfig{Workers: 4, QueueSize: 100, DedupWindow: 10 * time.Minute},
+		Stream:  StreamConfig{Buffer: 500, ClientBuffer: 64, Heartbeat: 15 * time.Second},
+		Sleep: SleepConfig{
+			Target:      7 * time.Hour,
+			BedPattern:  `^(おやすみ|お休み|寝ます|ねます|[Gg]ood ?night)`,
+			BedStickers: []Sticker{{"11537", "52002771"}},
+			Nights:      30,
+			Remind:      true,
+			Onset:       15 * time.Minute,
+			Cycle:       90 * time.Minute,
+			MaxWindow:   time.Hour,
+		},
+	}
+}
+
+// Path returns the config file to read and whether it was asked for explicitly.
+func Path() (string, bool) {
+	if p := os.Getenv("AWAKE_BOT_CONFIG"); p != "" {
+		return p, true
+	}
+	return DefaultPath, false
+}
+
+// Load reads path over the defaults, then applies the environment.
+// A missing file is fine unless required.
+func Load(path string, required bool) (*Config, error) {
+	return load(path, required, os.LookupEnv)
+}
+
+func load(path string, required bool, lookupEnv func(string) (string, bool)) (*Config, error) {
+	c, errs := read(path, required, lookupEnv)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return c, nil
+}
+
+// Check loads path like Load and validates the result, reporting the
+// problems of both in one list.
+func Check(path string, required bool) (*Config, error) {
+	return check(path, required, os.LookupEnv)
+}
+
+func check(path string, required bool, lookupEnv func(string) (string, bool)) (*Config, error) {
+	c, errs := read(path, required, lookupEnv)
+	if err := c.Validate(); err != nil {
+		errs = append(errs, err.(Errors)...)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return c, nil
+}
+
+// read goes on past a bad file so that the rest is still reported.
+func read(path string, required bool, lookupEnv func(string) (string, bool)) (*Config, Errors) {
+	c := Default()
+	errs := Errors{}
+
+	f, err := os.Open(path)
+	switch {
+	case err == nil:
+		values, err := parseTOML(f)
+		f.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", path, err))
+		} else {
+			errs = append(errs, decodeFile(c, values)...)
+		}
+	case !os.IsNotExist(err) || required:
+		errs = append(errs, err)
+	}
+
+	errs = append(errs, decodeEnv(c, lookupEnv)...)
+	return c, errs
+}
+
+// Errors reports every problem at once.
+type Errors []error
+
+func (e Errors) Error() string {
+	s := []string{}
+	for _, err := range e {
+		s = append(s, err.Error())
+	}
+	return strings.Join(s, "\n")
+}
+
+// Validate checks the whole configuration and returns every problem found.
+func (c *Config) Validate() error {
+	errs := Errors{}
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Port == "" {
+		add("port: must be set (PORT)")
+	} else if p, err := strconv.Atoi(c.Port); err != nil || p < 0 || p > 65535 {
+		add("port: invalid port %q", c.Port)
+	}
+	if _, err := time.LoadLocation(c.TimeZone); err != nil {
+		add("timezone: %s", err)
+	}
+	if c.StateFile == "" {
+		add("state_file: must be set")
+	}
+	if c.StoreFile == "" {
+		add("store_file: must be set")
+	}
+	if c.ShutdownTimeout <= 0 {
+		add("shutdown_timeout: must be positive")
+	}
+
+	if c.Line.ChannelSecret == "" {
+		add("line.channel_secret: must be set (LINE_CHANNEL_SECRET)")
+	}
+	if c.Line.ChannelToken == "" {
+		add("line.channel_token: must be set (LINE_CHANNEL_TOKEN)")
+	}
+	if c.Line.EndpointBase != "" {
+		if _, err := url.ParseRequestURI(c.Line.EndpointBase); err != nil {
+			add("line.endpoint_base: %s", err)
+		}
+	}
+	if c.Push.Token == "" {
+		add("push.token: must be set (AWAKE_BOT_TOKEN)")
+	}
+	if c.Dashboard.Password != "" && c.Dashboard.User == "" {
+		add("dashboard.user: must be set with a password")
+	}
+	if c.Web.BaseURL != "" {
+		if u, err := url.ParseRequestURI(c.Web.BaseURL); err != nil {
+			add("web.base_url: %s", err)
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			add("web.base_url: must be an http or https URL")
+		}
+	}
+	if c.Web.LinkTTL <= 0 || c.Web.SessionTTL <= 0 {
+		add("web.link_ttl and web.session_ttl: must be positive")
+	}
+	if c.KeepAwake.Delay <= 0 {
+		add("keep_awake.delay: must be positive")
+	}
+
+	if c.Snooze.MaxRepeats < 0 {
+		add("snooze.max_repeats: must not be negative")
+	}
+	if _, err := regexp.Compile(c.Snooze.AckPattern); err != nil {
+		add("snooze.ack_pattern: %s", err)
+	}
+	if c.Alarm.Timeout <= 0 {
+		add("alarm.timeout: must be positive")
+	}
+	if c.Persona.Dir == "" || c.Persona.Default == "" {
+		add("persona.dir and persona.default: must be set")
+	}
+	if c.Locale.Dir == "" || c.Locale.Default == "" {
+		add("locale.dir and locale.default: must be set")
+	}
+	for _, t := range c.Quota.Thresholds {
+		if t <= 0 {
+			add("quota.thresholds: must be positive ratios like 0.8")
+			break
+		}
+	}
+	if c.Quota.SyncInterval <= 0 {
+		add("quota.sync_interval: must be positive")
+	}
+	if c.Quota.ReplyTokenTTL < 0 {
+		add("quota.reply_token_ttl: must not be negative")
+	}
+	if c.Outbox.File == "" {
+		add("outbox.file: must be set")
+	}
+	if c.Outbox.Rate < 0 {
+		add("outbox.rate: must not be negative")
+	}
+	if c.Outbox.MaxAttempts <= 0 {
+		add("outbox.max_attempts: must be positive")
+	}
+	if c.Outbox.BaseBackoff <= 0 || c.Outbox.MaxBackoff < c.Outbox.BaseBackoff {
+		add("outbox.base_backoff and outbox.max_backoff: must be positive, max_backoff not below base_backoff")
+	}
+	if c.Outbox.MaxAge < 0 || c.Outbox.Timeout < 0 {
+		add("outbox.max_age and outbox.timeout: must not be negative")
+	}
+	if c.Webhook.Workers <= 0 || c.Webhook.QueueSize <= 0 {
+		add("webhook.workers and webhook.queue_size: must be positive")
+	}
+	if c.Webhook.DedupWindow <= 0 {
+		add("webhook.dedup_window: must be positive")
+	}
+	if c.Stream.Buffer <= 0 || c.Stream.ClientBuffer <= 0 {
+		add("stream.buffer and stream.client_buffer: must be positive")
+	}
+	if c.Stream.Heartbeat <= 0 {
+		add("stream.heartbeat: must be positive")
+	}
+	if c.Sleep.Target < time.Hour || c.Sleep.Target > 12*time.Hour {
+		add("sleep.target: must be between 1h and 12h")
+	}
+	if _, err := regexp.Compile(c.Sleep.BedPattern); err != nil {
+		add("sleep.bed_pattern: %s", err)
+	}
+	if c.Sleep.Nights <= 0 {
+		add("sleep.nights: must be positive")
+	}
+	if c.Sleep.Onset < 0 || c.Sleep.Cycle <= 0 || c.Sleep.MaxWindow <= 0 {
+		add("sleep.onset, sleep.cycle and sleep.max_window: must be positive")
+	}
+	if c.Forecast.City <= 0 {
+		add("forecast.city: must be a city code")
+	}
+	if c.Forecast.Latitude < -90 || c.Forecast.Latitude > 90 || c.Forecast.Longitude < -180 || c.Forecast.Longitude > 180 {
+		add("forecast.latitude and forecast.longitude: must be degrees")
+	}
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		add("log.level: %s", err)
+	}
+	if _, err := logging.ParseFormat(c.Log.Format); err != nil {
+		add("log.format: %s", err)
+	}
+	if _, err := logging.ParseRules(c.Log.Redact); err != nil {
+		add("log.redact: %s", err)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Location returns the default timezone. Validate first.
+func (c *Config) Location() *time.Location {
+	loc, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func write(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func env(m map[string]string) func(string) (string, bool) {
+	return func(k string) (string, bool) {
+		v, ok := m[k]
+		return v, ok
+	}
+}
+
+func TestLoad(t *testing.T) {
+	secret := write(t, "secret", "file-secret\n")
+	path := write(t, "awake-bot.toml", `
+port = "5000"
+timezone = "Asia/Singapore" # comment
+shutdown_timeout = "10s"
+
+[line]
+channel_secret_file = "`+secret+`"
+channel_token = "file-token"
+
+[snooze]
+max_repeats = 3
+sticker = "1/2"
+ack_pattern = "^(おはよ|起きた)"
+`)
+
+	c, err := load(path, true, env(map[string]string{
+		"LINE_CHANNEL_TOKEN": "env-token",
+		"AWAKE_BOT_TOKEN":    "push-token",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Port != "5000" || c.TimeZone != "Asia/Singapore" || c.ShutdownTimeout != 10*time.Second {
+		t.Errorf("top level = %+v", c)
+	}
+	if c.Line.ChannelSecret != "file-secret" {
+		t.Errorf("secret file is not read: %q", c.Line.ChannelSecret)
+	}
+	if c.Line.ChannelToken != "env-token" {
+		t.Errorf("env does not override the file: %q", c.Line.ChannelToken)
+	}
+	if c.Snooze.MaxRepeats != 3 || c.Snooze.Sticker != (Sticker{"1", "2"}) || c.Snooze.AckPattern != "^(おはよ|起きた)" {
+		t.Errorf("snooze = %+v", c.Snooze)
+	}
+	if c.Snooze.GiveUpSticker != (Sticker{"3", "193"}) || c.Forecast.City != 130010 {
+		t.Error("defaults are lost")
+	}
+	if err := c.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadSecretFromEnvFile(t *testing.T) {
+	secret := write(t, "token", "token-from-file")
+
+	c, err := load("missing.toml", false, env(map[string]string{"AWAKE_BOT_TOKEN_FILE": secret}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Push.Token != "token-from-file" {
+		t.Errorf("token = %q", c.Push.Token)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	path := write(t, "awake-bot.toml", `
+porrt = "5000"
+[snooze]
+max_repeats = "five"
+sticker = "11537"
+`)
+
+	_, err := load(path, true, env(nil))
+	if err == nil {
+		t.Fatal("invalid config is accepted")
+	}
+
+	for _, s := range []string{"porrt: unknown key", "snooze.max_repeats", "snooze.sticker"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("%q is not reported in\n%s", s, err)
+		}
+	}
+}
+
+func TestCheckReportsLoadAndValidateProblems(t *testing.T) {
+	path := write(t, "awake-bot.toml", `
+timezone = "Mars/Olympus"
+[line]
+channel_secret_file = "/no/such/secret"
+`)
+
+	_, err := check(path, true, env(nil))
+	if err == nil {
+		t.Fatal("invalid config is accepted")
+	}
+	for _, s := range []string{"line.channel_secret_file", "timezone", "push.token"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("%q is not reported in\n%s", s, err)
+		}
+	}
+}
+
+func TestCheckExample(t *testing.T) {
+	if _, err := check("../awake-bot.example.toml", true, env(nil)); err != nil {
+		t.Errorf("the example is invalid:\n%s", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.TimeZone = "Mars/Olympus"
+	c.Snooze.AckPattern = "("
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("invalid config is accepted")
+	}
+
+	errs := err.(Errors)
+	// port, timezone, channel secret and token, push token and ack pattern
+	if len(errs) != 6 {
+		t.Errorf("%d errors:\n%s", len(errs), err)
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	values, err := parseTOML(strings.NewReader(`
+a = "x # not a comment"
+b = 'C:\path'
+c = [1, 2_000, "three"]
+[t]
+d = 1.5
+e = false
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if values["a"] != "x # not a comment" || values["b"] != `C:\path` || values["t.d"] != 1.5 || values["t.e"] != false {
+		t.Errorf("values = %v", values)
+	}
+	if c := values["c"].([]interface{}); len(c) != 3 || c[1] != int64(2000) || c[2] != "three" {
+		t.Errorf("c = %v", c)
+	}
+
+	for _, bad := range []string{"a", "a = ", "a = [1,", "[t", "a = 1\na = 2"} {
+		if _, err := parseTOML(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q is accepted", bad)
+		}
+	}
+}
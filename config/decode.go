@@ -0,0 +1,232 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// field is a settable config value found by walking the struct tags.
+type field struct {
+	key    string // toml key with its table prefix
+	env    string
+	secret bool
+	value  reflect.Value
+}
+
+func fields(v reflect.Value, prefix string) []field {
+	r := []field{}
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := f.Tag.Get("toml")
+		if key == "" {
+			continue
+		}
+
+		fv := v.Field(i)
+		if f.Type.Kind() == reflect.Struct && !reflect.PtrTo(f.Type).Implements(textUnmarshalerType) {
+			r = append(r, fields(fv, prefix+key+".")...)
+			continue
+		}
+
+		r = append(r, field{prefix + key, f.Tag.Get("env"), f.Tag.Get("secret") == "true", fv})
+	}
+
+	return r
+}
+
+func decodeFile(c *Config, values map[string]interface{}) []error {
+	errs := []error{}
+	known := map[string]bool{}
+
+	for _, f := range fields(reflect.ValueOf(c).Elem(), "") {
+		known[f.key] = true
+
+		if f.secret {
+			known[f.key+"_file"] = true
+			if path, ok := values[f.key+"_file"]; ok {
+				s, err := readSecret(fmt.Sprint(path))
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s_file: %s", f.key, err))
+					continue
+				}
+				values[f.key] = s
+			}
+		}
+
+		v, ok := values[f.key]
+		if !ok {
+			continue
+		}
+		if err := set(f.value, v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", f.key, err))
+		}
+	}
+
+	unknown := []string{}
+	for k := range values {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	for _, k := range unknown {
+		errs = append(errs, fmt.Errorf("%s: unknown key", k))
+	}
+
+	return errs
+}
+
+// decodeEnv applies env overrides. Secrets can also be read from the file
+// named by the variable with a _FILE suffix.
+func decodeEnv(c *Config, lookupEnv func(string) (string, bool)) []error {
+	errs := []error{}
+
+	for _, f := range fields(reflect.ValueOf(c).Elem(), "") {
+		if f.env == "" {
+			continue
+		}
+
+		s, ok := lookupEnv(f.env)
+		if f.secret {
+			if path, fok := lookupEnv(f.env + "_FILE"); fok && !ok {
+				secret, err := readSecret(path)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s_FILE: %s", f.env, err))
+					continue
+				}
+				s, ok = secret, true
+			}
+		}
+		if !ok || s == "" {
+			continue
+		}
+
+		if err := set(f.value, s); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", f.env, err))
+		}
+	}
+
+	return errs
+}
+
+func readSecret(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// set assigns a parsed TOML value, or a string from the environment, to v.
+func set(v reflect.Value, x interface{}) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		s, ok := x.(string)
+		if !ok {
+			return fmt.Errorf("expected a string")
+		}
+		return u.UnmarshalText([]byte(s))
+	}
+
+	if v.Type() == durationType {
+		switch x := x.(type) {
+		case string:
+			d, err := time.ParseDuration(x)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(d))
+		case int64:
+			v.SetInt(x * int64(time.Second))
+		default:
+			return fmt.Errorf("expected a duration like \"25s\"")
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		s, ok := x.(string)
+		if !ok {
+			return fmt.Errorf("expected a string")
+		}
+		v.SetString(s)
+	case reflect.Int, reflect.Int64:
+		switch x := x.(type) {
+		case int64:
+			v.SetInt(x)
+		case string:
+			i, err := strconv.ParseInt(x, 10, 64)
+			if err != nil {
+				return fmt.Errorf("expected an integer")
+			}
+			v.SetInt(i)
+		default:
+			return fmt.Errorf("expected an integer")
+		}
+	case reflect.Float64:
+		switch x := x.(type) {
+		case float64:
+			v.SetFloat(x)
+		case int64:
+			v.SetFloat(float64(x))
+		case string:
+			f, err := strconv.ParseFloat(x, 64)
+			if err != nil {
+				return fmt.Errorf("expected a number")
+			}
+			v.SetFloat(f)
+		default:
+			return fmt.Errorf("expected a number")
+		}
+	case reflect.Bool:
+		switch x := x.(type) {
+		case bool:
+			v.SetBool(x)
+		case string:
+			b, err := strconv.ParseBool(x)
+			if err != nil {
+				return fmt.Errorf("expected true or false")
+			}
+			v.SetBool(b)
+		default:
+			return fmt.Errorf("expected true or false")
+		}
+	case reflect.Slice:
+		items, ok := x.([]interface{})
+		if !ok {
+			// comma separated in the environment
+			s, sok := x.(string)
+			if !sok {
+				return fmt.Errorf("expected an array")
+			}
+			items = []interface{}{}
+			for _, item := range strings.Split(s, ",") {
+				items = append(items, strings.TrimSpace(item))
+			}
+		}
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := set(slice.Index(i), item); err != nil {
+				return fmt.Errorf("[%d]: %s", i, err)
+			}
+		}
+		v.Set(slice)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// parseTOML reads the subset of TOML the config file needs: [tables],
+// key = value pairs with strings, numbers, booleans and one-line arrays.
+// Keys are returned with their table prefix, e.g. "line.channel_token".
+func parseTOML(r io.Reader) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	table := ""
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: invalid table %s", n, line)
+			}
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+
+		key := strings.Trim(strings.TrimSpace(kv[0]), `"`)
+		if table != "" {
+			key = table + "." + key
+		}
+		if _, dup := values[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %s", n, key)
+		}
+
+		v, err := parseValue(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %s", n, key, err)
+		}
+		values[key] = v
+	}
+
+	return values, scanner.Err()
+}
+
+// removes a # comment which is not inside a string
+func stripComment(line string) string {
+	quote := rune(0)
+	for i, r := range line {
+		switch {
+		case quote != 0 && r == quote && (quote == '\'' || i == 0 || line[i-1] != '\\'):
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseValue(s string) (interface{}, error) {
+	switch {
+	case s == "":
+		return nil, fmt.Errorf("missing value")
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("unterminated string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case strings.HasPrefix(s, "["):
+		return parseArray(s)
+	}
+
+	n := strings.Replace(s, "_", "", -1)
+	if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(n, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("invalid value %s", s)
+}
+
+func parseArray(s string) ([]interface{}, error) {
+	if !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("arrays must be on one line")
+	}
+
+	items := []interface{}{}
+	body := strings.TrimSpace(s[1 : len(s)-1])
+
+	for body != "" {
+		item, rest := splitItem(body)
+		v, err := parseValue(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+		body = strings.TrimSpace(rest)
+	}
+
+	return items, nil
+}
+
+// splits the first comma separated item off, respecting quotes
+func splitItem(s string) (string, string) {
+	quote := rune(0)
+	for i, r := range s {
+		switch {
+		case quote != 0 && r == quote && (quote == '\'' || s[i-1] != '\\'):
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == ',':
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"awake-bot/outbox"
+	"awake-bot/timeout"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how many session outcomes the dashboard shows
+const maxOutcomes = 50
+
+var (
+	outcomesMu sync.Mutex
+	outcomes   []outcome // newest last
+)
+
+type outcome struct {
+	At      time.Time
+	RoomId  string
+	UserId  string
+	Result  string // acknowledged, escalated, cancelled or skipped
+	Snoozes int
+	Took    time.Duration // from the first prompt
+	Early   time.Duration // the smart alarm rang this long before the set time
+}
+
+// remembers how a session ended and tells the event stream, to is nil when
+// none was started
+func recordOutcome(to *timeout.Timeout, roomId, userId, result string) {
+	o := outcome{At: clk.Now(), RoomId: roomId, UserId: userId, Result: result}
+	if to != nil {
+		o.Snoozes = to.GetRepeated()
+		o.Took = o.At.Sub(to.StartedAt)
+		if wakeAt, latest := to.GetWakeWindow(); !wakeAt.IsZero() {
+			o.Early = latest.Sub(wakeAt)
+		}
+	}
+
+	if to != nil {
+		publishSession(to, result)
+	}
+
+	outcomesMu.Lock()
+	defer outcomesMu.Unlock()
+	outcomes = append(outcomes, o)
+	if len(outcomes) > maxOutcomes {
+		outcomes = outcomes[len(outcomes)-maxOutcomes:]
+	}
+}
+
+func recentOutcomes() []outcome {
+	outcomesMu.Lock()
+	defer outcomesMu.Unlock()
+
+	list := make([]outcome, len(outcomes))
+	for i, o := range outcomes {
+		list[len(outcomes)-1-i] = o
+	}
+	return list
+}
+
+// admins logged in through /login, or the dashboard user. Without a
+// password only the former get in.
+func dashboardAuth() gin.HandlerFunc {
+	basic := func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusNotFound)
+	}
+	if conf.Dashboard.Password != "" {
+		basic = gin.BasicAuth(gin.Accounts{conf.Dashboard.User: conf.Dashboard.Password})
+	}
+
+	return func(c *gin.Context) {
+		if isAdmin(sessionUser(c)) {
+			return
+		}
+		basic(c)
+	}
+}
+
+// forms are only accepted from the dashboard itself; the browser would send
+// the basic auth credentials along with a forged one too
+func sameOrigin(c *gin.Context) {
+	if c.Request.Method == http.MethodGet {
+		return
+	}
+
+	origin := c.Request.Header.Get("Origin")
+	if origin == "" {
+		origin = c.Request.Header.Get("Referer")
+	}
+	if u, err := url.Parse(origin); origin != "" && (err != nil || u.Host != c.Request.Host) {
+		requestLog(c).Warn("cross-origin form rejected", "origin", origin)
+		c.AbortWithStatus(http.StatusForbidden)
+	}
+}
+
+type sessionRow struct {
+	Id, RoomId, UserId, AlertRoomId string
+	Stage                           string
+	NextAt                          string
+}
+
+type scheduleRow struct {
+	UserId, TimeZone, Alarm, RoomId string
+	NextAt                          string
+	Paused                          bool
+}
+
+type outcomeRow struct {
+	At, RoomId, UserId, Result string
+	Snoozes                    int
+	Took, Early                string
+}
+
+type quotaView struct {
+	Used, Limit    int64
+	Percent        int
+	Pending, Dead  int
+	CutStickersAt  int
+	OverStickerCut bool
+}
+
+const dashboardTime = "01/02 15:04:05"
+
+func onDashboard(c *gin.Context) {
+	now := clk.Now()
+	loc := conf.Location()
+	lc := locales.Get("")
+
+	sessions := []sessionRow{}
+	for _, to := range listSessions() {
+		s := to.Snapshot()
+		max := userMaxRepeats(users.User(s.UserId))
+		stage := fmt.Sprintf("snooze %d/%d", s.Repeated, max)
+		if s.Repeated >= max {
+			stage = "giving up next"
+		}
+		sessions = append(sessions, sessionRow{
+			Id:          s.Id,
+			RoomId:      s.RoomId,
+			UserId:      s.UserId,
+			AlertRoomId: s.AlertRoomId,
+			Stage:       stage,
+			NextAt:      s.CheckpointedAt.Add(s.Remaining).In(loc).Format(dashboardTime),
+		})
+	}
+
+	schedules := []scheduleRow{}
+	for _, u := range users.Users() {
+		alarm, next := nextAlarm(u, now)
+		if alarm == nil {
+			continue
+		}
+		ul := userLocation(u)
+		alarmsMu.Lock()
+		_, armed := alarmTimers[u.Id]
+		alarmsMu.Unlock()
+		schedules = append(schedules, scheduleRow{
+			UserId:   u.Id,
+			TimeZone: ul.String(),
+			Alarm:    formatAlarm(lc, alarm),
+			RoomId:   alarmRoom(u, alarm),
+			NextAt:   next.In(loc).Format(dashboardTime),
+			Paused:   !armed,
+		})
+	}
+
+	recent := []outcomeRow{}
+	for _, o := range recentOutcomes() {
+		took := ""
+		if o.Took > 0 {
+			took = o.Took.Round(time.Second).String()
+		}
+		early := ""
+		if o.Early > 0 {
+			early = o.Early.String()
+		}
+		recent = append(recent, outcomeRow{
+			At:      o.At.In(loc).Format(dashboardTime),
+			RoomId:  o.RoomId,
+			UserId:  o.UserId,
+			Result:  o.Result,
+			Snoozes: o.Snoozes,
+			Took:    took,
+			Early:   early,
+		})
+	}
+
+	u := budget.Usage()
+	q := quotaView{
+		Used:          u.Used,
+		Limit:         u.Limit,
+		Percent:       int(u.Ratio() * 100),
+		Pending:       len(outgoing.Pending()),
+		Dead:          len(outgoing.Dead()),
+		CutStickersAt: int(conf.Quota.CutStickersAt * 100),
+	}
+	q.OverStickerCut = u.Limit > 0 && u.Ratio() >= conf.Quota.CutStickersAt
+
+	c.HTML(http.StatusOK, "dashboard.tmpl.html", gin.H{
+		"Page":      "dashboard",
+		"Admin":     true,
+		"UserId":    sessionUser(c),
+		"Now":       now.In(loc).Format(dashboardTime),
+		"TimeZone":  loc.String(),
+		"Sessions":  sessions,
+		"Schedules": schedules,
+		"Outcomes":  recent,
+		"Quota":     q,
+		"Message":   c.Query("message"),
+	})
+}
+
+// back to the dashboard, telling what happened
+func redirectToDashboard(c *gin.Context, message string) {
+	c.Redirect(http.StatusSeeOther, "/admin?message="+url.QueryEscape(message))
+}
+
+func onDashboardCancel(c *gin.Context) {
+	roomId := c.Param("roomId")
+	to, ok := getSession(roomId)
+	if !ok {
+		redirectToDashboard(c, "no session in "+roomId)
+		return
+	}
+
+	to.Stop()
+	deleteSession(roomId)
+	recordOutcome(to, roomId, to.GetMonitoringUserId(), "cancelled")
+	requestLog(c).Info("session cancelled from the dashboard", "session_id", to.Id)
+	redirectToDashboard(c, "cancelled the session in "+roomId)
+}
+
+func onDashboardSnooze(c *gin.Context) {
+	roomId := c.Param("roomId")
+	to, ok := getSession(roomId)
+	if !ok {
+		redirectToDashboard(c, "no session in "+roomId)
+		return
+	}
+
+	requestLog(c).Info("snoozing now from the dashboard", "session_id", to.Id)
+	to.FireNow()
+	redirectToDashboard(c, "snoozed "+roomId)
+}
+
+func onDashboardTestPush(c *gin.Context) {
+	roomId := c.PostForm("room_id")
+	if roomId == "" {
+		redirectToDashboard(c, "room_id is missing")
+		return
+	}
+
+	text := locales.Get("").T("admin.test_push", "Time", clk.Now().In(conf.Location()).Format(dashboardTime))
+	switch err := send(roomId, outbox.Normal, newTextMessage(text)); {
+	case err == outbox.ErrQueued:
+		redirectToDashboard(c, "test push to "+roomId+" is queued for a retry")
+	case err != nil:
+		requestLog(c).Error("failed to push test message", "room_id", roomId, "err", err)
+		redirectToDashboard(c, "test push to "+roomId+" failed: "+err.Error())
+	default:
+		redirectToDashboard(c, "test push sent to "+roomId)
+	}
+}
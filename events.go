@@ -0,0 +1,88 @@
+package main
+
+import (
+	"awake-bot/logging"
+	"awake-bot/persona"
+	"awake-bot/store"
+	"errors"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// errUnreachable is returned by send for chats which blocked or removed the bot.
+var errUnreachable = errors.New("the chat blocked or removed the bot")
+
+// added as a friend, or unblocked
+func onFollow(l *logging.Logger, event *linebot.Event) {
+	userId := event.Source.UserID
+	setReachable(l, userId, true)
+
+	// remembered from now on, and alarms paused by a block ring again
+	u, err := users.UpdateUser(userId, func(*store.User) {})
+	if err != nil {
+		l.Error("failed to save user", "err", err)
+	}
+	scheduleAlarm(u)
+
+	// the profile may have changed while the bot was blocked
+	profiles.Forget(userId)
+	name, lc := lookupUser(userId)
+	p := roomPersona(userId, lc)
+	bot.ReplyMessage(event.ReplyToken,
+		newTextMessage(say(p, "hello", persona.Vars{Name: name})),
+		newTextMessage(lc.T("follow.welcome", "TimeZone", userLocation(u))),
+	).Do()
+}
+
+// blocked by the user
+func onUnfollow(l *logging.Logger, event *linebot.Event) {
+	userId := event.Source.UserID
+	setReachable(l, userId, false)
+	scheduleAlarm(users.User(userId))
+}
+
+// invited to a group or a multi-person chat
+func onJoin(l *logging.Logger, event *linebot.Event) {
+	roomId := sourceId(event.Source)
+	setReachable(l, roomId, true)
+	scheduleAlarms()
+
+	lc := locales.Get("")
+	p := roomPersona(roomId, lc)
+	bot.ReplyMessage(event.ReplyToken,
+		newTextMessage(say(p, "hello", persona.Vars{})),
+		newTextMessage(lc.T("join.intro")),
+	).Do()
+}
+
+// removed from a group or a multi-person chat
+func onLeave(l *logging.Logger, event *linebot.Event) {
+	roomId := sourceId(event.Source)
+	setReachable(l, roomId, false)
+	scheduleAlarms()
+}
+
+// marks the chat, and stops a session in it which could only push into the
+// void. A group the bot is invited to again can be claimed anew.
+func setReachable(l *logging.Logger, roomId string, reachable bool) {
+	_, err := users.UpdateRoom(roomId, func(r *store.Room) {
+		r.Unreachable = !reachable
+		if !reachable {
+			r.Owner = ""
+		}
+	})
+	if err != nil {
+		l.Error("failed to save room", "room_id", roomId, "err", err)
+	}
+	if reachable {
+		return
+	}
+
+	takeReplyToken(roomId)
+	if to, ok := getSession(roomId); ok {
+		to.Stop()
+		deleteSession(roomId)
+		recordOutcome(to, roomId, to.GetMonitoringUserId(), "cancelled")
+		l.Info("snooze cancelled. the chat is unreachable.", "session_id", to.Id)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"awake-bot/stream"
+	"awake-bot/timeout"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// session events for /api/v1/events
+var feed *stream.Broker
+
+func startFeed() {
+	feed = stream.NewBroker(conf.Stream.Buffer)
+}
+
+// tells the followers of /api/v1/events what happened to the session
+func publishSession(to *timeout.Timeout, typ string) {
+	feed.Publish(stream.Event{
+		Type:      typ,
+		At:        clk.Now(),
+		RoomId:    to.RoomId,
+		UserId:    to.GetMonitoringUserId(),
+		SessionId: to.Id,
+		Repeated:  to.GetRepeated(),
+	})
+}
+
+// GET /api/v1/events?room=: streams session events as Server-Sent Events.
+// EventSource sends the id of the last one it got when it reconnects, a
+// "reset" event tells it that some were lost on the way.
+func onEvents(c *gin.Context) {
+	l := requestLog(c)
+
+	lastId, _ := strconv.ParseInt(c.Request.Header.Get("Last-Event-ID"), 10, 64)
+	if lastId == 0 {
+		lastId, _ = strconv.ParseInt(c.Query("lastEventId"), 10, 64)
+	}
+	s := feed.Subscribe(lastId, c.Query("room"), conf.Stream.ClientBuffer)
+	defer s.Close()
+
+	streamClients.Add(1)
+	defer streamClients.Add(-1)
+
+	h := c.Writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no") // for proxies which would hold it back
+	c.Writer.WriteHeader(http.StatusOK)
+	c.Writer.WriteHeaderNow()
+
+	w := c.Writer
+	if s.Gap {
+		sse.Encode(w, sse.Event{Event: "reset", Id: strconv.FormatInt(feed.LastId(), 10), Data: "events were missed"})
+	}
+	for _, e := range s.Backlog {
+		writeEvent(w, e)
+	}
+	w.Flush()
+
+	heartbeat := time.NewTicker(conf.Stream.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case e, ok := <-s.C:
+			if !ok {
+				if s.Dropped() {
+					l.Warn("event stream client fell behind")
+					streamDropped.Inc()
+				}
+				return
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			// a comment, keeping proxies from timing the connection out
+			if _, err := io.WriteString(w, ":\n\n"); err != nil {
+				return
+			}
+		case <-c.Request.Context().Done():
+			return
+		}
+		w.Flush()
+	}
+}
+
+func writeEvent(w io.Writer, e stream.Event) error {
+	return sse.Encode(w, sse.Event{Id: strconv.FormatInt(e.Id, 10), Event: e.Type, Data: e})
+}
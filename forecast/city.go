@@ -0,0 +1,39 @@
+package forecast
+
+// City is a place forecasts are published for.
+type City struct {
+	Code     int
+	Name     string
+	Lat, Lon float64 // for the sunrise
+}
+
+// the primary cities of the forecast service, which users pick from
+var Cities = []City{
+	{16010, "札幌", 43.0642, 141.3469},
+	{40010, "仙台", 38.2682, 140.8694},
+	{130010, "東京", 35.6895, 139.6917},
+	{140010, "横浜", 35.4437, 139.6380},
+	{230010, "名古屋", 35.1815, 136.9066},
+	{260010, "京都", 35.0116, 135.7681},
+	{270000, "大阪", 34.6937, 135.5023},
+	{280010, "神戸", 34.6901, 135.1956},
+	{340010, "広島", 34.3853, 132.4553},
+	{400010, "福岡", 33.5904, 130.4017},
+	{471010, "那覇", 26.2124, 127.6809},
+}
+
+// CityName returns the name of a city in Cities.
+func CityName(code int) (string, bool) {
+	c, ok := FindCity(code)
+	return c.Name, ok
+}
+
+// FindCity looks a city up in Cities.
+func FindCity(code int) (City, bool) {
+	for _, c := range Cities {
+		if c.Code == code {
+			return c, true
+		}
+	}
+	return City{}, false
+}
@@ -1,8 +1,10 @@
 package forecast
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/antonholmquist/jason"
 )
@@ -18,28 +20,44 @@ const (
 	forecastEndpointURL = "http://weather.livedoor.com/forecast/webservice/json/v1?city="
 )
 
+// the forecast goes out with the morning message, so do not hold it for long
+var client = &http.Client{Timeout: 10 * time.Second}
+
 func Request(code int) []Forecast {
-	res, err := http.Get(forecastEndpointURL + strconv.Itoa(code))
+	r, err := Fetch(code)
 	if err != nil {
 		panic(err)
 	}
 
+	return r
+}
+
+// Fetch is Request returning errors instead of panicking.
+func Fetch(code int) ([]Forecast, error) {
+	res, err := client.Get(forecastEndpointURL + strconv.Itoa(code))
+	if err != nil {
+		return nil, err
+	}
+
 	defer res.Body.Close()
 
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("forecast: unexpected status %s", res.Status)
+	}
+
 	json, err := jason.NewObjectFromReader(res.Body)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	forecasts, err := json.GetObjectArray("forecasts")
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	r := []Forecast{}
 
 	for _, v := range forecasts {
-		print(v)
 		date, _ := v.GetString("dateLabel")
 		telop, _ := v.GetString("telop")
 		max, _ := v.GetString("temperature", "max", "celsius")
@@ -47,5 +65,5 @@ func Request(code int) []Forecast {
 		r = append(r, Forecast{date, telop, max, min})
 	}
 
-	return r
+	return r, nil
 }
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"awake-bot/i18n"
+	"awake-bot/profile"
+	"awake-bot/schedule"
+	"awake-bot/store"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// names and languages are looked up for most replies, and rarely change
+const profileTTL = time.Hour
+
+var (
+	locales  *i18n.Bundle
+	profiles *profile.Cache
+)
+
+// the user's display name, empty when the profile is not available, and the
+// locale to talk to them in: /lang, their LINE language or the default
+func lookupUser(userId string) (string, *i18n.Locale) {
+	name, lang := "", ""
+	if p, err := profiles.Get(userId, clk.Now()); err != nil {
+		logger.Warn("failed to get profile", "user_id", userId, "err", err)
+	} else {
+		name, lang = p.DisplayName, p.Language
+	}
+
+	if u := users.User(userId); u.Lang != "" {
+		lang = u.Lang
+	}
+	return name, locales.Get(lang)
+}
+
+// e.g. 10/20(火)
+func formatDate(lc *i18n.Locale, t time.Time) string {
+	return lc.T("date", "Month", int(t.Month()), "Day", t.Day(), "Weekday", lc.T("weekday."+t.Weekday().String()))
+}
+
+// e.g. 10/20(火) 7:00
+func formatDateTime(lc *i18n.Locale, t time.Time) string {
+	return formatDate(lc, t) + " " + schedule.Alarm{Hour: t.Hour(), Minute: t.Minute()}.Clock()
+}
+
+// e.g. あと 3 時間
+func formatUntil(lc *i18n.Locale, d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return lc.N("duration.minutes", int(math.Ceil(d.Minutes())))
+	case d < 48*time.Hour:
+		return lc.N("duration.hours", int(d.Hours()))
+	}
+	return lc.N("duration.days", int(d.Hours()/24))
+}
+
+func formatAlarm(lc *i18n.Locale, a *schedule.Alarm) string {
+	clock := a.Clock()
+	switch {
+	case a.Sun != "":
+		clock = formatSun(lc, a)
+	case a.Window > 0:
+		// 6:30-7:00
+		from := time.Date(2000, 1, 1, a.Hour, a.Minute-a.Window, 0, 0, time.UTC)
+		clock = schedule.Alarm{Hour: from.Hour(), Minute: from.Minute()}.Clock() + "-" + clock
+	}
+
+	switch {
+	case a.Once():
+		day, _ := time.Parse(schedule.DateLayout, a.Date)
+		return lc.T("alarm.once", "Date", formatDate(lc, day), "Clock", clock)
+	case len(a.Weekdays) == 0:
+		return lc.T("alarm.everyday", "Clock", clock)
+	case len(a.Weekdays) == 5 && a.SkipHolidays:
+		return lc.T("alarm.weekdays", "Clock", clock)
+	}
+
+	days := []string{}
+	for _, d := range a.Weekdays {
+		days = append(days, lc.T("weekday."+d.String()))
+	}
+	return lc.T("alarm.days", "Days", strings.Join(days, lc.T("weekdays.separator")), "Clock", clock)
+}
+
+// e.g. 日の出の30分前 (5:00〜6:30)
+func formatSun(lc *i18n.Locale, a *schedule.Alarm) string {
+	sun := lc.T("alarm." + a.Sun)
+	switch {
+	case a.Offset < 0:
+		sun = lc.T("alarm.sun_before", "Sun", sun, "Minutes", -a.Offset)
+	case a.Offset > 0:
+		sun = lc.T("alarm.sun_after", "Sun", sun, "Minutes", a.Offset)
+	}
+
+	switch {
+	case a.Earliest != "" && a.Latest != "":
+		sun += lc.T("alarm.sun_between", "Earliest", a.Earliest, "Latest", a.Latest)
+	case a.Earliest != "":
+		sun += lc.T("alarm.sun_not_before", "Clock", a.Earliest)
+	case a.Latest != "":
+		sun += lc.T("alarm.sun_not_after", "Clock", a.Latest)
+	}
+	if a.Window > 0 {
+		sun += lc.T("alarm.sun_window", "Minutes", a.Window)
+	}
+	return sun
+}
+
+// /lang [tag|auto]
+func onLangCommand(event *linebot.Event, args []string, lc *i18n.Locale) string {
+	tags := strings.Join(locales.Tags(), ", ")
+
+	if len(args) == 0 {
+		return lc.T("lang.current", "Lang", lc.Tag, "Tags", tags)
+	}
+
+	lang := strings.ToLower(args[0])
+	if lang != "auto" && !locales.Has(lang) {
+		return lc.T("lang.unknown", "Lang", args[0], "Tags", tags)
+	}
+	if lang == "auto" {
+		lang = ""
+	}
+
+	userId := event.Source.UserID
+	if _, err := users.UpdateUser(userId, func(u *store.User) { u.Lang = lang }); err != nil {
+		logger.Error("failed to save language", "user_id", userId, "err", err)
+		return lc.T("save_failed")
+	}
+
+	if lang == "" {
+		_, lc = lookupUser(userId)
+		return lc.T("lang.auto")
+	}
+	return locales.Get(lang).T("lang.set")
+}
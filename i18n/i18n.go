@@ -0,0 +1,204 @@
+// Package i18n loads locale bundles: one JSON file per language mapping keys
+// to text/template messages, optionally split by plural category.
+//
+//	{
+//	  "alarm.set": "Alarm set for {{.Alarm}}",
+//	  "duration.hours": {"one": "in an hour", "other": "in {{.N}} hours"}
+//	}
+package i18n
+
+import (
+	"awake-bot/config"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// plural categories as in CLDR; other is always required
+const (
+	One   = "one"
+	Other = "other"
+)
+
+// pluralRules pick the category of n. Languages without one, like ja, only use other.
+var pluralRules = map[string]func(n int) string{
+	"en": func(n int) string {
+		if n == 1 {
+			return One
+		}
+		return Other
+	},
+}
+
+type Locale struct {
+	Tag string
+
+	messages map[string]map[string]*template.Template // key: category: template
+}
+
+type Bundle struct {
+	locales map[string]*Locale
+	def     string
+}
+
+// Load reads every dir/<tag>.json. def must be one of them.
+func Load(dir string, def string) (*Bundle, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bundle{locales: map[string]*Locale{}, def: def}
+	errs := config.Errors{}
+	for _, path := range paths {
+		l, err := loadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		b.locales[l.Tag] = l
+	}
+
+	if _, ok := b.locales[def]; !ok && len(errs) == 0 {
+		errs = append(errs, fmt.Errorf("locale %q is not found in %s", def, dir))
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return b, nil
+}
+
+func loadFile(path string) (*Locale, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	raw := map[string]json.RawMessage{}
+	if err := json.NewDecoder(f).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	l := &Locale{
+		Tag:      strings.TrimSuffix(filepath.Base(path), ".json"),
+		messages: map[string]map[string]*template.Template{},
+	}
+
+	for key, v := range raw {
+		forms := map[string]string{}
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil {
+			forms[Other] = s
+		} else if err := json.Unmarshal(v, &forms); err != nil {
+			return nil, fmt.Errorf("%s: %s: must be a string or plural forms", path, key)
+		}
+		if _, ok := forms[Other]; !ok {
+			return nil, fmt.Errorf("%s: %s: plural form %q is missing", path, key, Other)
+		}
+
+		l.messages[key] = map[string]*template.Template{}
+		for category, text := range forms {
+			t, err := template.New(key).Option("missingkey=error").Parse(text)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", path, err)
+			}
+			l.messages[key][category] = t
+		}
+	}
+
+	return l, nil
+}
+
+// Get returns the locale for a tag like "en" or "en-US", or the default one.
+func (b *Bundle) Get(tag string) *Locale {
+	if l, ok := b.locales[tag]; ok {
+		return l
+	}
+	if i := strings.IndexAny(tag, "-_"); i > 0 {
+		if l, ok := b.locales[strings.ToLower(tag[:i])]; ok {
+			return l
+		}
+	}
+	return b.locales[b.def]
+}
+
+func (b *Bundle) Has(tag string) bool {
+	_, ok := b.locales[tag]
+	return ok
+}
+
+// Tags returns every locale ordered by tag.
+func (b *Bundle) Tags() []string {
+	tags := []string{}
+	for tag := range b.locales {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func (l *Locale) Has(key string) bool {
+	_, ok := l.messages[key]
+	return ok
+}
+
+// Keys returns every key ordered.
+func (l *Locale) Keys() []string {
+	keys := []string{}
+	for key := range l.messages {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// T renders key with args given as name, value pairs. A missing key or
+// argument renders as the key itself.
+func (l *Locale) T(key string, args ...interface{}) string {
+	return l.render(key, Other, vars(args))
+}
+
+// N renders the plural form of key for n, which is available as {{.N}}.
+func (l *Locale) N(key string, n int, args ...interface{}) string {
+	category := Other
+	if rule, ok := pluralRules[l.Tag]; ok {
+		category = rule(n)
+	}
+
+	v := vars(args)
+	v["N"] = n
+	return l.render(key, category, v)
+}
+
+func (l *Locale) render(key, category string, v map[string]interface{}) string {
+	forms, ok := l.messages[key]
+	if !ok {
+		return key
+	}
+	t, ok := forms[category]
+	if !ok {
+		t = forms[Other]
+	}
+
+	var b bytes.Buffer
+	if err := t.Execute(&b, v); err != nil {
+		return key
+	}
+	return b.String()
+}
+
+func vars(args []interface{}) map[string]interface{} {
+	v := map[string]interface{}{}
+	for i := 0; i+1 < len(args); i += 2 {
+		if k, ok := args[i].(string); ok {
+			v[k] = args[i+1]
+		}
+	}
+	return v
+}
@@ -0,0 +1,95 @@
+package i18n
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEveryKeyIsInEveryLocale(t *testing.T) {
+	b, err := Load("../locales", "ja")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := b.Tags()
+	if strings.Join(tags, " ") != "en ja" {
+		t.Fatalf("locales = %v", tags)
+	}
+
+	keys := map[string]bool{}
+	for _, tag := range tags {
+		for _, key := range b.Get(tag).Keys() {
+			keys[key] = true
+		}
+	}
+
+	for key := range keys {
+		for _, tag := range tags {
+			if !b.Get(tag).Has(key) {
+				t.Errorf("%s: %q is missing", tag, key)
+			}
+		}
+	}
+}
+
+func TestPlural(t *testing.T) {
+	b, _ := Load("../locales", "ja")
+	en, ja := b.Get("en"), b.Get("ja")
+
+	for _, tt := range []struct {
+		l    *Locale
+		n    int
+		want string
+	}{
+		{en, 1, "in an hour"},
+		{en, 3, "in 3 hours"},
+		{ja, 1, "あと 1 時間"},
+		{ja, 3, "あと 3 時間"},
+	} {
+		if got := tt.l.N("duration.hours", tt.n); got != tt.want {
+			t.Errorf("%s %d = %q, want %q", tt.l.Tag, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	b, _ := Load("../locales", "ja")
+
+	for tag, want := range map[string]string{"en": "en", "en-US": "en", "ja-JP": "ja", "fr": "ja", "": "ja"} {
+		if got := b.Get(tag).Tag; got != want {
+			t.Errorf("%q = %s, want %s", tag, got, want)
+		}
+	}
+}
+
+func TestT(t *testing.T) {
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "en.json"), []byte(`{"hello": "hello {{.Name}}", "items": {"one": "an item"}}`), 0600)
+
+	if _, err := Load(dir, "en"); err == nil || !strings.Contains(err.Error(), `"other" is missing`) {
+		t.Errorf("err = %v", err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "en.json"), []byte(`{"hello": "hello {{.Name}}"}`), 0600)
+	l := mustLoad(t, dir).Get("en")
+
+	if got := l.T("hello", "Name", "Alex"); got != "hello Alex" {
+		t.Errorf("T = %q", got)
+	}
+	if got := l.T("hello"); got != "hello" {
+		t.Errorf("missing argument = %q", got)
+	}
+	if got := l.T("bye"); got != "bye" {
+		t.Errorf("missing key = %q", got)
+	}
+}
+
+func mustLoad(t *testing.T, dir string) *Bundle {
+	b, err := Load(dir, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
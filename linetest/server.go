@@ -0,0 +1,367 @@
+// Package linetest provides a fake LINE Messaging API server and helpers for
+// building signed webhook requests, so handlers can be tested end-to-end.
+package linetest
+
+import (
+	"awake-bot/profile"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const (
+	maxMessages      = 5
+	maxTextLength    = 5000
+	maxAltTextLength = 400
+	maxConfirmText   = 240
+	maxActionLabel   = 20
+)
+
+// Message is a sending message as decoded by the fake server.
+type Message struct {
+	Type      string    `json:"type"`
+	Text      string    `json:"text,omitempty"`
+	PackageID string    `json:"packageId,omitempty"`
+	StickerID string    `json:"stickerId,omitempty"`
+	AltText   string    `json:"altText,omitempty"`
+	Template  *Template `json:"template,omitempty"`
+}
+
+// Template is the template of a template message.
+type Template struct {
+	Type    string   `json:"type"`
+	Text    string   `json:"text,omitempty"`
+	Actions []Action `json:"actions,omitempty"`
+}
+
+type Action struct {
+	Type        string `json:"type"`
+	Label       string `json:"label,omitempty"`
+	Data        string `json:"data,omitempty"`
+	Text        string `json:"text,omitempty"`
+	DisplayText string `json:"displayText,omitempty"`
+}
+
+// Call is a push or reply request accepted by the fake server.
+type Call struct {
+	Endpoint   string // linebot.APIEndpointPushMessage or linebot.APIEndpointReplyMessage
+	To         string
+	ReplyToken string
+	Messages   []Message
+}
+
+type failure struct {
+	status int
+	times  int
+}
+
+// Server records every push and reply it receives and answers like LINE does.
+type Server struct {
+	*httptest.Server
+
+	// Notify is called for every accepted call, if set.
+	Notify func(Call)
+
+	token    string
+	mu       sync.Mutex
+	calls    []Call
+	failures map[string]*failure
+	profiles map[string]profile.Profile // userId
+
+	quota     int64 // 0: no limit
+	quotaUsed int64 // before the pushes recorded
+}
+
+// NewServer starts a fake LINE API server which accepts channelToken only.
+func NewServer(channelToken string) *Server {
+	s := &Server{token: channelToken, failures: map[string]*failure{}, profiles: map[string]profile.Profile{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc(linebot.APIEndpointPushMessage, s.handle)
+	mux.HandleFunc(linebot.APIEndpointReplyMessage, s.handle)
+	mux.HandleFunc(profile.APIEndpointProfile, s.handleProfile)
+	mux.HandleFunc("/v2/bot/message/quota", s.handleQuota)
+	mux.HandleFunc("/v2/bot/message/quota/consumption", s.handleQuota)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Client returns a linebot client talking to this server.
+func (s *Server) Client(channelSecret string) (*linebot.Client, error) {
+	return linebot.New(channelSecret, s.token, linebot.WithEndpointBase(s.URL))
+}
+
+// Fail makes the next n calls to endpoint answer with status.
+func (s *Server) Fail(endpoint string, status int, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[endpoint] = &failure{status, n}
+}
+
+// SetProfile makes p available as the profile of p.UserID.
+func (s *Server) SetProfile(p profile.Profile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profiles[p.UserID] = p
+}
+
+// SetQuota sets the monthly limit and the usage so far. Every accepted push
+// adds to the usage as it does on LINE.
+func (s *Server) SetQuota(limit, used int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.quota, s.quotaUsed = limit, used
+}
+
+// Calls returns every accepted call so far.
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call{}, s.calls...)
+}
+
+// Pushes returns accepted push calls.
+func (s *Server) Pushes() []Call {
+	return s.filter(linebot.APIEndpointPushMessage)
+}
+
+// Replies returns accepted reply calls.
+func (s *Server) Replies() []Call {
+	return s.filter(linebot.APIEndpointReplyMessage)
+}
+
+// Reset forgets recorded calls and injected failures.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = nil
+	s.failures = map[string]*failure{}
+}
+
+func (s *Server) filter(endpoint string) []Call {
+	r := []Call{}
+	for _, c := range s.Calls() {
+		if c.Endpoint == endpoint {
+			r = append(r, c)
+		}
+	}
+	return r
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer "+s.token {
+		writeError(w, http.StatusUnauthorized, "Authentication failed due to the following reason: invalid token.")
+		return
+	}
+
+	if status := s.nextFailure(r.URL.Path); status != 0 {
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		writeError(w, status, http.StatusText(status))
+		return
+	}
+
+	body := struct {
+		To         string    `json:"to"`
+		ReplyToken string    `json:"replyToken"`
+		Messages   []Message `json:"messages"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "The request body has 1 error(s)", err.Error())
+		return
+	}
+
+	call := Call{r.URL.Path, body.To, body.ReplyToken, body.Messages}
+
+	if details := validate(call); len(details) > 0 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("The request body has %d error(s)", len(details)), details...)
+		return
+	}
+
+	s.mu.Lock()
+	s.calls = append(s.calls, call)
+	notify := s.Notify
+	s.mu.Unlock()
+
+	if notify != nil {
+		notify(call)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
+
+func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer "+s.token {
+		writeError(w, http.StatusUnauthorized, "Authentication failed due to the following reason: invalid token.")
+		return
+	}
+
+	userId := strings.TrimPrefix(r.URL.Path, profile.APIEndpointProfile)
+
+	s.mu.Lock()
+	p, ok := s.profiles[userId]
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+func (s *Server) handleQuota(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+s.token {
+		writeError(w, http.StatusUnauthorized, "Authentication failed due to the following reason: invalid token.")
+		return
+	}
+	if status := s.nextFailure(r.URL.Path); status != 0 {
+		writeError(w, status, http.StatusText(status))
+		return
+	}
+
+	s.mu.Lock()
+	limit, used := s.quota, s.quotaUsed
+	for _, c := range s.calls {
+		if c.Endpoint == linebot.APIEndpointPushMessage {
+			used++
+		}
+	}
+	s.mu.Unlock()
+
+	var body interface{}
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/consumption"):
+		body = map[string]int64{"totalUsage": used}
+	case limit > 0:
+		body = map[string]interface{}{"type": "limited", "value": limit}
+	default:
+		body = map[string]string{"type": "none"}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func (s *Server) nextFailure(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.failures[endpoint]
+	if !ok || f.times <= 0 {
+		return 0
+	}
+
+	f.times--
+	return f.status
+}
+
+// validate checks the payload roughly the same way the LINE platform does.
+func validate(c Call) []string {
+	details := []string{}
+
+	switch c.Endpoint {
+	case linebot.APIEndpointPushMessage:
+		if c.To == "" {
+			details = append(details, "to: must be specified")
+		}
+	case linebot.APIEndpointReplyMessage:
+		if c.ReplyToken == "" {
+			details = append(details, "replyToken: must be specified")
+		}
+	}
+
+	if len(c.Messages) == 0 || len(c.Messages) > maxMessages {
+		details = append(details, fmt.Sprintf("messages: size must be between 1 and %d", maxMessages))
+	}
+
+	for i, m := range c.Messages {
+		switch m.Type {
+		case "text":
+			if strings.TrimSpace(m.Text) == "" {
+				details = append(details, fmt.Sprintf("messages[%d].text: may not be empty", i))
+			}
+			if utf8.RuneCountInString(m.Text) > maxTextLength {
+				details = append(details, fmt.Sprintf("messages[%d].text: length must be less than %d", i, maxTextLength))
+			}
+		case "sticker":
+			if m.PackageID == "" || m.StickerID == "" {
+				details = append(details, fmt.Sprintf("messages[%d]: packageId and stickerId must be specified", i))
+			}
+		case "template":
+			details = append(details, validateTemplate(i, m)...)
+		default:
+			details = append(details, fmt.Sprintf("messages[%d].type: unsupported type %q", i, m.Type))
+		}
+	}
+
+	return details
+}
+
+// only confirm templates for now
+func validateTemplate(i int, m Message) []string {
+	details := []string{}
+	if m.AltText == "" || utf8.RuneCountInString(m.AltText) > maxAltTextLength {
+		details = append(details, fmt.Sprintf("messages[%d].altText: length must be between 1 and %d", i, maxAltTextLength))
+	}
+
+	t := m.Template
+	if t == nil || t.Type != "confirm" {
+		return append(details, fmt.Sprintf("messages[%d].template.type: unsupported template", i))
+	}
+	if t.Text == "" || utf8.RuneCountInString(t.Text) > maxConfirmText {
+		details = append(details, fmt.Sprintf("messages[%d].template.text: length must be between 1 and %d", i, maxConfirmText))
+	}
+	if len(t.Actions) != 2 {
+		details = append(details, fmt.Sprintf("messages[%d].template.actions: size must be 2", i))
+	}
+	for j, a := range t.Actions {
+		if a.Label == "" || utf8.RuneCountInString(a.Label) > maxActionLabel {
+			details = append(details, fmt.Sprintf("messages[%d].template.actions[%d].label: length must be between 1 and %d", i, j, maxActionLabel))
+		}
+		if a.Type == "postback" && a.Data == "" {
+			details = append(details, fmt.Sprintf("messages[%d].template.actions[%d].data: may not be empty", i, j))
+		}
+	}
+	return details
+}
+
+func writeError(w http.ResponseWriter, status int, message string, details ...string) {
+	res := struct {
+		Message string `json:"message"`
+		Details []struct {
+			Message  string `json:"message"`
+			Property string `json:"property"`
+		} `json:"details,omitempty"`
+	}{Message: message}
+
+	for _, d := range details {
+		res.Details = append(res.Details, struct {
+			Message  string `json:"message"`
+			Property string `json:"property"`
+		}{Message: d})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,89 @@
+package linetest
+
+import (
+	"awake-bot/profile"
+	"net/http"
+	"testing"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+func TestServerValidatesPayload(t *testing.T) {
+	s := NewServer("token")
+	defer s.Close()
+
+	bot, err := s.Client("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bot.PushMessage("", linebot.NewTextMessage("hi")).Do(); err == nil {
+		t.Error("push without target is accepted")
+	}
+	if _, err := bot.PushMessage("U1", linebot.NewTextMessage(" ")).Do(); err == nil {
+		t.Error("blank text is accepted")
+	}
+	if _, err := bot.ReplyMessage("token", linebot.NewStickerMessage("1", "2")).Do(); err != nil {
+		t.Error(err)
+	}
+
+	if calls := s.Calls(); len(calls) != 1 || calls[0].Messages[0].StickerID != "2" {
+		t.Errorf("calls = %+v", calls)
+	}
+}
+
+func TestServerInjectedError(t *testing.T) {
+	s := NewServer("token")
+	defer s.Close()
+
+	bot, _ := s.Client("secret")
+	s.Fail(linebot.APIEndpointPushMessage, http.StatusTooManyRequests, 1)
+
+	_, err := bot.PushMessage("U1", linebot.NewTextMessage("hi")).Do()
+	if e, ok := err.(*linebot.APIError); !ok || e.Code != http.StatusTooManyRequests {
+		t.Errorf("err = %v", err)
+	}
+
+	if _, err := bot.PushMessage("U1", linebot.NewTextMessage("hi")).Do(); err != nil {
+		t.Errorf("failure is injected more than once: %v", err)
+	}
+}
+
+func TestServerProfile(t *testing.T) {
+	s := NewServer("token")
+	defer s.Close()
+
+	bot, _ := s.Client("secret")
+	s.SetProfile(profile.Profile{UserID: "U1", DisplayName: "あずさ", Language: "ja"})
+
+	if p, err := bot.GetProfile("U1").Do(); err != nil || p.DisplayName != "あずさ" {
+		t.Errorf("profile = %+v, %v", p, err)
+	}
+	if p, err := profile.NewClient(http.DefaultClient, s.URL, "token").Get("U1"); err != nil || p.Language != "ja" {
+		t.Errorf("profile = %+v, %v", p, err)
+	}
+	if _, err := bot.GetProfile("U2").Do(); err == nil {
+		t.Error("unknown user has a profile")
+	}
+}
+
+func TestServerConfirmTemplate(t *testing.T) {
+	s := NewServer("token")
+	defer s.Close()
+
+	bot, _ := s.Client("secret")
+	yes := linebot.NewPostbackAction("はい", "answer=yes", "", "はい")
+	no := linebot.NewPostbackAction("いいえ", "answer=no", "", "いいえ")
+
+	if _, err := bot.PushMessage("U1", linebot.NewTemplateMessage("", linebot.NewConfirmTemplate("ok?", yes, no))).Do(); err == nil {
+		t.Error("template without altText is accepted")
+	}
+	if _, err := bot.PushMessage("U1", linebot.NewTemplateMessage("ok?", linebot.NewConfirmTemplate("ok?", yes, no))).Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := s.Calls()[0].Messages[0]
+	if m.Template == nil || m.Template.Type != "confirm" || len(m.Template.Actions) != 2 || m.Template.Actions[1].Data != "answer=no" {
+		t.Errorf("message = %+v", m)
+	}
+}
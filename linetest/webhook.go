@@ -0,0 +1,154 @@
+package linetest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+var (
+	seq int64
+
+	mu    sync.Mutex
+	metas = map[*linebot.Event]Meta{}
+)
+
+// Meta is what LINE sends along with an event which the SDK does not decode.
+type Meta struct {
+	WebhookEventID string
+	Redelivery     bool
+}
+
+// SetMeta changes what is sent along with e.
+func SetMeta(e *linebot.Event, m Meta) {
+	mu.Lock()
+	defer mu.Unlock()
+	metas[e] = m
+}
+
+// MetaOf returns what is sent along with e. The events built here get a
+// fresh webhookEventId.
+func MetaOf(e *linebot.Event) Meta {
+	mu.Lock()
+	defer mu.Unlock()
+	return metas[e]
+}
+
+// Sign returns the X-Line-Signature value for body.
+func Sign(channelSecret string, body []byte) string {
+	hash := hmac.New(sha256.New, []byte(channelSecret))
+	hash.Write(body)
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil))
+}
+
+// NewWebhookRequest builds a webhook POST to path signed with channelSecret.
+func NewWebhookRequest(channelSecret string, path string, events ...*linebot.Event) *http.Request {
+	raw := []map[string]interface{}{}
+	for _, e := range events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			panic(err)
+		}
+		m := map[string]interface{}{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			panic(err)
+		}
+
+		meta := MetaOf(e)
+		if meta.WebhookEventID != "" {
+			m["webhookEventId"] = meta.WebhookEventID
+		}
+		m["deliveryContext"] = map[string]bool{"isRedelivery": meta.Redelivery}
+		raw = append(raw, m)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"events": raw})
+	if err != nil {
+		panic(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Line-Signature", Sign(channelSecret, body))
+	return req
+}
+
+// Source returns a group source when groupId is set, otherwise a user source.
+func Source(userId string, groupId string) *linebot.EventSource {
+	if groupId == "" {
+		return &linebot.EventSource{Type: linebot.EventSourceTypeUser, UserID: userId}
+	}
+	return &linebot.EventSource{Type: linebot.EventSourceTypeGroup, UserID: userId, GroupID: groupId}
+}
+
+// TextEvent returns a text message event with a fresh reply token.
+func TextEvent(userId string, groupId string, text string) *linebot.Event {
+	id := next()
+	e := &linebot.Event{
+		ReplyToken: "reply-" + id,
+		Type:       linebot.EventTypeMessage,
+		Timestamp:  time.Now(),
+		Source:     Source(userId, groupId),
+		Message:    &linebot.TextMessage{ID: id, Text: text},
+	}
+	SetMeta(e, Meta{WebhookEventID: "event-" + id})
+	return e
+}
+
+// StickerEvent returns a sticker message event with a fresh reply token.
+func StickerEvent(userId string, groupId string, packageId string, stickerId string) *linebot.Event {
+	id := next()
+	e := &linebot.Event{
+		ReplyToken: "reply-" + id,
+		Type:       linebot.EventTypeMessage,
+		Timestamp:  time.Now(),
+		Source:     Source(userId, groupId),
+		Message:    &linebot.StickerMessage{ID: id, PackageID: packageId, StickerID: stickerId},
+	}
+	SetMeta(e, Meta{WebhookEventID: "event-" + id})
+	return e
+}
+
+// PostbackEvent returns the event sent when a postback action is tapped.
+func PostbackEvent(userId string, groupId string, data string) *linebot.Event {
+	id := next()
+	e := &linebot.Event{
+		ReplyToken: "reply-" + id,
+		Type:       linebot.EventTypePostback,
+		Timestamp:  time.Now(),
+		Source:     Source(userId, groupId),
+		Postback:   &linebot.Postback{Data: data},
+	}
+	SetMeta(e, Meta{WebhookEventID: "event-" + id})
+	return e
+}
+
+// ChatEvent returns a follow, unfollow, join or leave event. Only follow and
+// join have a reply token.
+func ChatEvent(eventType linebot.EventType, userId string, groupId string) *linebot.Event {
+	id := next()
+	e := &linebot.Event{
+		Type:      eventType,
+		Timestamp: time.Now(),
+		Source:    Source(userId, groupId),
+	}
+	if eventType == linebot.EventTypeFollow || eventType == linebot.EventTypeJoin {
+		e.ReplyToken = "reply-" + id
+	}
+	SetMeta(e, Meta{WebhookEventID: "event-" + id})
+	return e
+}
+
+func next() string {
+	return strconv.FormatInt(atomic.AddInt64(&seq, 1), 10)
+}
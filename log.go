@@ -0,0 +1,80 @@
+package main
+
+import (
+	"awake-bot/config"
+	"awake-bot/logging"
+	"awake-bot/timeout"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	requestIdHeader  = "X-Request-Id"
+	requestLoggerKey = "logger"
+	maxRequestIdLen  = 64
+)
+
+var logger = newLogger(config.Default().Log)
+
+func newLogger(c config.LogConfig) *logging.Logger {
+	level, err := logging.ParseLevel(c.Level)
+	if err != nil {
+		level = logging.Info
+	}
+	format, _ := logging.ParseFormat(c.Format)
+	redactor, err := logging.ParseRules(c.Redact)
+	if err != nil {
+		redactor, _ = logging.ParseRules(logging.DefaultRules)
+	}
+
+	return logging.New(os.Stderr, level, format, redactor)
+}
+
+// requestLogger logs every request and gives it a correlation id.
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Request.Header.Get(requestIdHeader)
+		if id == "" || len(id) > maxRequestIdLen {
+			id = logging.NewId()
+		}
+		c.Header(requestIdHeader, id)
+
+		l := logger.With("request_id", id)
+		c.Set(requestLoggerKey, l)
+
+		start := time.Now()
+		c.Next()
+
+		l.Info("request",
+			"method", c.Request.Method,
+			"path", routePath(c),
+			"status", c.Writer.Status(),
+			"latency", time.Since(start),
+			"client_ip", c.ClientIP())
+	}
+}
+
+// the path with its parameters named, like /admin/sessions/:roomId/cancel,
+// so ids in it are not logged unredacted
+func routePath(c *gin.Context) string {
+	path := c.Request.URL.Path
+	for _, p := range c.Params {
+		path = strings.Replace(path, "/"+p.Value, "/:"+p.Key, 1)
+	}
+	return path
+}
+
+// returns the logger of the request, carrying its correlation id
+func requestLog(c *gin.Context) *logging.Logger {
+	if l, ok := c.Get(requestLoggerKey); ok {
+		return l.(*logging.Logger)
+	}
+	return logger
+}
+
+func sessionLog(to *timeout.Timeout) *logging.Logger {
+	return logger.With("session_id", to.Id, "room_id", to.RoomId, "user_id", to.GetMonitoringUserId())
+}
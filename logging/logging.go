@@ -0,0 +1,203 @@
+// Package logging is a leveled key/value logger writing logfmt or JSON lines,
+// with redaction of sensitive fields.
+package logging
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return "unknown"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel accepts the names printed by Level.String.
+func ParseLevel(s string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(s, n) {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("logging: unknown level %q", s)
+}
+
+type Format int
+
+const (
+	Logfmt Format = iota
+	JSON
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "logfmt", "":
+		return Logfmt, nil
+	case "json":
+		return JSON, nil
+	}
+	return Logfmt, fmt.Errorf("logging: unknown format %q", s)
+}
+
+// output is shared by a logger and every logger derived from it with With.
+type output struct {
+	mu  sync.Mutex
+	w   io.Writer
+	now func() time.Time
+}
+
+type Logger struct {
+	out      *output
+	level    Level
+	format   Format
+	redactor *Redactor
+	fields   []interface{}
+}
+
+func New(w io.Writer, level Level, format Format, redactor *Redactor) *Logger {
+	if redactor == nil {
+		redactor = &Redactor{}
+	}
+	return &Logger{out: &output{w: w, now: time.Now}, level: level, format: format, redactor: redactor}
+}
+
+// With returns a logger which adds key/value pairs to every line.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	c := *l
+	c.fields = append(append([]interface{}{}, l.fields...), kv...)
+	return &c
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.log(Debug, msg, kv) }
+func (l *Logger) Info(msg string, kv ...interface{})  { l.log(Info, msg, kv) }
+func (l *Logger) Warn(msg string, kv ...interface{})  { l.log(Warn, msg, kv) }
+func (l *Logger) Error(msg string, kv ...interface{}) { l.log(Error, msg, kv) }
+
+// Fatal logs at error level and exits.
+func (l *Logger) Fatal(msg string, kv ...interface{}) {
+	l.log(Error, msg, kv)
+	os.Exit(1)
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *Logger) log(level Level, msg string, kv []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	pairs := append(append([]interface{}{}, l.fields...), kv...)
+	if len(pairs)%2 != 0 {
+		pairs = append(pairs, "(missing)")
+	}
+
+	var buf bytes.Buffer
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+
+	keys := []string{"time", "level", "msg"}
+	values := []string{l.out.now().Format(time.RFC3339), level.String(), msg}
+
+	for i := 0; i < len(pairs); i += 2 {
+		key := fmt.Sprint(pairs[i])
+		keys = append(keys, key)
+		values = append(values, l.redactor.Redact(key, stringify(pairs[i+1])))
+	}
+
+	switch l.format {
+	case JSON:
+		buf.WriteByte('{')
+		for i := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			k, _ := json.Marshal(keys[i])
+			v, _ := json.Marshal(values[i])
+			buf.Write(k)
+			buf.WriteByte(':')
+			buf.Write(v)
+		}
+		buf.WriteByte('}')
+	default:
+		for i := range keys {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(logfmtKey(keys[i]))
+			buf.WriteByte('=')
+			buf.WriteString(logfmtValue(values[i]))
+		}
+	}
+
+	buf.WriteByte('\n')
+	l.out.w.Write(buf.Bytes())
+}
+
+func stringify(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case error:
+		if v == nil {
+			return "<nil>"
+		}
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+func logfmtKey(k string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, k)
+}
+
+func logfmtValue(v string) string {
+	if v == "" {
+		return `""`
+	}
+	if strings.ContainsAny(v, " =\"\\\t\r\n") {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// NewId returns a random id for correlating log lines.
+func NewId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestLogger(format Format, rules string) (*Logger, *bytes.Buffer) {
+	r, err := ParseRules(rules)
+	if err != nil {
+		panic(err)
+	}
+
+	var buf bytes.Buffer
+	l := New(&buf, Info, format, r)
+	l.out.now = func() time.Time { return time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC) }
+	return l, &buf
+}
+
+func TestLogfmt(t *testing.T) {
+	l, buf := newTestLogger(Logfmt, DefaultRules)
+
+	l.With("request_id", "abc").Info("message received", "text", "おはよう", "count", 2, "err", errors.New("bad thing"))
+	l.Debug("hidden")
+
+	want := `time=2026-10-19T07:00:00Z level=info msg="message received" request_id=abc text=[REDACTED] count=2 err="bad thing"` + "\n"
+	if buf.String() != want {
+		t.Errorf("got  %s\nwant %s", buf.String(), want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	l, buf := newTestLogger(JSON, "")
+
+	l.Warn("quota", "used", 0.9)
+
+	want := `{"time":"2026-10-19T07:00:00Z","level":"warn","msg":"quota","used":"0.9"}` + "\n"
+	if buf.String() != want {
+		t.Errorf("got  %s\nwant %s", buf.String(), want)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	r, err := ParseRules(DefaultRules + ",body=truncate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key, value, want string
+	}{
+		{"token", "s3cr3t", "[REDACTED]"},
+		{"reply_token", "abcdef", "[REDACTED]"},
+		{"channel_secret", "abcdef", "[REDACTED]"},
+		{"body", "おはようございます", "おはよう...(9 chars)"},
+		{"body", "hi", "hi"},
+		{"path", "/push", "/push"},
+		{"token", "", ""},
+	}
+
+	for _, tt := range tests {
+		if got := r.Redact(tt.key, tt.value); got != tt.want {
+			t.Errorf("Redact(%q, %q) = %q, want %q", tt.key, tt.value, got, tt.want)
+		}
+	}
+
+	hashed := r.Redact("user_id", "U1234")
+	if len(hashed) != 10 || hashed == "U1234" || hashed != r.Redact("user_id", "U1234") {
+		t.Errorf("hashed user id = %q", hashed)
+	}
+}
+
+func TestParseRulesError(t *testing.T) {
+	for _, spec := range []string{"token", "token=erase"} {
+		if _, err := ParseRules(spec); err == nil {
+			t.Errorf("ParseRules(%q) is accepted", spec)
+		}
+	}
+}
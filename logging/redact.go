@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type Mode int
+
+const (
+	Keep     Mode = iota
+	Redact        // replaced entirely
+	Hash          // replaced by a short stable hash, so lines can still be correlated
+	Truncate      // only the first few characters are kept
+)
+
+var modeNames = map[string]Mode{"keep": Keep, "redact": Redact, "hash": Hash, "truncate": Truncate}
+
+// DefaultRules hides tokens and message bodies and hashes LINE ids.
+const DefaultRules = "*token=redact,*secret=redact,text=redact,message=redact," +
+	"user_id=hash,group_id=hash,room_id=hash,alert_room_id=hash"
+
+const truncateLength = 4
+
+// Redactor rewrites values by key. A key starting with "*" matches by suffix.
+type Redactor struct {
+	rules map[string]Mode
+}
+
+// ParseRules parses comma separated key=mode pairs, e.g. "token=redact,text=truncate".
+func ParseRules(spec string) (*Redactor, error) {
+	r := &Redactor{rules: map[string]Mode{}}
+
+	for _, rule := range strings.Split(spec, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		kv := strings.SplitN(rule, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("logging: invalid redaction rule %q", rule)
+		}
+
+		mode, ok := modeNames[strings.TrimSpace(kv[1])]
+		if !ok {
+			return nil, fmt.Errorf("logging: unknown redaction mode %q", kv[1])
+		}
+		r.rules[strings.TrimSpace(kv[0])] = mode
+	}
+
+	return r, nil
+}
+
+func (r *Redactor) mode(key string) Mode {
+	if m, ok := r.rules[key]; ok {
+		return m
+	}
+	for k, m := range r.rules {
+		if strings.HasPrefix(k, "*") && strings.HasSuffix(key, k[1:]) {
+			return m
+		}
+	}
+	return Keep
+}
+
+func (r *Redactor) Redact(key string, value string) string {
+	if value == "" {
+		return value
+	}
+
+	switch r.mode(key) {
+	case Redact:
+		return "[REDACTED]"
+	case Hash:
+		sum := sha256.Sum256([]byte(value))
+		return "h:" + hex.EncodeToString(sum[:4])
+	case Truncate:
+		if utf8.RuneCountInString(value) <= truncateLength {
+			return value
+		}
+		return string([]rune(value)[:truncateLength]) + fmt.Sprintf("...(%d chars)", utf8.RuneCountInString(value))
+	}
+	return value
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"awake-bot/auth"
+	"awake-bot/i18n"
+	"awake-bot/queue"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const sessionCookie = "awake_session"
+
+var (
+	signer    *auth.Signer
+	usedLinks *queue.Seen // a login link works once
+
+	errLinkUsed = errors.New("login link already used")
+)
+
+func startWeb() {
+	key := conf.Web.Secret
+	if key == "" {
+		key = conf.Line.ChannelSecret
+	}
+	signer = auth.NewSigner([]byte(key))
+	usedLinks = queue.NewSeen(conf.Web.LinkTTL)
+}
+
+// /login: a link to the web pages, only sent in the 1:1 chat since anyone
+// opening it is logged in as the sender
+func onLoginCommand(event *linebot.Event, args []string, lc *i18n.Locale) string {
+	if conf.Web.BaseURL == "" {
+		return lc.T("login.disabled")
+	}
+	userId := event.Source.UserID
+	if userId == "" || sourceId(event.Source) != userId {
+		return lc.T("login.private")
+	}
+
+	token := signer.Sign("login", userId, clk.Now().Add(conf.Web.LinkTTL))
+	link := strings.TrimSuffix(conf.Web.BaseURL, "/") + "/login?token=" + url.QueryEscape(token)
+	return lc.T("login.link", "Url", link, "Minutes", int(conf.Web.LinkTTL.Minutes()))
+}
+
+func isAdmin(userId string) bool {
+	for _, id := range conf.Admins {
+		if id == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// the logged in user, "" when nobody is
+func sessionUser(c *gin.Context) string {
+	cookie, err := c.Request.Cookie(sessionCookie)
+	if err != nil {
+		return ""
+	}
+	claims, err := signer.Verify("session", cookie.Value, clk.Now())
+	if err != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+// pages for logged in users, who are told how to log in otherwise
+func requireLogin(c *gin.Context) {
+	userId := sessionUser(c)
+	if userId == "" {
+		c.HTML(http.StatusUnauthorized, "login.tmpl.html", gin.H{"Error": webLocale("").T("login.required")})
+		c.Abort()
+		return
+	}
+	c.Set("userId", userId)
+}
+
+// GET /login?token=: LINE and other apps open links to show a preview, so
+// the link is only used up by the button on this page
+func onLoginPage(c *gin.Context) {
+	token := c.Query("token")
+	if claims, err := signer.Verify("login", token, clk.Now()); err != nil {
+		c.HTML(http.StatusForbidden, "login.tmpl.html", gin.H{"Error": loginError(err, claims.Subject)})
+		return
+	}
+	c.HTML(http.StatusOK, "login.tmpl.html", gin.H{"Token": token})
+}
+
+// POST /login
+func onLogin(c *gin.Context) {
+	l := requestLog(c)
+	now := clk.Now()
+
+	claims, err := signer.Verify("login", c.PostForm("token"), now)
+	if err == nil && !usedLinks.Add(claims.Nonce, now) {
+		err = errLinkUsed
+	}
+	if err != nil {
+		l.Info("login refused", "err", err)
+		c.HTML(http.StatusForbidden, "login.tmpl.html", gin.H{"Error": loginError(err, claims.Subject)})
+		return
+	}
+
+	expires := now.Add(conf.Web.SessionTTL)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    signer.Sign("session", claims.Subject, expires),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(conf.Web.BaseURL, "https:"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	l.Info("logged in", "user_id", claims.Subject, "admin", isAdmin(claims.Subject))
+
+	if isAdmin(claims.Subject) {
+		c.Redirect(http.StatusSeeOther, "/admin")
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/settings")
+}
+
+// POST /logout
+func onLogout(c *gin.Context) {
+	lc := webLocale(sessionUser(c))
+	http.SetCookie(c.Writer, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	c.HTML(http.StatusOK, "login.tmpl.html", gin.H{"Message": lc.T("login.logged_out")})
+}
+
+// userId is who the link was for, empty when it cannot be read
+func loginError(err error, userId string) string {
+	lc := webLocale(userId)
+	switch err {
+	case auth.ErrExpired:
+		return lc.T("login.expired")
+	case errLinkUsed:
+		return lc.T("login.used")
+	}
+	return lc.T("login.invalid")
+}
+
+// the locale of the user, the default one when nobody is known
+func webLocale(userId string) *i18n.Locale {
+	if userId == "" {
+		return locales.Get("")
+	}
+	_, lc := lookupUser(userId)
+	return lc
+}
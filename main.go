@@ -1,67 +1,186 @@
 package main
 
 import (
+	"awake-bot/clock"
+	"awake-bot/config"
 	"awake-bot/forecast"
+	"awake-bot/logging"
+	"awake-bot/outbox"
+	"awake-bot/persona"
+	"awake-bot/profile"
+	"awake-bot/quota"
+	"awake-bot/schedule"
+	"awake-bot/solar"
+	"awake-bot/store"
 	"awake-bot/timeout"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	_ "github.com/heroku/x/hmetrics/onload"
 	"github.com/line/line-bot-sdk-go/linebot"
 	"github.com/pinzolo/flagday"
 )
 
-const (
-	AwakeBotTokenEnv = "AWAKE_BOT_TOKEN"
-)
-
 var (
-	bot    *linebot.Client             // LineBot Client
-	snooze map[string]*timeout.Timeout // roomId
+	bot    *linebot.Client                 // LineBot Client
+	snooze = map[string]*timeout.Timeout{} // roomId
+	conf   = config.Default()
+	clk    = clock.Real()
+	users  *store.Store
+
+	requestForecast = forecast.Fetch // replaced in tests
 )
 
-func init() {
-	tz := os.Getenv("TZ")
-	if tz == "" {
-		return
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "simulate":
+			simulate(os.Args[2:])
+			return
+		case "config":
+			configCommand(os.Args[2:])
+			return
+		}
 	}
 
-	local, _ := time.LoadLocation(tz)
-	log.Println("Timezone: " + local.String())
-	time.Local = local
-
-	snooze = map[string]*timeout.Timeout{}
-}
+	c, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+		os.Exit(1)
+	}
 
-func main() {
-	port := os.Getenv("PORT")
+	conf = c
+	logger = newLogger(conf.Log)
+	if err := loadMessages(); err != nil {
+		logger.Fatal("failed to load messages", "err", err)
+	}
+	if err := compilePatterns(); err != nil {
+		logger.Fatal("failed to compile patterns", "err", err)
+	}
+	time.Local = conf.Location()
+	logger.Info("timezone", "tz", time.Local.String())
 
-	if port == "" {
-		log.Fatal("$PORT must be set")
+	options := []linebot.ClientOption{}
+	if conf.Line.EndpointBase != "" {
+		options = append(options, linebot.WithEndpointBase(conf.Line.EndpointBase))
 	}
 
-	lb, err := linebot.New(os.Getenv("LINE_CHANNEL_SECRET"), os.Getenv("LINE_CHANNEL_TOKEN"))
+	lb, err := newBot(conf.Line.ChannelSecret, conf.Line.ChannelToken, options...)
 
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("failed to create LINE client", "err", err)
 	}
 
 	bot = lb
 
+	endpointBase := conf.Line.EndpointBase
+	if endpointBase == "" {
+		endpointBase = linebot.APIEndpointBase
+	}
+	quotaClient = quota.NewClient(instrumentedClient(), endpointBase, conf.Line.ChannelToken)
+	profiles = profile.NewCache(profile.NewClient(instrumentedClient(), endpointBase, conf.Line.ChannelToken), profileTTL)
+	budget = quota.NewTracker(conf.Quota.Thresholds)
+	syncQuota()
+
+	if outgoing, err = openOutbox(conf.Outbox.File); err != nil {
+		logger.Fatal("failed to open outbox", "err", err)
+	}
+	startWebhooks()
+	startFeed()
+	startWeb()
+
+	if users, err = store.Open(conf.StoreFile); err != nil {
+		logger.Fatal("failed to open user store", "err", err)
+	}
+	scheduleAlarms()
+	scheduleTimers()
+
+	if err := restoreSessions(conf.StateFile); err != nil {
+		logger.Error("failed to restore sessions", "err", err)
+	}
+
+	srv := &http.Server{Addr: ":" + conf.Port, Handler: newRouter()}
+	runServer(srv, conf.StateFile)
+}
+
+func loadConfig() (*config.Config, error) {
+	path, required := config.Path()
+	return config.Check(path, required)
+}
+
+// the configured patterns, compiled once by compilePatterns
+var ackPattern, bedPattern *regexp.Regexp
+
+func compilePatterns() error {
+	var err error
+	if ackPattern, err = regexp.Compile(conf.Snooze.AckPattern); err != nil {
+		return fmt.Errorf("snooze.ack_pattern: %s", err)
+	}
+	if bedPattern, err = regexp.Compile(conf.Sleep.BedPattern); err != nil {
+		return fmt.Errorf("sleep.bed_pattern: %s", err)
+	}
+	return nil
+}
+
+// config check [path]: reports every problem of the configuration
+func configCommand(args []string) {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: awake-bot config check [path]")
+		os.Exit(2)
+	}
+
+	path, required := config.Path()
+	if len(args) > 1 {
+		path, required = args[1], true
+	}
+
+	if _, err := config.Check(path, required); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("ok")
+}
+
+func newRouter() *gin.Engine {
 	router := gin.New()
-	router.Use(gin.Logger())
+	router.Use(requestLogger())
 	router.LoadHTMLGlob("templates/*.tmpl.html")
 	router.Static("/static", "static")
 
 	router.GET("/", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "index.tmpl.html", nil)
+		if userId := sessionUser(c); userId != "" && !isAdmin(userId) {
+			c.Redirect(http.StatusFound, "/settings")
+			return
+		}
+		c.Redirect(http.StatusFound, "/admin")
 	})
 
+	// users log in through a link the bot sends for /login
+	router.GET("/login", onLoginPage)
+	router.POST("/login", sameOrigin, onLogin)
+	router.POST("/logout", sameOrigin, onLogout)
+	settings := router.Group("/settings", requireLogin, sameOrigin)
+	settings.GET("", onSettings)
+	settings.POST("", onSaveSettings)
+
+	// dashboard for the admin
+	admin := router.Group("/admin", dashboardAuth(), sameOrigin)
+	admin.GET("", onDashboard)
+	admin.POST("/sessions/:roomId/cancel", onDashboardCancel)
+	admin.POST("/sessions/:roomId/snooze", onDashboardSnooze)
+	admin.POST("/test-push", onDashboardTestPush)
+
+	// session events for the dashboard and wall displays
+	api := router.Group("/api/v1", dashboardAuth())
+	api.GET("/events", onEvents)
+
 	// Setup HTTP Server for receiving requests from LINE platform
 	router.POST("/message", onMessage)
 	// push message via HTTP request
@@ -69,10 +188,10 @@ func main() {
 	// for UptimeRobot
 	router.HEAD("/ping", onPing)
 	router.GET("/ping", onPing)
-	// go sendForecast("C377079ced8ae010da2a12f5e2e365f30")
+	// for Prometheus
+	router.GET("/metrics", gin.WrapH(registry.Handler()))
 
-	// will be ignored all this below
-	router.Run(":" + port)
+	return router
 }
 
 func onPing(c *gin.Context) {
@@ -81,8 +200,11 @@ func onPing(c *gin.Context) {
 
 // when message received from LINE
 func onMessage(c *gin.Context) {
-	events, err := bot.ParseRequest(c.Request)
+	l := requestLog(c)
+
+	events, err := parseWebhook(c.Request)
 	if err != nil {
+		l.Warn("invalid webhook", "err", err)
 		if err == linebot.ErrInvalidSignature {
 			c.Writer.WriteHeader(http.StatusBadRequest)
 		} else {
@@ -91,54 +213,100 @@ func onMessage(c *gin.Context) {
 		return
 	}
 
+	// handled after answering, LINE does not wait for replies
+	queued := true
 	for _, event := range events {
-		log.Printf("[info] event type: %s", event.Type)
-		log.Printf("[info] user id: %s, group id: %s", event.Source.UserID, event.Source.GroupID)
+		el := l.With("event_type", event.Type, "user_id", event.Source.UserID, "group_id", event.Source.GroupID)
+		el.Info("event received")
+		if !enqueueEvent(el, event) {
+			queued = false
+		}
+	}
 
-		if event.Type == linebot.EventTypeMessage {
-			switch message := event.Message.(type) {
-			case *linebot.TextMessage:
-				log.Printf("[info] message: %s", message.Text)
+	if !queued {
+		// LINE delivers the batch again when redelivery is turned on for the
+		// channel; the events already queued are dropped as duplicates
+		c.Writer.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
 
-				if message.Text == "/id" {
-					reply := "UserId: " + event.Source.UserID + ", GroupId: " + event.Source.GroupID
-					bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage(reply)).Do()
-					return
-				}
+// handles one event of a webhook; returning early never drops the others
+func onEvent(l *logging.Logger, event *linebot.Event) {
+	if event.Source.UserID != "" {
+		noteRooms(l, event.Source.UserID, sourceId(event.Source))
+	}
 
-				if to, e := snooze[event.Source.GroupID]; e {
-					if event.Source.UserID == to.GetMonitoringUserId() {
-						r := regexp.MustCompile(`^おはよ.`)
-						if r.MatchString(message.Text) {
-							log.Printf("[info] monitoring user id %s is matched. stop monitoring.", to.GetMonitoringUserId())
+	switch event.Type {
+	case linebot.EventTypeFollow:
+		onFollow(l, event)
+		return
+	case linebot.EventTypeUnfollow:
+		onUnfollow(l, event)
+		return
+	case linebot.EventTypeJoin:
+		onJoin(l, event)
+		return
+	case linebot.EventTypeLeave:
+		onLeave(l, event)
+		return
+	case linebot.EventTypePostback:
+		onPostback(l, event)
+		return
+	}
+
+	if message, ok := event.Message.(*linebot.TextMessage); ok && event.Type == linebot.EventTypeMessage {
+		l.Debug("text message", "text", message.Text)
+
+		if reply, ok := runCommand(event, message.Text); ok {
+			if reply != "" {
+				bot.ReplyMessage(event.ReplyToken, newTextMessage(reply)).Do()
+			}
+			return
+		}
 
-							bot.ReplyMessage(event.ReplyToken,
-								newTextMessage("おはよー！！\n今日も一日がんばるぞい☀"),
-								newStickerMessage("11537", "52002764")).Do()
+		if to, e := getSession(sourceId(event.Source)); e {
+			if event.Source.UserID == to.GetMonitoringUserId() {
+				if isAck(users.User(to.GetMonitoringUserId()), message.Text) {
+					l.Info("monitoring user woke up. stop monitoring.", "session_id", to.Id)
 
-							to.Stop()
-							delete(snooze, to.RoomId)
-							return
-						}
+					name, lc := lookupUser(to.GetMonitoringUserId())
+					vars := persona.Vars{Name: name}
+					messages := sayWithSticker(roomPersona(to.RoomId, lc), "awake", vars)
+					if n, ok := wakeUpFromSleep(l, to.GetMonitoringUserId(), clk.Now()); ok {
+						messages = append(messages, newTextMessage(lc.T("sleep.slept", "Sleep", formatSleep(lc, n.Duration()))))
 					}
+					bot.ReplyMessage(event.ReplyToken, messages...).Do()
+
+					to.Stop()
+					deleteSession(to.RoomId)
+					recordOutcome(to, to.RoomId, to.GetMonitoringUserId(), "acknowledged")
+					sessionsAcknowledged.Inc()
+					acknowledgeSeconds.Observe(clk.Now().Sub(to.StartedAt).Seconds())
+					return
 				}
 			}
 		}
+
+		if proposeAlarm(l, event, message.Text) {
+			return
+		}
 	}
+
+	if event.Type == linebot.EventTypeMessage && onSleepMessage(l, event) {
+		return
+	}
+
+	keepReplyToken(sourceId(event.Source), event.ReplyToken)
 }
 
 // when received a push-message via webhook
 func onPush(c *gin.Context) {
-	token := os.Getenv(AwakeBotTokenEnv)
-
-	if isHolidayToday() {
-		log.Printf("[info] Today is holiday. // todo skip")
-		return
-	}
+	l := requestLog(c)
+	token := conf.Push.Token
 
 	if token != c.PostForm("token") {
 		c.Writer.WriteHeader(http.StatusNotFound)
-		log.Printf("[err] Invalid token %s", c.PostForm("token"))
+		l.Warn("invalid token")
 		return
 	}
 
@@ -146,62 +314,127 @@ func onPush(c *gin.Context) {
 
 	if userId == "" {
 		c.Writer.WriteHeader(http.StatusBadRequest)
-		log.Printf("[err] 'user_id' is missing.")
+		l.Warn("'user_id' is missing.")
 		return
 	}
 
-	log.Printf("[info] monitoring target user id: %s", userId)
-
 	roomId := c.PostForm("room_id")
 
 	if roomId == "" {
 		roomId = userId
 	}
 
-	log.Printf("[info] push message target id: %s", roomId)
+	l = l.With("user_id", userId, "room_id", roomId)
+	u := users.User(userId)
+	loc := userLocation(u)
+
+	if users.Room(roomId).Unreachable {
+		l.Warn("the room blocked or removed the bot.")
+		c.Writer.WriteHeader(http.StatusGone)
+		return
+	}
+
+	if paused(u, clk.Now()) {
+		l.Info("the user is paused. push skipped.", "until", u.Pause.Until)
+		recordOutcome(nil, roomId, userId, "skipped")
+		pauseSkips.Inc()
+		skipPush(c, "paused", u.Pause.Until)
+		return
+	}
+
+	if isHolidayToday(loc) {
+		l.Info("today is holiday. push skipped.")
+		recordOutcome(nil, roomId, userId, "skipped")
+		holidaySkips.Inc()
+		skipPush(c, "holiday", time.Time{})
+		return
+	}
 
 	message := c.PostForm("message")
 
 	if message == "" {
-		log.Printf("[err] 'message' is missing.")
+		l.Warn("'message' is missing.")
 		c.Writer.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	noteRooms(l, userId, roomId, c.PostForm("alert_room_id"))
 
 	wait, _ := strconv.Atoi(c.DefaultPostForm("timeout", "0"))
+	priority := outbox.Normal
 
 	if wait > 0 {
-		if _, exists := snooze[roomId]; exists {
-			log.Printf("[err] snooze for roomId %s is already exists.", roomId)
+		to, ok := startSession(l, roomId, userId, c.PostForm("alert_room_id"), wait)
+		if !ok {
+			l.Warn("snooze for the room already exists.")
 			return
-		} else {
-			alertRoomId := c.PostForm("alert_room_id")
-			log.Printf("[info] sending alert room id: %s", alertRoomId)
-			snooze[roomId] = timeout.New(onTimeout, wait, roomId, userId, alertRoomId)
-			// Keep awake
-			sendKeepAwake(1200) // 20 min
 		}
+		l = l.With("session_id", to.Id)
+		priority = outbox.High // the first wake prompt
 	}
 
-	if err := pushMessage(roomId, message); err != nil {
-		log.Print(err)
+	messages := []linebot.SendingMessage{newTextMessage(message)}
+	if f, ok := forecastMessage(roomId, userId); ok {
+		messages = append(messages, f)
+	}
+	if text, ok := welcomeBack(l, userId); ok {
+		messages = append(messages, newTextMessage(text))
+	}
+
+	switch err := send(roomId, priority, messages...); {
+	case err == outbox.ErrQueued:
+		l.Warn("message queued for retry.")
+		c.Writer.WriteHeader(http.StatusAccepted)
+	case err != nil:
+		l.Error("failed to push message", "err", err)
 		c.Writer.WriteHeader(http.StatusInternalServerError)
-	} else {
-		log.Printf("[info] message pushed.")
+	default:
+		l.Info("message pushed.")
 		c.Writer.WriteHeader(http.StatusOK)
-		go sendForecast(roomId)
 	}
 }
 
+// tells the caller nothing was sent and why, with when it ends if known
+func skipPush(c *gin.Context, reason string, until time.Time) {
+	res := gin.H{"result": "skipped", "reason": reason}
+	if !until.IsZero() {
+		res["until"] = until.Format(time.RFC3339)
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// starts monitoring userId in roomId. false if the room already has a session.
+func startSession(l *logging.Logger, roomId, userId, alertRoomId string, wait int) (*timeout.Timeout, bool) {
+	to := timeout.New(clk, onTimeout, wait, roomId, userId, alertRoomId)
+	if !putSession(to) {
+		to.Stop()
+		return nil, false
+	}
+
+	l.Info("snooze started", "session_id", to.Id, "timeout", wait, "alert_room_id", alertRoomId)
+	sessionsStarted.Inc()
+	publishSession(to, "started")
+	// Keep awake
+	sendKeepAwake(conf.KeepAwake.Delay)
+	return to, true
+}
+
+// the chat the event came from
+func sourceId(s *linebot.EventSource) string {
+	switch {
+	case s.GroupID != "":
+		return s.GroupID
+	case s.RoomID != "":
+		return s.RoomID
+	}
+	return s.UserID
+}
+
 func pushMessage(roomId string, message string) error {
-	_, err := bot.PushMessage(roomId, linebot.NewTextMessage(message)).Do()
-	return err
+	return send(roomId, outbox.High, newTextMessage(message))
 }
 
 func pushSticker(roomId string, packageId string, stickerId string) error {
-	msg := linebot.NewStickerMessage(packageId, stickerId)
-	_, err := bot.PushMessage(roomId, msg).Do()
-	return err
+	return send(roomId, outbox.High, newStickerMessage(packageId, stickerId))
 }
 
 func newTextMessage(msg string) linebot.SendingMessage {
@@ -212,43 +445,107 @@ func newStickerMessage(packageId, stickerId string) linebot.SendingMessage {
 	return linebot.NewStickerMessage(packageId, stickerId)
 }
 
+func newSticker(s config.Sticker) linebot.SendingMessage {
+	return newStickerMessage(s.PackageId, s.StickerId)
+}
+
 func onTimeout(to *timeout.Timeout) {
-	if to.Repeated < 5 { // todo
-		bot.PushMessage(to.RoomId,
-			newTextMessage("おーい。起きてるかー？？"),
-			newStickerMessage("11537", "52002744")).Do()
+	inflight.Add()
+	defer inflight.Done()
+
+	l := sessionLog(to)
+	u := users.User(to.GetMonitoringUserId())
+	if paused(u, clk.Now()) {
+		// went away in the middle of it
+		deleteSession(to.RoomId)
+		recordOutcome(to, to.RoomId, u.Id, "cancelled")
+		l.Info("snooze cancelled. the user is paused.")
+		return
+	}
+	name, lc := lookupUser(u.Id)
+	p := roomPersona(to.RoomId, lc)
+	alerts := alertRooms(to, u)
+	repeated := to.GetRepeated()
+	vars := persona.Vars{
+		Name:        name,
+		Repeated:    repeated,
+		MaxRepeats:  userMaxRepeats(u),
+		AlertRoomId: strings.Join(alerts, ", "),
+	}
+
+	if repeated < vars.MaxRepeats {
+		vars.Repeated++ // counting this one
+		if err := send(to.RoomId, outbox.High, sayWithSticker(p, "snooze", vars)...); err != nil {
+			l.Error("failed to push snooze", "err", err)
+		}
 
-		log.Printf("[info] snooze %d with timeout: %d sec for roomId %s", to.Repeated, to.Sec, to.RoomId)
+		l.Info("snooze", "repeated", repeated, "timeout", to.Sec)
 		to.Snooze()
+		publishSession(to, "snoozed")
 	} else {
 
-		bot.PushMessage(to.RoomId,
-			newTextMessage("もう知らない！\nあずさのバカ！！"),
-			newStickerMessage("3", "193")).Do()
+		messages := sayWithSticker(p, "give_up", vars)
+		if len(alerts) > 0 {
+			messages = append(messages, newTextMessage(say(p, "alert_info", vars)))
+		}
+		if err := send(to.RoomId, outbox.High, messages...); err != nil {
+			l.Error("failed to push giving up", "err", err)
+		}
 
-		if to.AlertRoomId != "" {
-			pushMessage(to.RoomId, fmt.Sprintf("[INFO] ここで ID: %s に通報", to.AlertRoomId))
-			pushMessage(to.AlertRoomId, fmt.Sprintf("%d 回起こしたんですが反応なかったので寝てるかも😇", to.Repeated))
+		for _, roomId := range alerts {
+			pushMessage(roomId, say(roomPersona(roomId, lc), "alert", vars))
 		}
 
-		log.Printf("[info] snooze repeated %d times. finish monitoring.", to.Repeated)
-		delete(snooze, to.RoomId)
+		l.Info("snooze repeated. finish monitoring.", "repeated", repeated)
+		deleteSession(to.RoomId)
+		recordOutcome(to, to.RoomId, to.GetMonitoringUserId(), "escalated")
+		sessionsEscalated.Inc()
 	}
 }
 
-func isHolidayToday() bool {
-	today := time.Now()
+// weekends and public holidays in loc
+func isHolidayToday(loc *time.Location) bool {
+	today := clk.Now().In(loc)
 	return today.Weekday() == 0 || today.Weekday() == 6 || flagday.IsPublicHolidayTime(today)
 }
 
-func sendForecast(roomId string) {
+// today's and tomorrow's weather, told the way userId reads it
+func forecastMessage(roomId string, userId string) (linebot.SendingMessage, bool) {
+	name, lc := lookupUser(userId)
+	u := users.User(userId)
+	today := clk.Now().In(userLocation(u))
+
+	// computed here, so it is told even without a forecast
+	sun := ""
+	lat, lon := userCoords(u)
+	if rise, ok := solar.Sunrise(today, lat, lon); ok {
+		rise = rise.Round(time.Minute)
+		sun = lc.T("forecast.sunrise", "Sunrise", schedule.Alarm{Hour: rise.Hour(), Minute: rise.Minute()}.Clock())
+	}
+
 	msg := ""
-	list := forecast.Request(130010) // tokyo
+	list, err := requestForecast(userCity(u))
+	if err != nil {
+		logger.Error("failed to fetch forecast", "err", err)
+		forecastFailures.Inc()
+		if sun == "" {
+			return nil, false
+		}
+	}
 
 	for k, v := range list {
-		msg += fmt.Sprintf("%sは %s", v.Date, v.Name)
+		day := lc.T("forecast.today")
+		if k > 0 {
+			day = lc.T("forecast.tomorrow")
+		}
+		weather := v.Name
+		if lc.Has("weather." + v.Name) {
+			weather = lc.T("weather." + v.Name)
+		}
+
+		msg += lc.T("forecast.line", "Day", day, "Weather", weather)
 		if v.TempHigh != "" {
-			msg += fmt.Sprintf(" (%s°C / %s°C)", v.TempHigh, v.TempLow)
+			msg += lc.T("forecast.temp", "High", v.TempHigh, "Low", v.TempLow)
 		}
 
 		// today and tomorrow
@@ -258,22 +555,34 @@ func sendForecast(roomId string) {
 			break
 		}
 	}
+	if sun != "" {
+		msg = strings.TrimSuffix(msg, "\n") + "\n" + sun
+	}
 
-	pushMessage(roomId, msg)
+	return newTextMessage(say(roomPersona(roomId, lc), "forecast", persona.Vars{
+		Name:    name,
+		Date:    formatDate(lc, today),
+		Holiday: holidayName(today),
+		Weather: strings.TrimPrefix(msg, "\n"),
+	})), true
 }
 
 func sendKeepAwake(delay int) {
-	token := os.Getenv("IFTTT_WEBHOOK_TOKEN")
+	token := conf.KeepAwake.IFTTTToken
+	if token == "" {
+		return
+	}
+
 	pingUrl := "https://maker.ifttt.com/trigger/ping-awake-bot/with/key/" + token
 
-	timeout.NewTimeout(func() {
+	timeout.NewTimeout(clk, func() {
 		_, err := http.PostForm(pingUrl, nil)
 
 		if err != nil {
-			log.Print(err)
+			logger.Error("failed to ping for keep-alive", "err", err)
 			return
 		}
 
-		log.Printf("[info] Request ping for keep-alive.")
+		logger.Info("requested ping for keep-alive.")
 	}, delay)
 }
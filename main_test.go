@@ -0,0 +1,1722 @@
+package main
+
+import (
+	"awake-bot/clock"
+	"awake-bot/config"
+	"awake-bot/forecast"
+	"awake-bot/linetest"
+	"awake-bot/logging"
+	"awake-bot/profile"
+	"awake-bot/quota"
+	"awake-bot/schedule"
+	"awake-bot/store"
+	"awake-bot/stream"
+	"awake-bot/timeout"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const (
+	testChannelSecret = "test-channel-secret"
+	testChannelToken  = "test-channel-token"
+	testBotToken      = "test-bot-token"
+)
+
+var jst = time.FixedZone("JST", 9*60*60)
+
+// a Monday which is not a public holiday
+var weekday = time.Date(2026, 10, 19, 7, 0, 0, 0, jst)
+
+func setup(t *testing.T) (*linetest.Server, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+
+	fake := linetest.NewServer(testChannelToken)
+	t.Cleanup(fake.Close)
+
+	lb, err := newBot(testChannelSecret, testChannelToken, linebot.WithEndpointBase(fake.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bot = lb
+	clk = clock.NewFake(weekday)
+	snooze = map[string]*timeout.Timeout{}
+	users, _ = store.Open("")
+	alarmTimers = map[string]clock.Timer{}
+	activeTimers = map[string]clock.Timer{}
+	requestForecast = func(code int) ([]forecast.Forecast, error) {
+		return []forecast.Forecast{
+			{Date: "今日", Name: "晴れ", TempHigh: "20", TempLow: "10"},
+			{Date: "明日", Name: "曇り"},
+		}, nil
+	}
+	conf = config.Default()
+	conf.Push.Token = testBotToken
+	if err := loadMessages(); err != nil {
+		t.Fatal(err)
+	}
+	if err := compilePatterns(); err != nil {
+		t.Fatal(err)
+	}
+	quotaClient = quota.NewClient(http.DefaultClient, fake.URL, testChannelToken)
+	profiles = profile.NewCache(profile.NewClient(http.DefaultClient, fake.URL, testChannelToken), profileTTL)
+	budget = quota.NewTracker(conf.Quota.Thresholds)
+	replyTokens = map[string]replyToken{}
+	outgoing, _ = openOutbox("")
+	startWebhooks()
+	startFeed()
+	startWeb()
+	t.Cleanup(func() {
+		webhooks.Close(context.Background())
+		feed.Close()
+	})
+
+	return fake, newRouter()
+}
+
+// serves req and waits for the webhook events it queued
+func serve(router *gin.Engine, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	webhooks.Wait()
+	return w
+}
+
+func newPushRequest(form url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/push", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}
+
+func TestMessageInvalidSignature(t *testing.T) {
+	_, router := setup(t)
+
+	req := linetest.NewWebhookRequest("wrong-secret", "/message", linetest.TextEvent("U1", "", "/id"))
+
+	if w := serve(router, req); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestMessageId(t *testing.T) {
+	fake, router := setup(t)
+
+	req := linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent("U1", "G1", "/id"))
+
+	if w := serve(router, req); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	replies := fake.Replies()
+	if len(replies) != 1 {
+		t.Fatalf("replies = %d, want 1", len(replies))
+	}
+	if text := replies[0].Messages[0].Text; text != "UserId: U1, GroupId: G1" {
+		t.Errorf("reply = %q", text)
+	}
+}
+
+func TestEventsDispatchedIndependently(t *testing.T) {
+	fake, router := setup(t)
+
+	req := linetest.NewWebhookRequest(testChannelSecret, "/message",
+		linetest.TextEvent("U1", "G1", "/id"),
+		linetest.TextEvent("U2", "G1", "/nope"),
+		linetest.TextEvent("U2", "", "/tz"))
+	serve(router, req)
+
+	replies := fake.Replies()
+	if len(replies) != 3 {
+		t.Fatalf("replies = %d, want 3", len(replies))
+	}
+	texts := []string{}
+	for _, r := range replies {
+		texts = append(texts, r.Messages[0].Text)
+	}
+	if !strings.Contains(strings.Join(texts, "\n"), "/nope") {
+		t.Errorf("unknown command is not answered: %q", texts)
+	}
+}
+
+func TestWebhookDeduplicates(t *testing.T) {
+	fake, router := setup(t)
+
+	event := linetest.TextEvent("U1", "G1", "/id")
+	serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", event))
+	linetest.SetMeta(event, linetest.Meta{WebhookEventID: linetest.MetaOf(event).WebhookEventID, Redelivery: true})
+	w := serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", event))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if n := len(fake.Replies()); n != 1 {
+		t.Errorf("replies = %d, the redelivered event is handled again", n)
+	}
+
+	// without an id, the same message at the same time is the same event
+	linetest.SetMeta(event, linetest.Meta{})
+	serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", event, event))
+	if n := len(fake.Replies()); n != 2 {
+		t.Errorf("replies = %d, want 2", n)
+	}
+
+	// the webhookEventId is what counts when it is sent
+	other := linetest.TextEvent("U2", "G2", "/id")
+	linetest.SetMeta(other, linetest.Meta{WebhookEventID: "event-dup"})
+	again := linetest.TextEvent("U2", "G2", "/id")
+	linetest.SetMeta(again, linetest.Meta{WebhookEventID: "event-dup", Redelivery: true})
+	serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", other, again))
+	if n := len(fake.Replies()); n != 3 {
+		t.Errorf("replies = %d, want 3", n)
+	}
+}
+
+func TestCommandPermissions(t *testing.T) {
+	fake, router := setup(t)
+	conf.Admins = []string{"UADMIN"}
+	say := func(userId, text string) string {
+		fake.Reset()
+		serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent(userId, "G1", text)))
+		if len(fake.Replies()) != 1 {
+			t.Fatalf("%s %q: replies = %d", userId, text, len(fake.Replies()))
+		}
+		return fake.Replies()[0].Messages[0].Text
+	}
+
+	// running a command does not make an owner, /claim does
+	if reply := say("U1", "/help"); strings.Contains(reply, "/persona") {
+		t.Errorf("help before /claim = %q", reply)
+	}
+	if reply := say("U1", "/claim"); !strings.Contains(reply, "オーナーになりました") {
+		t.Errorf("claim = %q", reply)
+	}
+	if reply := say("U2", "/claim"); !strings.Contains(reply, "もうオーナーがいます") {
+		t.Errorf("second claim = %q", reply)
+	}
+	if reply := say("U1", "/help"); !strings.Contains(reply, "/persona") || strings.Contains(reply, "/quota") {
+		t.Errorf("owner help = %q", reply)
+	}
+	if reply := say("U2", "/help"); strings.Contains(reply, "/persona") {
+		t.Errorf("member help = %q", reply)
+	}
+	if reply := say("U2", "/persona gentle"); !strings.Contains(reply, "オーナー") {
+		t.Errorf("member changed the persona: %q", reply)
+	}
+	if reply := say("UADMIN", "/quota"); !strings.Contains(reply, "再送待ち: 0") {
+		t.Errorf("quota = %q", reply)
+	}
+	if reply := say("U1", "/alarm sunrise 平日 5:00-6:00 今日"); !strings.HasPrefix(reply, "使い方: /alarm") {
+		t.Errorf("usage = %q", reply)
+	}
+	if reply := say("U1", "／ヘルプ"); !strings.HasPrefix(reply, "コマンド一覧") {
+		t.Errorf("alias = %q", reply)
+	}
+}
+
+func TestPushInvalidToken(t *testing.T) {
+	fake, router := setup(t)
+
+	w := serve(router, newPushRequest(url.Values{"token": {"wrong"}, "user_id": {"U1"}, "message": {"hi"}}))
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if len(fake.Calls()) != 0 {
+		t.Errorf("unexpected calls: %v", fake.Calls())
+	}
+}
+
+func TestPushMissingParams(t *testing.T) {
+	_, router := setup(t)
+
+	for _, form := range []url.Values{
+		{"token": {testBotToken}, "message": {"hi"}},
+		{"token": {testBotToken}, "user_id": {"U1"}},
+	} {
+		if w := serve(router, newPushRequest(form)); w.Code != http.StatusBadRequest {
+			t.Errorf("%v: status = %d, want %d", form, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestPush(t *testing.T) {
+	fake, router := setup(t)
+
+	w := serve(router, newPushRequest(url.Values{"token": {testBotToken}, "user_id": {"U1"}, "message": {"朝だよ"}}))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// the forecast goes in the same push
+	pushes := fake.Pushes()
+	if len(pushes) != 1 || len(pushes[0].Messages) != 2 {
+		t.Fatalf("pushes = %+v", pushes)
+	}
+	if pushes[0].To != "U1" || pushes[0].Messages[0].Text != "朝だよ" {
+		t.Errorf("push = %+v", pushes[0])
+	}
+	if text := pushes[0].Messages[1].Text; text != "10/19(月)の天気\n今日は 晴れ (20°C / 10°C)\n明日は 曇り\n日の出 5:50" {
+		t.Errorf("forecast = %q", text)
+	}
+}
+
+func TestPushLineError(t *testing.T) {
+	fake, router := setup(t)
+	fake.Fail(linebot.APIEndpointPushMessage, http.StatusBadRequest, 1)
+
+	w := serve(router, newPushRequest(url.Values{"token": {testBotToken}, "user_id": {"U1"}, "message": {"朝だよ"}}))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if len(outgoing.Dead()) != 1 {
+		t.Errorf("dead letters = %d, want 1", len(outgoing.Dead()))
+	}
+}
+
+func TestPushRetried(t *testing.T) {
+	fake, router := setup(t)
+	fake.Fail(linebot.APIEndpointPushMessage, http.StatusTooManyRequests, 2)
+
+	w := serve(router, newPushRequest(url.Values{"token": {testBotToken}, "user_id": {"U1"}, "message": {"朝だよ"}}))
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusAccepted)
+	}
+	if len(fake.Pushes()) != 0 || len(outgoing.Pending()) != 1 {
+		t.Fatalf("pushes = %d, pending = %d", len(fake.Pushes()), len(outgoing.Pending()))
+	}
+
+	// LINE asks for a second, then the backoff has grown to two
+	clk.(*clock.Fake).Advance(time.Second)
+	if len(fake.Pushes()) != 0 {
+		t.Fatal("pushed while LINE is still busy")
+	}
+	clk.(*clock.Fake).Advance(2 * time.Second)
+
+	pushes := fake.Pushes()
+	if len(pushes) != 1 || pushes[0].Messages[0].Text != "朝だよ" {
+		t.Fatalf("pushes = %+v", pushes)
+	}
+	if len(outgoing.Pending()) != 0 || budget.Usage().Used != 1 {
+		t.Errorf("pending = %d, used = %d", len(outgoing.Pending()), budget.Usage().Used)
+	}
+}
+
+func TestFollowAndUnfollow(t *testing.T) {
+	fake, router := setup(t)
+	event := func(e *linebot.Event) {
+		serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", e))
+	}
+
+	event(linetest.ChatEvent(linebot.EventTypeFollow, "U1", ""))
+	replies := fake.Replies()
+	if len(replies) != 1 || len(replies[0].Messages) != 2 || !strings.Contains(replies[0].Messages[1].Text, "/alarm 7:00 平日") {
+		t.Fatalf("replies = %+v", replies)
+	}
+	event(linetest.TextEvent("U1", "", "/alarm 7:30"))
+	if len(alarmTimers) != 1 {
+		t.Fatal("alarm is not set")
+	}
+
+	form := url.Values{"token": {testBotToken}, "user_id": {"U1"}, "message": {"朝だよ"}, "timeout": {"300"}}
+	serve(router, newPushRequest(form))
+	event(linetest.ChatEvent(linebot.EventTypeUnfollow, "U1", ""))
+
+	if _, ok := getSession("U1"); ok {
+		t.Error("snooze goes on after a block")
+	}
+	if len(alarmTimers) != 0 {
+		t.Error("alarm is not paused")
+	}
+	if w := serve(router, newPushRequest(form)); w.Code != http.StatusGone {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusGone)
+	}
+
+	event(linetest.ChatEvent(linebot.EventTypeFollow, "U1", ""))
+	if len(alarmTimers) != 1 || users.Room("U1").Unreachable {
+		t.Error("alarm does not come back after unblocking")
+	}
+}
+
+func TestJoinAndLeave(t *testing.T) {
+	fake, router := setup(t)
+
+	serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.ChatEvent(linebot.EventTypeJoin, "", "G1")))
+	replies := fake.Replies()
+	if len(replies) != 1 || !strings.Contains(replies[0].Messages[1].Text, "このグループ") {
+		t.Fatalf("replies = %+v", replies)
+	}
+
+	form := url.Values{"token": {testBotToken}, "user_id": {"U1"}, "room_id": {"G1"}, "message": {"朝だよ"}, "timeout": {"300"}}
+	serve(router, newPushRequest(form))
+	serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.ChatEvent(linebot.EventTypeLeave, "", "G1")))
+
+	if _, ok := getSession("G1"); ok {
+		t.Error("snooze goes on after leaving")
+	}
+	clk.(*clock.Fake).Advance(10 * time.Minute)
+	if n := len(fake.Pushes()); n != 1 {
+		t.Errorf("pushes = %d, want only the first", n)
+	}
+	if w := serve(router, newPushRequest(form)); w.Code != http.StatusGone {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusGone)
+	}
+}
+
+func TestWakeUp(t *testing.T) {
+	fake, router := setup(t)
+
+	form := url.Values{"token": {testBotToken}, "user_id": {"U1"}, "room_id": {"G1"}, "message": {"朝だよ"}, "timeout": {"300"}}
+	if w := serve(router, newPushRequest(form)); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if _, ok := getSession("G1"); !ok {
+		t.Fatal("snooze is not started")
+	}
+
+	// someone else saying good morning does not count
+	serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent("U2", "G1", "おはよう")))
+	if _, ok := getSession("G1"); !ok {
+		t.Fatal("snooze is stopped by another user")
+	}
+
+	serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent("U1", "G1", "おはよう")))
+	if _, ok := getSession("G1"); ok {
+		t.Fatal("snooze is not stopped")
+	}
+
+	replies := fake.Replies()
+	if len(replies) != 1 || len(replies[0].Messages) != 2 || replies[0].Messages[1].Type != "sticker" {
+		t.Errorf("replies = %+v", replies)
+	}
+}
+
+func TestTimeoutGivesUp(t *testing.T) {
+	fake, _ := setup(t)
+
+	to := &timeout.Timeout{Sec: 300, RoomId: "G1", AlertRoomId: "G2", Repeated: 5}
+	putSession(to)
+
+	onTimeout(to)
+
+	if _, ok := getSession("G1"); ok {
+		t.Error("snooze is not finished")
+	}
+
+	pushes := fake.Pushes()
+	// giving up and telling about the alert in one push
+	if len(pushes) != 2 || len(pushes[0].Messages) != 3 {
+		t.Fatalf("pushes = %+v", pushes)
+	}
+	if pushes[1].To != "G2" {
+		t.Errorf("alert is pushed to %s", pushes[1].To)
+	}
+}
+
+func TestLangCommand(t *testing.T) {
+	fake, router := setup(t)
+
+	for _, text := range []string{"/lang fr", "/lang en", "/tz", "/alarm 7:30 everyday"} {
+		serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent("U1", "", text)))
+	}
+
+	want := []string{
+		"言語 fr には対応していません。使える言語: en, ja",
+		"I'll talk to you in English from now on",
+		"Time zone: Asia/Tokyo (now Mon 10/19 7:00)",
+		"Alarm set for every day at 7:30 (Asia/Tokyo)\nNext: Mon 10/19 7:30 (in 30 minutes)",
+	}
+	replies := fake.Replies()
+	if len(replies) != len(want) {
+		t.Fatalf("replies = %+v", replies)
+	}
+	for i, w := range want {
+		if text := replies[i].Messages[0].Text; text != w {
+			t.Errorf("reply %d = %q, want %q", i, text, w)
+		}
+	}
+}
+
+func TestLocaleFromProfile(t *testing.T) {
+	fake, router := setup(t)
+	fake.SetProfile(profile.Profile{UserID: "U1", DisplayName: "Alex", Language: "en-US"})
+
+	serve(router, newPushRequest(url.Values{"token": {testBotToken}, "user_id": {"U1"}, "message": {"morning"}}))
+	if text := fake.Pushes()[0].Messages[1].Text; text != "Weather for Mon 10/19\nToday: sunny (20°C / 10°C)\nTomorrow: cloudy\nSunrise: 5:50" {
+		t.Errorf("forecast = %q", text)
+	}
+}
+
+func TestPersonaPerRoom(t *testing.T) {
+	fake, router := setup(t)
+	fc := clk.(*clock.Fake)
+	fake.SetProfile(profile.Profile{UserID: "U1", DisplayName: "あずさ"})
+	users.UpdateRoom("G1", func(r *store.Room) { r.Owner = "U1" })
+
+	serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent("U1", "G1", "/persona drill_sergeant")))
+	if text := fake.Replies()[0].Messages[0].Text; text != "本日よりあずさの起床を担当する！覚悟しろ！" {
+		t.Errorf("hello = %q", text)
+	}
+
+	putSession(timeout.New(clk, onTimeout, 300, "G1", "U1", ""))
+	putSession(timeout.New(clk, onTimeout, 300, "G2", "U1", ""))
+	fc.Advance(300 * time.Second)
+
+	prompts := map[string]linetest.Call{}
+	for _, c := range fake.Pushes() {
+		prompts[c.To] = c
+	}
+
+	if m := prompts["G1"].Messages; len(m) != 2 || m[0].Text != "1 回目だ！あずさ、いつまで寝ている！起きろ！！" || m[1].StickerID != "51626501" {
+		t.Errorf("G1 = %+v", m)
+	}
+	// other rooms keep the default persona and the configured sticker
+	if m := prompts["G2"].Messages; len(m) != 2 || m[0].Text != "おーい。起きてるかー？？" || m[1].StickerID != conf.Snooze.Sticker.StickerId {
+		t.Errorf("G2 = %+v", m)
+	}
+}
+
+func TestWakeFlow(t *testing.T) {
+	tests := []struct {
+		name    string
+		start   time.Time
+		tz      string
+		skipped bool
+		prompts []string
+		alertAt string
+	}{
+		{
+			name:    "5 snoozes then an alert on a weekday",
+			start:   time.Date(2026, 10, 19, 6, 55, 0, 0, jst),
+			prompts: []string{"07:00", "07:05", "07:10", "07:15", "07:20"},
+			alertAt: "07:25",
+		},
+		{
+			name:    "skipped on Saturday",
+			start:   time.Date(2026, 10, 24, 6, 55, 0, 0, jst),
+			skipped: true,
+		},
+		{
+			name:    "skipped on 勤労感謝の日",
+			start:   time.Date(2026, 11, 23, 6, 55, 0, 0, jst),
+			skipped: true,
+		},
+		{
+			name:    "skipped on Sunday in the user's timezone",
+			start:   time.Date(2026, 10, 19, 6, 55, 0, 0, jst), // Sunday 14:55 in Los Angeles
+			tz:      "America/Los_Angeles",
+			skipped: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, router := setup(t)
+			fc := clock.NewFake(tt.start)
+			clk = fc
+			outgoing, _ = openOutbox("")
+			if tt.tz != "" {
+				users.UpdateUser("U1", func(u *store.User) { u.TimeZone = tt.tz })
+			}
+
+			prompts := []string{}
+			alertAt := ""
+			fake.Notify = func(c linetest.Call) {
+				switch {
+				case c.Messages[0].Text == "おーい。起きてるかー？？":
+					prompts = append(prompts, fc.Now().Format("15:04"))
+				case c.To == "G2":
+					alertAt = fc.Now().Format("15:04")
+				}
+			}
+
+			form := url.Values{"token": {testBotToken}, "user_id": {"U1"}, "room_id": {"G1"}, "alert_room_id": {"G2"}, "message": {"朝だよ"}, "timeout": {"300"}}
+			w := serve(router, newPushRequest(form))
+			if skipped := strings.Contains(w.Body.String(), `"reason":"holiday"`); skipped != tt.skipped {
+				t.Errorf("response = %d %s", w.Code, w.Body.String())
+			}
+
+			if _, ok := getSession("G1"); ok == tt.skipped {
+				t.Fatalf("snooze started = %v, want %v", ok, !tt.skipped)
+			}
+
+			fc.Advance(time.Hour)
+
+			if tt.skipped {
+				if len(fake.Pushes()) != 0 {
+					t.Errorf("pushes on a holiday: %+v", fake.Pushes())
+				}
+				return
+			}
+
+			if strings.Join(prompts, " ") != strings.Join(tt.prompts, " ") {
+				t.Errorf("prompts at %v, want %v", prompts, tt.prompts)
+			}
+			if alertAt != tt.alertAt {
+				t.Errorf("alert at %q, want %q", alertAt, tt.alertAt)
+			}
+			if _, ok := getSession("G1"); ok {
+				t.Error("snooze is not finished")
+			}
+		})
+	}
+}
+
+func TestTimeZoneCommand(t *testing.T) {
+	fake, router := setup(t)
+
+	for _, text := range []string{"/tz Mars/Olympus", "/tz Asia/Singapore", "/tz"} {
+		serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent("U1", "", text)))
+	}
+
+	replies := fake.Replies()
+	if len(replies) != 3 {
+		t.Fatalf("replies = %+v", replies)
+	}
+	if text := replies[0].Messages[0].Text; !strings.Contains(text, "分かりません") {
+		t.Errorf("unknown timezone reply = %q", text)
+	}
+	if text := replies[2].Messages[0].Text; text != "タイムゾーン: Asia/Singapore (いま 10/19(月) 6:00)" {
+		t.Errorf("reply = %q", text)
+	}
+	if tz := users.User("U1").TimeZone; tz != "Asia/Singapore" {
+		t.Errorf("timezone = %q", tz)
+	}
+}
+
+func TestJapaneseAlarm(t *testing.T) {
+	fake, router := setup(t)
+	fc := clk.(*clock.Fake)
+	say := func(userId, groupId, text string) {
+		serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent(userId, groupId, text)))
+	}
+	tap := func(userId, groupId, data string) string {
+		serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.PostbackEvent(userId, groupId, data)))
+		replies := fake.Replies()
+		return replies[len(replies)-1].Messages[0].Text
+	}
+	confirm := func() *linetest.Template {
+		replies := fake.Replies()
+		m := replies[len(replies)-1].Messages[0]
+		if m.Type != "template" || m.Template == nil {
+			t.Fatalf("reply = %+v", m)
+		}
+		return m.Template
+	}
+
+	say("U1", "", "/alarm 7:00")
+	say("U1", "", "30分後に起こして")
+	tmpl := confirm()
+	if tmpl.Text != "10/19(月) 7:30 (1回だけ) に起こしますか？\n次は 10/19(月) 7:30" {
+		t.Errorf("question = %q", tmpl.Text)
+	}
+	if users.User("U1").Once != nil {
+		t.Fatal("saved before confirming")
+	}
+
+	yes := tmpl.Actions[0].Data
+	if text := tap("U1", "", yes); text != "アラームを 10/19(月) 7:30 (1回だけ) (Asia/Tokyo) にセットしました\n次は 10/19(月) 7:30 (あと 30 分)" {
+		t.Errorf("reply = %q", text)
+	}
+	if text := tap("U1", "", yes); text != "この確認は期限切れです。もう一度送ってね" {
+		t.Errorf("tapped twice: %q", text)
+	}
+
+	// rings once, the weekday alarm stays
+	fc.Advance(30 * time.Minute)
+	if pushes := fake.Pushes(); len(pushes) == 0 || pushes[0].To != "U1" || pushes[0].Messages[0].Text != "起きる時間だよ⏰" {
+		t.Fatalf("pushes = %+v", pushes)
+	}
+	u := users.User("U1")
+	if u.Once != nil || u.Alarm == nil || u.Alarm.Clock() != "7:00" {
+		t.Errorf("user = %+v", u)
+	}
+	say("U1", "", "おはよう")
+
+	// replacing the weekday alarm
+	say("U1", "", "平日は6時半")
+	tmpl = confirm()
+	if !strings.HasSuffix(tmpl.Text, "(今の 平日 7:00 は解除されます)") {
+		t.Errorf("question = %q", tmpl.Text)
+	}
+	tap("U1", "", tmpl.Actions[0].Data)
+	if a := users.User("U1").Alarm; a == nil || a.Clock() != "6:30" || !a.SkipHolidays {
+		t.Errorf("alarm = %+v", a)
+	}
+
+	// groups: only when asked to wake up
+	replies := len(fake.Replies())
+	say("U2", "G1", "明日は6時")
+	if len(fake.Replies()) != replies {
+		t.Errorf("chat in the group is answered: %+v", fake.Replies()[replies:])
+	}
+	say("U2", "G1", "明日は6時に起こして")
+	tmpl = confirm()
+	if text := tap("U1", "G1", tmpl.Actions[0].Data); text != "この確認は期限切れです。もう一度送ってね" {
+		t.Errorf("someone else confirmed: %q", text)
+	}
+	if text := tap("U2", "G1", tmpl.Actions[1].Data); text != "セットしませんでした" {
+		t.Errorf("reply = %q", text)
+	}
+	if u := users.User("U2"); u.Once != nil {
+		t.Errorf("cancelled alarm is saved: %+v", u.Once)
+	}
+
+	say("U2", "G1", "明日の6時に起こして")
+	tap("U2", "G1", confirm().Actions[0].Data)
+	if a := users.User("U2").Once; a == nil || a.RoomId != "G1" || a.Date != "2026-10-20" {
+		t.Errorf("alarm = %+v", a)
+	}
+
+	say("U1", "", "25時に起こして")
+	if text := fake.Replies()[len(fake.Replies())-1].Messages[0].Text; text != "その時刻はありません。「明日7時に起こして」のように送ってね" {
+		t.Errorf("reply = %q", text)
+	}
+}
+
+func TestTimers(t *testing.T) {
+	fake, router := setup(t)
+	fc := clk.(*clock.Fake)
+	say := func(text string) string {
+		serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent("U1", "", text)))
+		replies := fake.Replies()
+		return replies[len(replies)-1].Messages[0].Text
+	}
+
+	for text, want := range map[string]string{
+		"/timer 3m カップ麺":   "10/19(月) 7:03 にお知らせします (あと 3 分)",
+		"/remind 15:00 会議": "10/19(月) 15:00 に「会議」をお知らせします",
+		"/nap":             "20分後の 10/19(月) 7:20 に起こすね。おやすみ💤",
+		"/timer 25h":       "時間は 3m や 1h30m のように、24 時間以内で指定してね",
+		"/nap 0":           "分は 1〜180 で指定してね",
+	} {
+		if reply := say(text); reply != want {
+			t.Errorf("%s: reply = %q", text, reply)
+		}
+	}
+	if reply := say("/timers"); reply != "タイマー一覧\n1. 10/19(月) 7:03 タイマー カップ麺\n2. 10/19(月) 7:20 昼寝\n3. 10/19(月) 15:00 会議\n/timers off 番号 で取り消せます" {
+		t.Errorf("list = %q", reply)
+	}
+
+	// a restart arms them again, once
+	for _, timer := range activeTimers {
+		timer.Stop()
+	}
+	activeTimers = map[string]clock.Timer{}
+	scheduleTimers()
+
+	fc.Advance(3 * time.Minute)
+	if pushes := fake.Pushes(); len(pushes) != 1 || pushes[0].To != "U1" || pushes[0].Messages[0].Text != "⏲ 時間です カップ麺" {
+		t.Fatalf("pushes = %+v", pushes)
+	}
+
+	// a nap wakes you up like an alarm
+	fc.Advance(17 * time.Minute)
+	if pushes := fake.Pushes(); len(pushes) != 2 || pushes[1].Messages[0].Text != "起きる時間だよ⏰" {
+		t.Fatalf("pushes = %+v", pushes)
+	}
+	if _, ok := getSession("U1"); !ok {
+		t.Error("nap did not start a session")
+	}
+	say("おはよう")
+
+	if reply := say("/timers off 2"); reply != "2 番のタイマーはありません" {
+		t.Errorf("reply = %q", reply)
+	}
+	if reply := say("/timers off 1"); reply != "1 番のタイマーを取り消しました" {
+		t.Errorf("reply = %q", reply)
+	}
+	if reply := say("/timers"); reply != "タイマーはありません" {
+		t.Errorf("list = %q", reply)
+	}
+	fc.Advance(8 * time.Hour)
+	if pushes := fake.Pushes(); len(pushes) != 2 {
+		t.Errorf("cancelled reminder fired: %+v", pushes[2:])
+	}
+}
+
+func TestSleep(t *testing.T) {
+	fake, router := setup(t)
+	fc := clk.(*clock.Fake)
+	send := func(event *linebot.Event) string {
+		n := len(fake.Replies())
+		serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", event))
+		replies := fake.Replies()
+		if len(replies) == n {
+			return ""
+		}
+		messages := replies[len(replies)-1].Messages
+		return messages[len(messages)-1].Text
+	}
+	say := func(text string) string { return send(linetest.TextEvent("U1", "", text)) }
+
+	say("/alarm 7:00")
+	if reply := say("/sleep target 8:00"); reply != "睡眠の目標を 8時間0分 にしました" {
+		t.Errorf("reply = %q", reply)
+	}
+	if reply := say("/sleep stats"); !strings.HasPrefix(reply, "まだ記録がありません") {
+		t.Errorf("reply = %q", reply)
+	}
+
+	// still up at 23:30, told once
+	fc.Advance(16*time.Hour + 30*time.Minute)
+	if reply := say("まだ起きてる"); reply != "そろそろ寝る時間だよ。10/20(火) 7:00 のアラームまで 7時間30分、目標の睡眠には 23:00 に寝ないとね" {
+		t.Errorf("reminder = %q", reply)
+	}
+	if reply := say("まだ起きてる"); reply != "" {
+		t.Errorf("reminded twice: %q", reply)
+	}
+	if reply := say("おやすみ"); reply != "おやすみ💤 次のアラームは 10/20(火) 7:00、7時間30分 眠れるよ" {
+		t.Errorf("reply = %q", reply)
+	}
+
+	// the wake-up reply ends the night
+	fc.Advance(7*time.Hour + 32*time.Minute)
+	if reply := say("おはよう"); reply != "7時間32分 寝たね" {
+		t.Errorf("reply = %q", reply)
+	}
+	if u := users.User("U1"); u.BedAt != nil || len(u.Nights) != 1 {
+		t.Errorf("user = %+v", u)
+	}
+
+	// a sticker, and おはよう outside of a session
+	send(linetest.StickerEvent("U1", "", "11537", "52002771"))
+	fc.Advance(6 * time.Hour)
+	if reply := say("おはよう"); reply != "6時間0分 寝たね" {
+		t.Errorf("reply = %q", reply)
+	}
+
+	want := "直近 2 日の睡眠\n平均 6時間46分 (目標 8時間0分)\n寝不足 2時間28分\n寝る時刻 3:16 ± 226分"
+	if reply := say("/sleep stats"); reply != want {
+		t.Errorf("stats = %q", reply)
+	}
+}
+
+func TestSmartAlarm(t *testing.T) {
+	fake, router := setup(t)
+	fc := clk.(*clock.Fake)
+	say := func(text string) string {
+		serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent("U1", "", text)))
+		replies := fake.Replies()
+		messages := replies[len(replies)-1].Messages
+		return messages[len(messages)-1].Text
+	}
+
+	say("/alarm 7:00")
+	if reply := say("/alarm window 30"); !strings.HasPrefix(reply, "アラームを 平日 6:30-7:00 にしました。") {
+		t.Errorf("reply = %q", reply)
+	}
+	if reply := say("/alarm window 90"); reply != "幅は 0〜60 分で指定してね" {
+		t.Errorf("reply = %q", reply)
+	}
+
+	// asleep at 23:15, the fifth cycle ends at 6:45
+	fc.Advance(16 * time.Hour)
+	if reply := say("おやすみ"); reply != "おやすみ💤 次のアラームは 10/20(火) 6:45、7時間45分 眠れるよ" {
+		t.Errorf("reply = %q", reply)
+	}
+	fc.Advance(7*time.Hour + 44*time.Minute)
+	if len(fake.Pushes()) != 0 {
+		t.Fatalf("rang early: %+v", fake.Pushes())
+	}
+	fc.Advance(time.Minute)
+	if pushes := fake.Pushes(); len(pushes) != 1 || pushes[0].Messages[0].Text != "起きる時間だよ⏰" {
+		t.Fatalf("pushes = %+v", pushes)
+	}
+
+	to, ok := getSession("U1")
+	if !ok {
+		t.Fatal("no session")
+	}
+	s := to.Snapshot()
+	if s.Latest.In(conf.Location()).Format("01/02 15:04") != "10/20 07:00" || s.Latest.Sub(s.WakeAt) != 15*time.Minute {
+		t.Errorf("snapshot = %+v", s)
+	}
+
+	fc.Advance(4 * time.Minute)
+	say("おはよう")
+	if o := recentOutcomes()[0]; o.Result != "acknowledged" || o.Early != 15*time.Minute || o.Took != 4*time.Minute {
+		t.Errorf("outcome = %+v", o)
+	}
+
+	// rang today already
+	fc.Advance(time.Hour)
+	if len(fake.Pushes()) != 1 {
+		t.Errorf("rang again at the set time: %+v", fake.Pushes()[1:])
+	}
+	if u := users.User("U1"); u.BedAt != nil || len(u.Nights) != 1 {
+		t.Errorf("user = %+v", u)
+	}
+}
+
+func TestSunAlarm(t *testing.T) {
+	fake, router := setup(t)
+	fc := clk.(*clock.Fake)
+	say := func(text string) string {
+		serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent("U1", "", text)))
+		replies := fake.Replies()
+		messages := replies[len(replies)-1].Messages
+		return messages[len(messages)-1].Text
+	}
+
+	for text, want := range map[string]string{
+		"/alarm sunrise-500":       "時刻は 7:00 か 日の出-30 のように指定してね",
+		"/alarm sunrise 6:30-5:00": "時間の範囲は 5:00-6:30 のように指定してね",
+		"/alarm 7:00 5:00-6:00":    "曜日は 平日 か 毎日 で指定してね",
+		"/alarm 日の出 平日 5:00-6:00":  "アラームを 平日 日の出 (5:00〜6:00) (Asia/Tokyo) にセットしました\n次は 10/20(火) 5:51 (あと 22 時間)",
+	} {
+		if reply := say(text); reply != want {
+			t.Errorf("%s: reply = %q", text, reply)
+		}
+	}
+
+	// the sun rises at 5:51 tomorrow, and it may not ring before 5:30
+	if reply := say("/alarm 日の出-30 毎日 5:30-"); !strings.HasPrefix(reply, "アラームを 毎日 日の出の30分前 (5:30以降) (Asia/Tokyo) にセットしました\n次は 10/20(火) 5:30") {
+		t.Errorf("reply = %q", reply)
+	}
+	if a := users.User("U1").Alarm; a.Lat != 35.6895 || a.Hour != 5 || a.Minute != 30 {
+		t.Errorf("alarm = %+v", a)
+	}
+
+	fc.Advance(22*time.Hour + 29*time.Minute)
+	if len(fake.Pushes()) != 0 {
+		t.Fatalf("rang early: %+v", fake.Pushes())
+	}
+	fc.Advance(time.Minute)
+	pushes := fake.Pushes()
+	if len(pushes) != 1 || len(pushes[0].Messages) != 2 || pushes[0].Messages[0].Text != "起きる時間だよ⏰" {
+		t.Fatalf("pushes = %+v", pushes)
+	}
+	if text := pushes[0].Messages[1].Text; !strings.HasSuffix(text, "\n明日は 曇り\n日の出 5:51") {
+		t.Errorf("forecast = %q", text)
+	}
+	say("おはよう")
+
+	// the forecast fails, but the sunrise is still told
+	requestForecast = func(code int) ([]forecast.Forecast, error) { return nil, errors.New("down") }
+	fc.Advance(24 * time.Hour)
+	if pushes := fake.Pushes(); len(pushes) != 2 || pushes[1].Messages[1].Text != "10/21(水)の天気\n日の出 5:52" {
+		t.Errorf("pushes = %+v", pushes)
+	}
+}
+
+func TestPause(t *testing.T) {
+	fake, router := setup(t)
+	fc := clk.(*clock.Fake)
+	say := func(text string) string {
+		serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent("U1", "", text)))
+		replies := fake.Replies()
+		messages := replies[len(replies)-1].Messages
+		return messages[len(messages)-1].Text
+	}
+	push := func() *httptest.ResponseRecorder {
+		return serve(router, newPushRequest(url.Values{"token": {testBotToken}, "user_id": {"U1"}, "message": {"朝だよ"}, "timeout": {"300"}}))
+	}
+
+	for text, want := range map[string]string{
+		"/vacation":          "お休みの予定はありません。/vacation 10/20-10/27 か /pause 3d で設定できます",
+		"/vacation 2/30":     "日付は /vacation 10/20-10/27 のように、90日までで指定してね",
+		"/vacation 10/1-1/5": "日付は /vacation 10/20-10/27 のように、90日までで指定してね",
+		"/pause 0":           "日数は /pause 3d のように 1〜90 日で指定してね",
+		"/resume":            "お休みの予定はありません。/vacation 10/20-10/27 か /pause 3d で設定できます",
+	} {
+		if reply := say(text); reply != want {
+			t.Errorf("%s: reply = %q", text, reply)
+		}
+	}
+
+	say("/alarm 7:00 毎日")
+	if reply := say("/vacation 10/20〜10/22"); reply != "10/20(火)〜10/22(木) はお休みです。アラームも連絡もしません。10/23(金) から再開します" {
+		t.Errorf("reply = %q", reply)
+	}
+	if reply := say("/alarm"); !strings.Contains(reply, "次は 10/23(金) 7:00") {
+		t.Errorf("alarm = %q", reply)
+	}
+
+	// away: no alarms, and pushes tell why they did nothing
+	fc.Advance(24 * time.Hour)
+	if w := push(); w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"reason":"paused","result":"skipped","until":"2026-10-23T00:00:00+09:00"}` {
+		t.Errorf("push = %d %s", w.Code, w.Body.String())
+	}
+	fc.Advance(2*24*time.Hour + time.Hour)
+	if len(fake.Pushes()) != 0 {
+		t.Fatalf("pushes while away: %+v", fake.Pushes())
+	}
+
+	// back on 10/23, welcomed with the alarm
+	fc.Advance(23 * time.Hour)
+	pushes := fake.Pushes()
+	if len(pushes) != 1 || pushes[0].Messages[0].Text != "起きる時間だよ⏰" {
+		t.Fatalf("pushes = %+v", pushes)
+	}
+	messages := pushes[0].Messages
+	if text := messages[len(messages)-1].Text; text != "おかえりなさい！3日間のお休みはどうだった？今日からまた起こすね☀️" {
+		t.Errorf("welcome = %q", text)
+	}
+	if users.User("U1").Pause != nil {
+		t.Error("the pause is kept")
+	}
+
+	// pausing stops the running alarm
+	if reply := say("/pause 2d"); reply != "お休み中です (10/23(金)〜10/24(土))。アラームも連絡もしません。10/25(日) から再開します。/resume ですぐに再開できます" {
+		t.Errorf("reply = %q", reply)
+	}
+	if _, ok := getSession("U1"); ok {
+		t.Error("the session is kept")
+	}
+	if reply := say("/resume"); reply != "アラームを再開しました\n次は 10/24(土) 7:00" {
+		t.Errorf("reply = %q", reply)
+	}
+
+	// going away while a session is running cancels it before anyone is alerted
+	fc.Advance(time.Hour)
+	push()
+	users.UpdateUser("U1", func(u *store.User) { u.Pause = &store.Pause{From: fc.Now(), Until: fc.Now().Add(24 * time.Hour)} })
+	n := len(fake.Pushes())
+	fc.Advance(time.Hour)
+	if len(fake.Pushes()) != n {
+		t.Errorf("snoozed while away: %+v", fake.Pushes()[n:])
+	}
+	if _, ok := getSession("U1"); ok {
+		t.Error("the session is kept")
+	}
+	if o := recentOutcomes()[0]; o.Result != "cancelled" {
+		t.Errorf("outcome = %+v", o)
+	}
+
+	// nor does a nap ring
+	say("/nap 20")
+	fc.Advance(30 * time.Minute)
+	if len(fake.Pushes()) != n {
+		t.Errorf("nap rang while away: %+v", fake.Pushes()[n:])
+	}
+	if _, ok := getSession("U1"); ok {
+		t.Error("nap started a session while away")
+	}
+}
+
+func TestParseVacation(t *testing.T) {
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	now := time.Date(2026, 10, 19, 7, 0, 0, 0, tokyo)
+
+	for s, want := range map[string][2]string{
+		"10/20-10/27": {"2026-10-20", "2026-10-28"},
+		"10/20":       {"2026-10-20", "2026-10-21"},
+		"10/18-10/19": {"2026-10-18", "2026-10-20"}, // already begun
+		"12/28-1/4":   {"2026-12-28", "2027-01-05"},
+		"1/2-1/3":     {"2027-01-02", "2027-01-04"},
+	} {
+		from, until, err := parseVacation(s, now)
+		if err != nil || from.Format("2006-01-02") != want[0] || until.Format("2006-01-02") != want[1] || until.Hour() != 0 {
+			t.Errorf("parseVacation(%q) = %v, %v, %v", s, from, until, err)
+		}
+	}
+
+	for _, s := range []string{"", "10/20-", "13/1", "10/20-10/21-10/22", "7:00", "10/1-1/5"} {
+		if _, _, err := parseVacation(s, now); err == nil {
+			t.Errorf("parseVacation(%q) is accepted", s)
+		}
+	}
+}
+
+func TestAlarmInUserTimeZone(t *testing.T) {
+	fake, router := setup(t)
+	fc := clk.(*clock.Fake)
+
+	for _, text := range []string{"/tz Asia/Singapore", "/alarm 7:00"} {
+		serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent("U1", "", text)))
+	}
+
+	if text := fake.Replies()[1].Messages[0].Text; text != "アラームを 平日 7:00 (Asia/Singapore) にセットしました\n次は 10/19(月) 7:00 (あと 1 時間)" {
+		t.Errorf("reply = %q", text)
+	}
+
+	// 7:00 in Singapore is 8:00 in Tokyo
+	fc.Advance(59 * time.Minute)
+	if len(fake.Pushes()) != 0 {
+		t.Fatalf("alarm rings early: %+v", fake.Pushes())
+	}
+
+	fc.Advance(time.Minute)
+	pushes := fake.Pushes()
+	if len(pushes) != 1 || len(pushes[0].Messages) != 2 {
+		t.Fatalf("pushes = %+v", pushes)
+	}
+	if pushes[0].To != "U1" || pushes[0].Messages[0].Text != "起きる時間だよ⏰" {
+		t.Errorf("alarm = %+v", pushes[0])
+	}
+	if !strings.HasPrefix(pushes[0].Messages[1].Text, "10/19(月)の天気") {
+		t.Errorf("forecast = %q", pushes[0].Messages[1].Text)
+	}
+
+	// acknowledged in the 1:1 chat
+	serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent("U1", "", "おはよう")))
+	if _, ok := getSession("U1"); ok {
+		t.Error("snooze is not stopped")
+	}
+
+	// rearmed for tomorrow
+	if fc.Pending() != 1 {
+		t.Errorf("pending timers = %d, want 1", fc.Pending())
+	}
+}
+
+func TestWakeUpStopsSnooze(t *testing.T) {
+	fake, router := setup(t)
+	fc := clk.(*clock.Fake)
+
+	form := url.Values{"token": {testBotToken}, "user_id": {"U1"}, "room_id": {"G1"}, "message": {"朝だよ"}, "timeout": {"300"}}
+	serve(router, newPushRequest(form))
+	fc.Advance(7 * time.Minute)
+	// the message with the forecast and a snooze prompt
+	waitFor(t, func() bool { return len(fake.Pushes()) == 2 })
+
+	serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent("U1", "G1", "おはよう")))
+	n := len(fake.Pushes())
+
+	fc.Advance(time.Hour)
+
+	if len(fake.Pushes()) != n {
+		t.Errorf("pushed after waking up: %+v", fake.Pushes()[n:])
+	}
+}
+
+func TestSnoozeRepliesWhenPossible(t *testing.T) {
+	fake, router := setup(t)
+	fc := clk.(*clock.Fake)
+
+	putSession(timeout.New(clk, onTimeout, 300, "G1", "U1", ""))
+
+	// someone else talks in the room just before the prompt
+	fc.Advance(280 * time.Second)
+	serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent("U2", "G1", "起きてー")))
+	fc.Advance(20 * time.Second)
+
+	if len(fake.Pushes()) != 0 || len(fake.Replies()) != 1 || fake.Replies()[0].Messages[0].Text != "おーい。起きてるかー？？" {
+		t.Fatalf("pushes = %+v, replies = %+v", fake.Pushes(), fake.Replies())
+	}
+
+	// the token is used once, and old ones are not used at all
+	serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent("U2", "G1", "起きてー")))
+	fc.Advance(5 * time.Minute)
+	fc.Advance(5 * time.Minute)
+
+	if len(fake.Pushes()) != 2 || len(fake.Replies()) != 1 {
+		t.Errorf("pushes = %d, replies = %d", len(fake.Pushes()), len(fake.Replies()))
+	}
+}
+
+func TestQuota(t *testing.T) {
+	fake, _ := setup(t)
+	conf.Quota.AdminRoom = "GADMIN"
+	fake.SetQuota(10, 7)
+	syncQuota()
+
+	pushes := fake.Pushes()
+	if len(pushes) != 1 || pushes[0].To != "GADMIN" || pushes[0].Messages[0].Text != "⚠ 今月のメッセージ数が上限の 50% に達しました (7 / 10)" {
+		t.Fatalf("pushes = %+v", pushes)
+	}
+
+	// 0.8 and 0.9 at once are told once
+	pushMessage("U1", "1")
+	pushes = fake.Pushes()
+	if len(pushes) != 3 || pushes[2].Messages[0].Text != "⚠ 今月のメッセージ数が上限の 90% に達しました (9 / 10)" {
+		t.Fatalf("pushes = %+v", pushes)
+	}
+
+	// stickers alone are not worth the last messages
+	if err := pushSticker("U1", "1", "2"); err != nil || len(fake.Pushes()) != 3 {
+		t.Errorf("sticker is pushed at 100%%: %v", err)
+	}
+	if err := pushMessage("U1", "2"); err != nil || len(fake.Pushes()) != 4 {
+		t.Errorf("text is not pushed: %v", err)
+	}
+}
+
+func dashboardRequest(method, path string, form url.Values) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("admin", "test-password")
+	return req
+}
+
+func TestRequestLogHidesIds(t *testing.T) {
+	_, router := setup(t)
+	conf.Dashboard.Password = "test-password"
+	var buf bytes.Buffer
+	old := logger
+	logger = logging.New(&buf, logging.Info, logging.Logfmt, nil)
+	defer func() { logger = old }()
+
+	groupId := "C4af4980629f8d85a6d7e2f4d4a3a1b8c"
+	serve(router, dashboardRequest(http.MethodPost, "/admin/sessions/"+groupId+"/cancel", nil))
+	if out := buf.String(); !strings.Contains(out, "path=/admin/sessions/:roomId/cancel") || strings.Contains(out, groupId) {
+		t.Errorf("log = %s", out)
+	}
+}
+
+func TestDashboardAuth(t *testing.T) {
+	_, router := setup(t)
+	if w := serve(router, dashboardRequest(http.MethodGet, "/admin", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("status without a password = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	conf.Dashboard.Password = "test-password"
+	router = newRouter()
+	if w := serve(router, httptest.NewRequest(http.MethodGet, "/admin", nil)); w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	req := dashboardRequest(http.MethodPost, "/admin/test-push", url.Values{"room_id": {"U1"}})
+	req.Header.Set("Origin", "https://evil.example.com")
+	if w := serve(router, req); w.Code != http.StatusForbidden {
+		t.Errorf("cross-origin status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	fake, _ := setup(t)
+	conf.Dashboard.Password = "test-password"
+	router := newRouter()
+	fc := clk.(*clock.Fake)
+
+	u, _ := users.UpdateUser("U2", func(u *store.User) { u.Alarm = &schedule.Alarm{Hour: 6, Minute: 30} })
+	scheduleAlarm(u)
+	form := url.Values{"token": {testBotToken}, "user_id": {"U1"}, "room_id": {"G1"}, "message": {"朝だよ"}, "timeout": {"300"}}
+	serve(router, newPushRequest(form))
+	fc.Advance(5 * time.Minute)
+
+	w := serve(router, dashboardRequest(http.MethodGet, "/admin", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	for _, s := range []string{"<code>G1</code>", "snooze 1/5", "10/19 07:10:00", "毎日 6:30", "10/20 06:30:00", "2 messages this month"} {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("%q is not shown", s)
+		}
+	}
+
+	fake.Reset()
+	if w := serve(router, dashboardRequest(http.MethodPost, "/admin/sessions/G1/snooze", nil)); w.Code != http.StatusSeeOther {
+		t.Fatalf("snooze status = %d", w.Code)
+	}
+	if len(fake.Pushes()) != 1 {
+		t.Errorf("pushes = %d after snoozing now", len(fake.Pushes()))
+	}
+	fc.Advance(4 * time.Minute) // the old timer must not fire too
+	if len(fake.Pushes()) != 1 {
+		t.Errorf("pushes = %d, snoozed twice", len(fake.Pushes()))
+	}
+
+	serve(router, dashboardRequest(http.MethodPost, "/admin/sessions/G1/cancel", nil))
+	if _, ok := getSession("G1"); ok {
+		t.Error("session is not cancelled")
+	}
+	serve(router, dashboardRequest(http.MethodPost, "/admin/test-push", url.Values{"room_id": {"G9"}}))
+	if p := fake.Pushes(); len(p) != 2 || p[1].To != "G9" {
+		t.Errorf("pushes = %+v", p)
+	}
+
+	w = serve(router, dashboardRequest(http.MethodGet, "/admin", nil))
+	if !strings.Contains(w.Body.String(), "<td>cancelled</td>") {
+		t.Error("the cancellation is not among the outcomes")
+	}
+}
+
+// reads Server-Sent Events as "id event room"
+func readEvents(t *testing.T, r *bufio.Reader, n int) []string {
+	t.Helper()
+	list := []string{}
+	var id, typ string
+	for len(list) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("after %v: %v", list, err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "id:"):
+			id = line[3:]
+		case strings.HasPrefix(line, "event:"):
+			typ = line[6:]
+		case strings.HasPrefix(line, "data:"):
+			e := stream.Event{}
+			json.Unmarshal([]byte(line[5:]), &e)
+			list = append(list, id+" "+typ+" "+e.RoomId)
+		}
+	}
+	return list
+}
+
+func openEvents(t *testing.T, url, lastId string) *bufio.Reader {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.SetBasicAuth("admin", "test-password")
+	if lastId != "" {
+		req.Header.Set("Last-Event-ID", lastId)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, content type %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(res.Body)
+}
+
+func TestEventStream(t *testing.T) {
+	_, _ = setup(t)
+	conf.Dashboard.Password = "test-password"
+	router := newRouter()
+	fc := clk.(*clock.Fake)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close) // after the streams are closed
+
+	if w := serve(router, httptest.NewRequest(http.MethodGet, "/api/v1/events", nil)); w.Code != http.StatusUnauthorized {
+		t.Errorf("status without a login = %d", w.Code)
+	}
+
+	g1 := openEvents(t, srv.URL+"/api/v1/events?room=G1", "")
+	all := openEvents(t, srv.URL+"/api/v1/events", "")
+
+	for _, room := range []string{"G2", "G1"} {
+		form := url.Values{"token": {testBotToken}, "user_id": {"U1"}, "room_id": {room}, "message": {"朝だよ"}, "timeout": {"300"}}
+		serve(router, newPushRequest(form))
+	}
+	fc.Advance(5 * time.Minute)
+	serve(router, dashboardRequest(http.MethodPost, "/admin/sessions/G1/cancel", nil))
+
+	want := []string{"2 started G1", "4 snoozed G1", "5 cancelled G1"}
+	if got := readEvents(t, g1, 3); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("G1 events = %v, want %v", got, want)
+	}
+	if got := readEvents(t, all, 5); got[0] != "1 started G2" || got[4] != "5 cancelled G1" {
+		t.Errorf("events = %v", got)
+	}
+
+	// EventSource coming back after the first one
+	again := openEvents(t, srv.URL+"/api/v1/events?room=G1", "2")
+	if got := readEvents(t, again, 2); got[0] != "4 snoozed G1" || got[1] != "5 cancelled G1" {
+		t.Errorf("resumed with %v", got)
+	}
+}
+
+// the token of the link the bot sent for /login
+func loginToken(t *testing.T, fake *linetest.Server, router *gin.Engine, userId, groupId string) string {
+	t.Helper()
+	fake.Reset()
+	serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent(userId, groupId, "/login")))
+	reply := fake.Replies()[0].Messages[0].Text
+	i := strings.Index(reply, "https://awake.example.com/login?token=")
+	if i < 0 {
+		return ""
+	}
+	u, _ := url.Parse(strings.TrimSpace(reply[i:]))
+	return u.Query().Get("token")
+}
+
+func loginRequest(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(url.Values{"token": {token}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestLogin(t *testing.T) {
+	fake, router := setup(t)
+	conf.Web.BaseURL = "https://awake.example.com"
+	conf.Admins = []string{"UADMIN"}
+	fc := clk.(*clock.Fake)
+
+	if token := loginToken(t, fake, router, "U1", "G1"); token != "" {
+		t.Error("login link sent to a group")
+	}
+	token := loginToken(t, fake, router, "U1", "")
+	if token == "" {
+		t.Fatal("no login link")
+	}
+
+	// opening the link, as link previews do, does not use it up
+	for i := 0; i < 2; i++ {
+		if w := serve(router, httptest.NewRequest(http.MethodGet, "/login?token="+url.QueryEscape(token), nil)); w.Code != http.StatusOK {
+			t.Fatalf("login page status = %d", w.Code)
+		}
+	}
+	w := serve(router, loginRequest(token))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/settings" {
+		t.Fatalf("login status = %d, location %q", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookie || !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("cookies = %+v", cookies)
+	}
+	if w := serve(router, loginRequest(token)); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "使用済み") {
+		t.Errorf("second login status = %d", w.Code)
+	}
+
+	withCookie := func(req *http.Request, c *http.Cookie) *http.Request {
+		req.AddCookie(c)
+		return req
+	}
+	if w := serve(router, withCookie(httptest.NewRequest(http.MethodGet, "/settings", nil), cookies[0])); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<code>U1</code>") {
+		t.Errorf("settings status = %d", w.Code)
+	}
+	if w := serve(router, withCookie(httptest.NewRequest(http.MethodGet, "/admin", nil), cookies[0])); w.Code != http.StatusNotFound {
+		t.Errorf("dashboard status for a user = %d", w.Code)
+	}
+	if w := serve(router, httptest.NewRequest(http.MethodGet, "/settings", nil)); w.Code != http.StatusUnauthorized {
+		t.Errorf("settings status without a login = %d", w.Code)
+	}
+
+	// admins get the dashboard
+	w = serve(router, loginRequest(loginToken(t, fake, router, "UADMIN", "")))
+	if w.Header().Get("Location") != "/admin" {
+		t.Fatalf("admin login location = %q", w.Header().Get("Location"))
+	}
+	admin := w.Result().Cookies()[0]
+	if w := serve(router, withCookie(httptest.NewRequest(http.MethodGet, "/admin", nil), admin)); w.Code != http.StatusOK {
+		t.Errorf("dashboard status for an admin = %d", w.Code)
+	}
+
+	fake.SetProfile(profile.Profile{UserID: "UADMIN", DisplayName: "Sam", Language: "en"})
+	if w := serve(router, withCookie(httptest.NewRequest(http.MethodPost, "/logout", nil), admin)); !strings.Contains(w.Body.String(), "Logged out.") {
+		t.Errorf("logout page = %s", w.Body.String())
+	}
+
+	// links and sessions run out
+	late := loginToken(t, fake, router, "U1", "")
+	fc.Advance(conf.Web.LinkTTL)
+	if w := serve(router, loginRequest(late)); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "期限切れ") {
+		t.Errorf("expired link status = %d", w.Code)
+	}
+	fc.Advance(conf.Web.SessionTTL)
+	if w := serve(router, withCookie(httptest.NewRequest(http.MethodGet, "/settings", nil), cookies[0])); w.Code != http.StatusUnauthorized {
+		t.Errorf("settings status after the session ended = %d", w.Code)
+	}
+}
+
+// the settings form as saved for a user who kept the defaults
+func settingsValues() url.Values {
+	return url.Values{
+		"clock": {"6:30"}, "day": {"1", "2", "3", "4", "5"}, "skip_holidays": {"1"},
+		"timezone": {"Asia/Tokyo"}, "city": {"0"}, "persona": {""}, "lang": {""},
+		"max_repeats": {"5"}, "snooze_minutes": {"5"},
+	}
+}
+
+func TestSettings(t *testing.T) {
+	fake, router := setup(t)
+	conf.Web.BaseURL = "https://awake.example.com"
+	fake.SetProfile(profile.Profile{UserID: "U1", DisplayName: "Alex", Language: "en"})
+	cookie := serve(router, loginRequest(loginToken(t, fake, router, "U1", ""))).Result().Cookies()[0]
+	// contacts are chosen from the chats the user talked in
+	for _, g := range []string{"G2", "G3"} {
+		serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent("U1", g, "/id")))
+	}
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/settings", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookie)
+		return serve(router, req)
+	}
+
+	w := post(url.Values{
+		"clock": {"25:00"}, "timezone": {"Mars/Olympus"}, "lang": {"fr"}, "city": {"999"}, "persona": {"nobody"},
+		"max_repeats": {"0"}, "snooze_minutes": {"61"}, "contacts": {"G2\nnot an id\nU1\nG9"},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d", w.Code)
+	}
+	for _, s := range []string{
+		"write the time like 7:00", "at least one day", "Mars/Olympus is unknown", "fr is not available",
+		"choose one of the cities", "nobody does not exist", "between 1 and 20", "between 1 and 60 minutes",
+		"&#34;not an id&#34; is not a user", "your own contact", "G9 is not a chat you talked to me in", `value="25:00"`,
+	} {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("%q is not shown", s)
+		}
+	}
+	if users.User("U1").Alarm != nil {
+		t.Error("invalid settings were saved")
+	}
+
+	form := settingsValues()
+	form.Set("day", "6")
+	form.Add("day", "0")
+	form.Del("skip_holidays")
+	form.Set("city", "270000")
+	form.Set("persona", "gentle")
+	form.Set("max_repeats", "2")
+	form.Set("snooze_minutes", "10")
+	form.Set("ack_phrases", "起きた\n 起きてる \n")
+	form.Set("contacts", "G2\nG3")
+
+	// a preview changes nothing
+	form.Set("action", "preview")
+	w = post(form)
+	if w.Code != http.StatusOK || users.User("U1").Alarm != nil {
+		t.Fatalf("preview status = %d, saved %v", w.Code, users.User("U1").Alarm)
+	}
+	for _, s := range []string{"Sat 10/24 6:30", "every 10 min, 2 times", "<code>G3</code>", "the weather forecast for 大阪", "sticker 11537/52002745"} {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("preview lacks %q", s)
+		}
+	}
+
+	form.Set("action", "save")
+	if w := post(form); w.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d", w.Code)
+	}
+	u := users.User("U1")
+	if u.Alarm == nil || u.Alarm.Clock() != "6:30" || len(u.Alarm.Weekdays) != 2 || u.Alarm.SkipHolidays {
+		t.Errorf("alarm = %+v", u.Alarm)
+	}
+	if u.City != 270000 || u.MaxRepeats != 2 || u.SnoozeSec != 600 || len(u.AckPhrases) != 2 || u.AckPhrases[1] != "起きてる" || len(u.Contacts) != 2 {
+		t.Errorf("user = %+v", u)
+	}
+	if len(u.Rooms) != 2 {
+		t.Errorf("rooms = %v, fields the page does not edit are lost", u.Rooms)
+	}
+	if users.Room("U1").Persona != "gentle" {
+		t.Errorf("persona = %q", users.Room("U1").Persona)
+	}
+	alarmsMu.Lock()
+	_, armed := alarmTimers["U1"]
+	alarmsMu.Unlock()
+	if !armed {
+		t.Error("alarm is not scheduled")
+	}
+
+	// everything the page shows is saved as it was
+	w = serve(router, func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/settings", nil)
+		req.AddCookie(cookie)
+		return req
+	}())
+	for _, s := range []string{`value="6" checked`, `value="0" checked`, `value="270000" selected`, `value="gentle" selected`, "起きた\n起きてる</textarea>"} {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("settings page lacks %q", s)
+		}
+	}
+
+	// a sunrise alarm watches the sun over the user's city
+	form.Set("clock", "sunrise-30")
+	form.Set("between", "5:30-")
+	form.Set("city", "16010")
+	if w := post(form); w.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if a := users.User("U1").Alarm; a == nil || a.Time() != "sunrise-30" || a.Earliest != "5:30" || a.Latest != "" || a.Lat != 43.0642 {
+		t.Errorf("alarm = %+v", a)
+	}
+	w = serve(router, func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/settings", nil)
+		req.AddCookie(cookie)
+		return req
+	}())
+	for _, s := range []string{`value="sunrise-30"`, `value="5:30-"`} {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("settings page lacks %q", s)
+		}
+	}
+	form.Set("clock", "7:00")
+	if w := post(form); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "only for sunrise and sunset alarms") {
+		t.Errorf("range on a clock alarm: status = %d", w.Code)
+	}
+
+	form.Set("clock", "")
+	form.Del("between")
+	post(form)
+	if users.User("U1").Alarm != nil {
+		t.Error("alarm is not turned off")
+	}
+}
+
+func TestEscalationSettings(t *testing.T) {
+	fake, router := setup(t)
+	fc := clk.(*clock.Fake)
+	users.UpdateUser("U1", func(u *store.User) {
+		u.MaxRepeats = 1
+		u.SnoozeSec = 60
+		u.AckPhrases = []string{"起きた"}
+		u.Contacts = []string{"G2", "G3"}
+		u.Alarm = &schedule.Alarm{Hour: 7, Minute: 1}
+	})
+	scheduleAlarm(users.User("U1"))
+
+	fc.Advance(time.Minute) // rings
+	fc.Advance(time.Minute) // the only snooze
+	fake.Reset()
+	fc.Advance(time.Minute) // gives up
+	to := map[string]int{}
+	for _, p := range fake.Pushes() {
+		to[p.To]++
+	}
+	if to["U1"] != 1 || to["G2"] != 1 || to["G3"] != 1 {
+		t.Errorf("pushes after giving up = %v", to)
+	}
+
+	// again tomorrow, woken up with the user's phrase
+	fc.Advance(24*time.Hour - 2*time.Minute)
+	if _, ok := getSession("U1"); !ok {
+		t.Fatal("alarm did not ring")
+	}
+	serve(router, linetest.NewWebhookRequest(testChannelSecret, "/message", linetest.TextEvent("U1", "", "起きたよ")))
+	if _, ok := getSession("U1"); ok {
+		t.Error("the user's phrase did not stop the session")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	fake, router := setup(t)
+	fake.Fail(linebot.APIEndpointPushMessage, http.StatusTooManyRequests, 1)
+
+	serve(router, newPushRequest(url.Values{"token": {testBotToken}, "user_id": {"U1"}, "message": {"朝だよ"}}))
+
+	w := serve(router, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	for _, s := range []string{
+		"# TYPE awake_bot_active_sessions gauge",
+		`awake_bot_line_errors_total{endpoint="/v2/bot/message/push",code="429"}`,
+		`awake_bot_line_request_seconds_count{endpoint="/v2/bot/message/push"}`,
+	} {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("%q is not exported", s)
+		}
+	}
+}
+
+func TestEndpointLabel(t *testing.T) {
+	path := "/v2/bot/profile/U4af4980629aaaaaaaaaaaaaaaaaaaaaaaa"
+	if l := endpointLabel(path); l != "/v2/bot/profile/:id" {
+		t.Errorf("endpointLabel(%q) = %q", path, l)
+	}
+}
+
+func TestShutdownCheckpointsSessions(t *testing.T) {
+	fake, router := setup(t)
+	fc := clk.(*clock.Fake)
+	path := filepath.Join(t.TempDir(), "sessions.json")
+
+	form := url.Values{"token": {testBotToken}, "user_id": {"U1"}, "room_id": {"G1"}, "alert_room_id": {"G2"}, "message": {"朝だよ"}, "timeout": {"300"}}
+	serve(router, newPushRequest(form))
+	fc.Advance(7 * time.Minute) // one snooze, the next one in 3 min
+
+	if err := shutdown(&http.Server{}, time.Second, path); err != nil {
+		t.Fatal(err)
+	}
+	n := len(fake.Pushes())
+
+	// the process restarts a minute later
+	snooze = map[string]*timeout.Timeout{}
+	fc.Advance(time.Minute)
+
+	if err := restoreSessions(path); err != nil {
+		t.Fatal(err)
+	}
+
+	to, ok := getSession("G1")
+	if !ok {
+		t.Fatal("session is not restored")
+	}
+	if to.GetRepeated() != 1 || to.AlertRoomId != "G2" || to.GetMonitoringUserId() != "U1" {
+		t.Errorf("restored session = %+v", to)
+	}
+
+	fc.Advance(2*time.Minute - time.Second)
+	if len(fake.Pushes()) != n {
+		t.Fatal("resumed session fired too early")
+	}
+
+	fc.Advance(time.Second)
+	if len(fake.Pushes()) != n+1 {
+		t.Fatal("resumed session did not fire")
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("checkpoint is not removed after restoring")
+	}
+}
+
+// a writer the fake LINE server and the test may use at once
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSimulatorPush(t *testing.T) {
+	out := &lockedBuffer{}
+	sim := newSimulator(out, 1)
+	defer sim.close()
+
+	sim.run(strings.NewReader(":room G1\n:push 60 起きて\n:sessions\n:quit\n"))
+
+	for _, s := range []string{"(push answered 200)", "[bot -> G1] 起きて", "G1: user U0001, repeated 0, every 60 sec"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("%q is not in\n%s", s, out)
+		}
+	}
+}
+
+func TestSimulatorConfirmsAlarm(t *testing.T) {
+	out := &lockedBuffer{}
+	sim := newSimulator(out, 1)
+	defer sim.close()
+
+	sim.run(strings.NewReader("明日7時に起こして\n"))
+	webhooks.Wait()
+	m := regexp.MustCompile(`(:postback alarm=confirm&id=\S+)`).FindStringSubmatch(out.String())
+	if m == nil {
+		t.Fatalf("no confirmation to tap in\n%s", out)
+	}
+
+	sim.run(strings.NewReader(m[1] + "\n"))
+	webhooks.Wait()
+	if u := users.User("U0001"); u.Once == nil && u.Alarm == nil {
+		t.Errorf("the alarm is not set:\n%s", out)
+	}
+}
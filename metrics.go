@@ -0,0 +1,100 @@
+package main
+
+import (
+	"awake-bot/metrics"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+var (
+	registry = metrics.NewRegistry()
+
+	activeSessions = registry.NewGauge("awake_bot_active_sessions",
+		"Wake sessions currently waiting for an acknowledgement.")
+	sessionsStarted = registry.NewCounter("awake_bot_sessions_started_total",
+		"Wake sessions started by /push.")
+	sessionsAcknowledged = registry.NewCounter("awake_bot_sessions_acknowledged_total",
+		"Wake sessions stopped by the monitored user.")
+	sessionsEscalated = registry.NewCounter("awake_bot_sessions_escalated_total",
+		"Wake sessions which gave up after the last snooze.")
+	acknowledgeSeconds = registry.NewHistogram("awake_bot_acknowledge_seconds",
+		"Seconds from the first prompt to the acknowledgement.",
+		[]float64{30, 60, 120, 300, 600, 900, 1200, 1800, 3600})
+	sleepSeconds = registry.NewHistogram("awake_bot_sleep_seconds",
+		"Sleep from the bedtime message to the wake-up reply.",
+		[]float64{4 * 3600, 5 * 3600, 6 * 3600, 7 * 3600, 8 * 3600, 9 * 3600, 10 * 3600})
+	lineRequestSeconds = registry.NewHistogram("awake_bot_line_request_seconds",
+		"LINE Messaging API call latency by endpoint.", metrics.DefBuckets, "endpoint")
+	lineErrors = registry.NewCounter("awake_bot_line_errors_total",
+		"Failed LINE Messaging API calls by endpoint and status code.", "endpoint", "code")
+	forecastFailures = registry.NewCounter("awake_bot_forecast_failures_total",
+		"Failed weather forecast fetches.")
+	holidaySkips = registry.NewCounter("awake_bot_holiday_skips_total",
+		"Pushes skipped because of a weekend or a public holiday.")
+	pauseSkips = registry.NewCounter("awake_bot_pause_skips_total",
+		"Pushes skipped because the user is on vacation or paused.")
+	quotaUsed = registry.NewGauge("awake_bot_quota_used",
+		"Messages counted against this month's quota.")
+	quotaLimit = registry.NewGauge("awake_bot_quota_limit",
+		"Monthly message quota, 0 when unlimited.")
+	pushesSaved = registry.NewCounter("awake_bot_pushes_saved_total",
+		"Pushes avoided by replying or by dropping stickers.", "reason")
+	queuedEvents = registry.NewGauge("awake_bot_queued_events",
+		"Webhook events waiting to be handled.")
+	duplicateEvents = registry.NewCounter("awake_bot_duplicate_events_total",
+		"Webhook events dropped because they were delivered before.")
+	outboxPending = registry.NewGauge("awake_bot_outbox_pending",
+		"Messages waiting in the outbox to be retried.")
+	outboxDead = registry.NewCounter("awake_bot_outbox_dead_total",
+		"Messages given up on after failing permanently or too often.")
+	streamClients = registry.NewGauge("awake_bot_stream_clients",
+		"Clients following /api/v1/events.")
+	streamDropped = registry.NewCounter("awake_bot_stream_dropped_total",
+		"Event stream clients disconnected for falling behind.")
+)
+
+// newBot creates a LINE client whose calls are measured.
+func newBot(channelSecret string, channelToken string, options ...linebot.ClientOption) (*linebot.Client, error) {
+	options = append([]linebot.ClientOption{linebot.WithHTTPClient(instrumentedClient())}, options...)
+	return linebot.New(channelSecret, channelToken, options...)
+}
+
+func instrumentedClient() *http.Client {
+	return &http.Client{Transport: instrumentedTransport{http.DefaultTransport}}
+}
+
+type instrumentedTransport struct {
+	base http.RoundTripper
+}
+
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := endpointLabel(req.URL.Path)
+	start := time.Now()
+
+	res, err := t.base.RoundTrip(req)
+	noteRetryAfter(req, res)
+
+	lineRequestSeconds.Observe(time.Since(start).Seconds(), endpoint)
+	if err != nil {
+		lineErrors.Inc(endpoint, "error")
+	} else if res.StatusCode >= 400 {
+		lineErrors.Inc(endpoint, strconv.Itoa(res.StatusCode))
+	}
+
+	return res, err
+}
+
+// replaces user, group and room ids in a path so labels stay bounded
+func endpointLabel(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if len(s) >= 20 {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+
+	c := r.NewCounter("test_errors_total", "Errors.", "endpoint", "code")
+	c.Inc("/push", "429")
+	c.Inc("/push", "429")
+	c.Inc("/reply", `"5xx"`)
+
+	g := r.NewGauge("test_active", "Active things.")
+	g.Set(3)
+
+	h := r.NewHistogram("test_seconds", "Latency.", []float64{1, 10})
+	h.Observe(0.5)
+	h.Observe(5)
+	h.Observe(50)
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP test_errors_total Errors.
+# TYPE test_errors_total counter
+test_errors_total{endpoint="/push",code="429"} 2
+test_errors_total{endpoint="/reply",code="\"5xx\""} 1
+# HELP test_active Active things.
+# TYPE test_active gauge
+test_active 3
+# HELP test_seconds Latency.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="1"} 1
+test_seconds_bucket{le="10"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 55.5
+test_seconds_count 3
+`
+
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUnobservedMetricsAreExported(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Total.")
+
+	var buf bytes.Buffer
+	r.Write(&buf)
+
+	if !strings.Contains(buf.String(), "test_total 0\n") {
+		t.Errorf("got\n%s", buf.String())
+	}
+}
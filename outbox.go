@@ -0,0 +1,120 @@
+package main
+
+import (
+	"awake-bot/outbox"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+var outgoing *outbox.Outbox
+
+// opens the outbox at path, an empty path keeps it in memory
+func openOutbox(path string) (*outbox.Outbox, error) {
+	c := conf.Outbox
+	return outbox.New(lineTransport{}, clk, outbox.Options{
+		Path:        path,
+		Rate:        c.Rate,
+		Burst:       c.Burst,
+		MaxAttempts: c.MaxAttempts,
+		BaseBackoff: c.BaseBackoff,
+		MaxBackoff:  c.MaxBackoff,
+		MaxAge:      c.MaxAge,
+		Timeout:     c.Timeout,
+		OnSent:      onSent,
+		OnDead:      onDead,
+	})
+}
+
+func onSent(it outbox.Item) {
+	if it.Attempts > 1 {
+		logger.Info("message sent after retrying", "room_id", it.To, "attempts", it.Attempts)
+	}
+	updateOutboxMetrics()
+
+	reached := budget.Add(1, clk.Now().In(conf.Location()))
+	updateQuotaMetrics()
+	if it.To != conf.Quota.AdminRoom { // the warnings count but do not warn again
+		warnQuota(reached)
+	}
+}
+
+func onDead(it outbox.Item) {
+	logger.Error("gave up sending a message", "room_id", it.To, "priority", it.Priority, "attempts", it.Attempts, "err", it.LastError)
+	outboxDead.Inc()
+	updateOutboxMetrics()
+}
+
+func updateOutboxMetrics() {
+	outboxPending.Set(float64(len(outgoing.Pending())))
+}
+
+// pushes with the LINE client, telling what is worth retrying
+type lineTransport struct{}
+
+func (lineTransport) Push(ctx context.Context, to string, messages []json.RawMessage) error {
+	ms := make([]linebot.SendingMessage, len(messages))
+	for i, m := range messages {
+		ms[i] = rawMessage(m)
+	}
+
+	ctx, retryAfter := withRetryAfter(ctx)
+	_, err := bot.PushMessage(to, ms...).WithContext(ctx).Do()
+	if err == nil {
+		return nil
+	}
+
+	// 4xx other than 429 will fail the same way next time
+	if e, ok := err.(*linebot.APIError); ok && e.Code != http.StatusTooManyRequests && e.Code < 500 {
+		return err
+	}
+	return &outbox.RetryableError{Err: err, RetryAfter: *retryAfter}
+}
+
+// a message already encoded for the Messaging API
+type rawMessage json.RawMessage
+
+func (rawMessage) Message() {}
+
+func (m rawMessage) WithQuickReplies(*linebot.QuickReplyItems) linebot.SendingMessage {
+	return m
+}
+
+func (m rawMessage) MarshalJSON() ([]byte, error) {
+	return m, nil
+}
+
+func encodeMessages(messages []linebot.SendingMessage) ([]json.RawMessage, error) {
+	raw := make([]json.RawMessage, len(messages))
+	for i, m := range messages {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		raw[i] = b
+	}
+	return raw, nil
+}
+
+type retryAfterKey struct{}
+
+// the returned duration is set from the Retry-After header of the response
+// to a request made with ctx, see noteRetryAfter
+func withRetryAfter(ctx context.Context) (context.Context, *time.Duration) {
+	d := new(time.Duration)
+	return context.WithValue(ctx, retryAfterKey{}, d), d
+}
+
+func noteRetryAfter(req *http.Request, res *http.Response) {
+	d, ok := req.Context().Value(retryAfterKey{}).(*time.Duration)
+	if !ok || res == nil {
+		return
+	}
+	if sec, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && sec > 0 {
+		*d = time.Duration(sec) * time.Second
+	}
+}
@@ -0,0 +1,485 @@
+// Package outbox delivers messages reliably: they are kept in a JSON file
+// until sent, retried with backoff when LINE is unavailable, and kept as
+// dead letters when they keep failing.
+package outbox
+
+import (
+	"awake-bot/clock"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrQueued is returned by Send when the message could not be sent right
+// away and will be retried.
+var ErrQueued = errors.New("outbox: queued for retry")
+
+// maxDead is how many dead letters are kept, the oldest are dropped
+const maxDead = 100
+
+type Priority int
+
+const (
+	Low Priority = iota
+	Normal
+	High
+)
+
+func (p Priority) String() string {
+	switch p {
+	case Low:
+		return "low"
+	case Normal:
+		return "normal"
+	case High:
+		return "high"
+	}
+	return strconv.Itoa(int(p))
+}
+
+// Transport sends messages to a user, group or room.
+type Transport interface {
+	Push(ctx context.Context, to string, messages []json.RawMessage) error
+}
+
+// RetryableError is a failure worth retrying, e.g. 429 or 5xx. Every other
+// error of a Transport is permanent.
+type RetryableError struct {
+	Err        error
+	RetryAfter time.Duration // what the server asked for, 0 if nothing
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+type Item struct {
+	Id        string
+	To        string
+	Messages  []json.RawMessage
+	Priority  Priority
+	Attempts  int
+	CreatedAt time.Time
+	NextAt    time.Time
+	LastError string `json:",omitempty"`
+}
+
+type Options struct {
+	Path        string        // empty keeps everything in memory
+	Rate        float64       // sends per second, 0 for no limit
+	Burst       int           // sends allowed at once
+	MaxAttempts int           // then the item is dead
+	BaseBackoff time.Duration // doubles on every attempt
+	MaxBackoff  time.Duration
+	MaxAge      time.Duration // items older than this are dead, a late wake-up call is useless
+	Timeout     time.Duration // per attempt
+
+	OnSent func(Item)
+	OnDead func(Item)
+}
+
+// Outbox is safe for concurrent use. It runs no goroutine of its own: due
+// items are sent from clock callbacks, which are only armed while some wait.
+type Outbox struct {
+	transport Transport
+	clock     clock.Clock
+	opts      Options
+
+	sending sync.Mutex // one flush at a time
+
+	mu       sync.Mutex
+	data     data
+	seq      int
+	tokens   float64
+	refilled time.Time
+	timer    clock.Timer
+	stopped  bool
+	notify   []func() // OnSent and OnDead calls, made without locks held
+}
+
+type data struct {
+	Queue []*Item
+	Dead  []*Item
+}
+
+// New reads opts.Path, which does not need to exist yet, and schedules the
+// items left in it.
+func New(t Transport, c clock.Clock, opts Options) (*Outbox, error) {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+	if opts.Burst <= 0 {
+		opts.Burst = 1
+	}
+
+	o := &Outbox{transport: t, clock: c, opts: opts, tokens: float64(opts.Burst), refilled: c.Now()}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	o.schedule()
+	o.mu.Unlock()
+	return o, nil
+}
+
+// Send queues messages for to and tries to send them at once. It returns nil
+// when they were sent, ErrQueued when they will be retried, or the error
+// which made them a dead letter.
+func (o *Outbox) Send(to string, p Priority, messages ...json.RawMessage) error {
+	now := o.clock.Now()
+
+	o.mu.Lock()
+	o.seq++
+	it := &Item{
+		Id:        fmt.Sprintf("%d-%d", now.UnixNano(), o.seq),
+		To:        to,
+		Messages:  messages,
+		Priority:  p,
+		CreatedAt: now,
+		NextAt:    now,
+	}
+	o.data.Queue = append(o.data.Queue, it)
+	if err := o.save(); err != nil {
+		o.remove(it)
+		o.mu.Unlock()
+		return err
+	}
+	o.mu.Unlock()
+
+	o.Flush()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch {
+	case o.indexOf(o.data.Queue, it.Id) >= 0:
+		return ErrQueued
+	case it.LastError != "":
+		return errors.New(it.LastError)
+	}
+	return nil
+}
+
+// Flush sends every item due now, highest priority first, as fast as the
+// rate limit allows, and arms the clock for the rest.
+func (o *Outbox) Flush() {
+	o.flush()
+
+	o.mu.Lock()
+	notify := o.notify
+	o.notify = nil
+	o.mu.Unlock()
+
+	for _, f := range notify {
+		f()
+	}
+}
+
+func (o *Outbox) flush() {
+	o.sending.Lock()
+	defer o.sending.Unlock()
+
+	for {
+		o.mu.Lock()
+		it := o.next()
+		o.mu.Unlock()
+		if it == nil {
+			break
+		}
+
+		err := o.push(it)
+
+		o.mu.Lock()
+		o.done(it, err)
+		o.mu.Unlock()
+	}
+
+	o.mu.Lock()
+	o.schedule()
+	o.mu.Unlock()
+}
+
+// Stop disarms the clock. Queued items stay in the file for the next start.
+func (o *Outbox) Stop() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.stopped = true
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+}
+
+// Pending returns the items waiting to be sent, in the order they will be.
+func (o *Outbox) Pending() []Item {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.sort()
+	return copyItems(o.data.Queue)
+}
+
+// Dead returns the items given up on, oldest first.
+func (o *Outbox) Dead() []Item {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return copyItems(o.data.Dead)
+}
+
+// Retry queues a dead letter again as if it were new.
+func (o *Outbox) Retry(id string) error {
+	o.mu.Lock()
+	i := o.indexOf(o.data.Dead, id)
+	if i < 0 {
+		o.mu.Unlock()
+		return fmt.Errorf("outbox: no dead letter %s", id)
+	}
+
+	it := o.data.Dead[i]
+	o.data.Dead = append(o.data.Dead[:i], o.data.Dead[i+1:]...)
+	now := o.clock.Now()
+	it.Attempts, it.CreatedAt, it.NextAt, it.LastError = 0, now, now, ""
+	o.data.Queue = append(o.data.Queue, it)
+	err := o.save()
+	o.mu.Unlock()
+
+	o.Flush()
+	return err
+}
+
+// Discard forgets a dead letter.
+func (o *Outbox) Discard(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	i := o.indexOf(o.data.Dead, id)
+	if i < 0 {
+		return fmt.Errorf("outbox: no dead letter %s", id)
+	}
+	o.data.Dead = append(o.data.Dead[:i], o.data.Dead[i+1:]...)
+	return o.save()
+}
+
+func (o *Outbox) push(it *Item) error {
+	ctx := context.Background()
+	if o.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.opts.Timeout)
+		defer cancel()
+	}
+	return o.transport.Push(ctx, it.To, it.Messages)
+}
+
+// the next item to send, expiring stale ones on the way. nil when nothing is
+// due or the rate limit is reached. o.mu must be held.
+func (o *Outbox) next() *Item {
+	now := o.clock.Now()
+	o.sort()
+
+	busy := map[string]bool{} // keeps the order of messages to the same chat
+	for _, it := range o.data.Queue {
+		if busy[it.To] {
+			continue
+		}
+		busy[it.To] = true
+
+		if o.opts.MaxAge > 0 && now.Sub(it.CreatedAt) > o.opts.MaxAge {
+			o.bury(it, "expired")
+			return o.next()
+		}
+		if it.NextAt.After(now) {
+			continue
+		}
+		if !o.take(now) {
+			return nil
+		}
+		return it
+	}
+	return nil
+}
+
+// records the result of an attempt. o.mu must be held.
+func (o *Outbox) done(it *Item, err error) {
+	it.Attempts++
+
+	var retryable *RetryableError
+	switch {
+	case err == nil:
+		o.remove(it)
+		if o.opts.OnSent != nil {
+			sent := *it
+			o.notify = append(o.notify, func() { o.opts.OnSent(sent) })
+		}
+	case !errors.As(err, &retryable) || it.Attempts >= o.opts.MaxAttempts:
+		o.bury(it, err.Error())
+		return
+	default:
+		it.LastError = err.Error()
+		it.NextAt = o.clock.Now().Add(o.backoff(it.Attempts, retryable.RetryAfter))
+	}
+
+	o.save()
+}
+
+func (o *Outbox) backoff(attempts int, retryAfter time.Duration) time.Duration {
+	d := time.Duration(float64(o.opts.BaseBackoff) * math.Pow(2, float64(attempts-1)))
+	if o.opts.MaxBackoff > 0 && d > o.opts.MaxBackoff {
+		d = o.opts.MaxBackoff
+	}
+	if retryAfter > d {
+		d = retryAfter
+	}
+	return d
+}
+
+// moves it to the dead letters. o.mu must be held.
+func (o *Outbox) bury(it *Item, reason string) {
+	o.remove(it)
+	it.LastError = reason
+	o.data.Dead = append(o.data.Dead, it)
+	if len(o.data.Dead) > maxDead {
+		o.data.Dead = o.data.Dead[len(o.data.Dead)-maxDead:]
+	}
+	o.save()
+
+	if o.opts.OnDead != nil {
+		dead := *it
+		o.notify = append(o.notify, func() { o.opts.OnDead(dead) })
+	}
+}
+
+func (o *Outbox) remove(it *Item) {
+	if i := o.indexOf(o.data.Queue, it.Id); i >= 0 {
+		o.data.Queue = append(o.data.Queue[:i], o.data.Queue[i+1:]...)
+	}
+}
+
+// token bucket. o.mu must be held.
+func (o *Outbox) take(now time.Time) bool {
+	if o.opts.Rate <= 0 {
+		return true
+	}
+
+	o.tokens += now.Sub(o.refilled).Seconds() * o.opts.Rate
+	if max := float64(o.opts.Burst); o.tokens > max {
+		o.tokens = max
+	}
+	o.refilled = now
+
+	if o.tokens < 1 {
+		return false
+	}
+	o.tokens--
+	return true
+}
+
+// arms the clock for the next due item. o.mu must be held.
+func (o *Outbox) schedule() {
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer = nil
+	}
+	if o.stopped || len(o.data.Queue) == 0 {
+		return
+	}
+
+	now := o.clock.Now()
+	at := o.data.Queue[0].NextAt
+	for _, it := range o.data.Queue {
+		if it.NextAt.Before(at) {
+			at = it.NextAt
+		}
+	}
+	if o.opts.Rate > 0 && o.tokens < 1 {
+		refill := o.refilled.Add(time.Duration((1 - o.tokens) / o.opts.Rate * float64(time.Second)))
+		if refill.After(at) {
+			at = refill
+		}
+	}
+
+	d := at.Sub(now)
+	if d < 0 {
+		d = 0
+	}
+	o.timer = o.clock.AfterFunc(d, o.Flush)
+}
+
+// highest priority first, then oldest. o.mu must be held.
+func (o *Outbox) sort() {
+	sort.SliceStable(o.data.Queue, func(i, j int) bool {
+		a, b := o.data.Queue[i], o.data.Queue[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+}
+
+func (o *Outbox) indexOf(items []*Item, id string) int {
+	for i, it := range items {
+		if it.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func copyItems(items []*Item) []Item {
+	c := make([]Item, len(items))
+	for i, it := range items {
+		c[i] = *it
+	}
+	return c
+}
+
+func (o *Outbox) load() error {
+	if o.opts.Path == "" {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(o.opts.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &o.data)
+}
+
+// o.mu must be held
+func (o *Outbox) save() error {
+	if o.opts.Path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(o.data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(o.opts.Path), filepath.Base(o.opts.Path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), o.opts.Path)
+}
@@ -0,0 +1,205 @@
+package outbox
+
+import (
+	"awake-bot/clock"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC)
+
+// answers with errs in order, then succeeds
+type fakeTransport struct {
+	errs []error
+	sent []string // to:message
+	at   []time.Time
+	clk  clock.Clock
+}
+
+func (t *fakeTransport) Push(ctx context.Context, to string, messages []json.RawMessage) error {
+	t.at = append(t.at, t.clk.Now())
+	if len(t.errs) > 0 {
+		err := t.errs[0]
+		t.errs = t.errs[1:]
+		if err != nil {
+			return err
+		}
+	}
+	for _, m := range messages {
+		t.sent = append(t.sent, to+":"+string(m))
+	}
+	return nil
+}
+
+func retryable(after time.Duration) error {
+	return &RetryableError{Err: errors.New("429"), RetryAfter: after}
+}
+
+func newOutbox(t *testing.T, opts Options, errs ...error) (*Outbox, *fakeTransport, *clock.Fake) {
+	t.Helper()
+	fc := clock.NewFake(start)
+	tr := &fakeTransport{errs: errs, clk: fc}
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.BaseBackoff == 0 {
+		opts.BaseBackoff = time.Second
+	}
+	o, err := New(tr, fc, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o, tr, fc
+}
+
+func msg(s string) json.RawMessage {
+	b, _ := json.Marshal(s)
+	return b
+}
+
+func TestSendAtOnce(t *testing.T) {
+	sent := 0
+	o, tr, fc := newOutbox(t, Options{OnSent: func(Item) { sent++ }})
+
+	if err := o.Send("U1", High, msg("起きて")); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(tr.sent, " ") != `U1:"起きて"` || sent != 1 {
+		t.Errorf("sent = %v, OnSent = %d", tr.sent, sent)
+	}
+	if len(o.Pending()) != 0 || fc.Pending() != 0 {
+		t.Errorf("pending = %d, timers = %d", len(o.Pending()), fc.Pending())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	o, tr, fc := newOutbox(t, Options{MaxBackoff: 3 * time.Second}, retryable(0), retryable(0), retryable(0), retryable(0))
+
+	if err := o.Send("U1", High, msg("起きて")); err != ErrQueued {
+		t.Fatalf("err = %v, want ErrQueued", err)
+	}
+	fc.Advance(time.Minute)
+
+	want := []time.Duration{0, 1 * time.Second, 3 * time.Second, 6 * time.Second, 9 * time.Second}
+	if len(tr.at) != len(want) {
+		t.Fatalf("attempts = %d, want %d", len(tr.at), len(want))
+	}
+	for i, at := range tr.at {
+		if at.Sub(start) != want[i] {
+			t.Errorf("attempt %d at %s, want %s", i, at.Sub(start), want[i])
+		}
+	}
+	if len(tr.sent) != 1 || len(o.Pending()) != 0 {
+		t.Errorf("sent = %v, pending = %v", tr.sent, o.Pending())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	o, tr, fc := newOutbox(t, Options{}, retryable(30*time.Second))
+
+	o.Send("U1", High, msg("起きて"))
+	fc.Advance(29 * time.Second)
+	if len(tr.sent) != 0 {
+		t.Fatal("Retry-After is not honored")
+	}
+	fc.Advance(time.Second)
+	if len(tr.sent) != 1 {
+		t.Errorf("not retried after Retry-After")
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	dead := []Item{}
+	o, _, fc := newOutbox(t, Options{MaxAttempts: 2, OnDead: func(it Item) { dead = append(dead, it) }},
+		errors.New("400 invalid"), retryable(0), retryable(0))
+
+	if err := o.Send("U1", Normal, msg("a")); err == nil || err == ErrQueued {
+		t.Errorf("permanent error = %v", err)
+	}
+	o.Send("U2", Normal, msg("b"))
+	fc.Advance(time.Minute)
+
+	if len(dead) != 2 || dead[0].LastError != "400 invalid" || dead[1].To != "U2" || dead[1].Attempts != 2 {
+		t.Fatalf("dead = %+v", dead)
+	}
+	if len(o.Dead()) != 2 {
+		t.Errorf("Dead() = %d", len(o.Dead()))
+	}
+
+	if err := o.Retry(dead[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Discard(dead[1].Id); err != nil {
+		t.Fatal(err)
+	}
+	if len(o.Dead()) != 0 || len(o.Pending()) != 0 {
+		t.Errorf("dead = %v, pending = %v", o.Dead(), o.Pending())
+	}
+	if o.Retry("nope") == nil {
+		t.Error("unknown dead letter retried")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	o, tr, fc := newOutbox(t, Options{MaxAge: 10 * time.Second, BaseBackoff: time.Minute}, retryable(0))
+
+	o.Send("U1", High, msg("起きて"))
+	fc.Advance(time.Hour)
+
+	if len(tr.sent) != 0 {
+		t.Error("a stale message is sent")
+	}
+	if d := o.Dead(); len(d) != 1 || d[0].LastError != "expired" {
+		t.Errorf("dead = %+v", d)
+	}
+}
+
+func TestPriorityAndOrder(t *testing.T) {
+	o, tr, fc := newOutbox(t, Options{Rate: 1, Burst: 1}, nil)
+
+	o.Send("U1", Normal, msg("1")) // takes the only token
+	o.Send("G1", Low, msg("quota"))
+	o.Send("U2", Normal, msg("push"))
+	o.Send("U3", High, msg("alarm"))
+	o.Send("U3", High, msg("forecast"))
+	fc.Advance(time.Minute)
+
+	want := `U1:"1" U3:"alarm" U3:"forecast" U2:"push" G1:"quota"`
+	if got := strings.Join(tr.sent, " "); got != want {
+		t.Errorf("sent %s\nwant %s", got, want)
+	}
+	for i, at := range tr.at {
+		if at.Sub(start) != time.Duration(i)*time.Second {
+			t.Errorf("send %d at %s, the rate is 1/s", i, at.Sub(start))
+		}
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	o, _, _ := newOutbox(t, Options{Path: path}, retryable(0), errors.New("400"))
+
+	o.Send("U1", High, msg("起きて"))
+	o.Send("U2", High, msg("bad"))
+	o.Stop()
+
+	fc := clock.NewFake(start.Add(time.Minute))
+	tr := &fakeTransport{clk: fc}
+	o, err := New(tr, fc, Options{Path: path, MaxAttempts: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(o.Dead()) != 1 || len(o.Pending()) != 1 {
+		t.Fatalf("dead = %v, pending = %v", o.Dead(), o.Pending())
+	}
+
+	fc.Advance(0)
+	if strings.Join(tr.sent, " ") != `U1:"起きて"` || len(o.Pending()) != 0 {
+		t.Errorf("sent = %v after restart", tr.sent)
+	}
+}
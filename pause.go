@@ -0,0 +1,186 @@
+package main
+
+import (
+	"awake-bot/i18n"
+	"awake-bot/logging"
+	"awake-bot/store"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const maxPauseDays = 90
+
+var errBadVacation = errors.New("vacation must be like 10/20-10/27")
+
+// whether the user's alarms and escalations are suspended at t
+func paused(u store.User, t time.Time) bool {
+	return u.Pause != nil && !t.Before(u.Pause.From) && t.Before(u.Pause.Until)
+}
+
+// the midnight starting t's day
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// 10/20-10/27, or 10/20 for one day, counted from the next time the last
+// day comes. Until is the midnight after it.
+func parseVacation(s string, now time.Time) (time.Time, time.Time, error) {
+	s = strings.NewReplacer("〜", "-", "~", "-").Replace(s)
+	parts := strings.Split(s, "-")
+	if len(parts) == 1 {
+		parts = append(parts, parts[0])
+	}
+	if len(parts) != 2 {
+		return time.Time{}, time.Time{}, errBadVacation
+	}
+
+	today := startOfDay(now)
+	days := make([]time.Time, 2)
+	for i, p := range parts {
+		d, err := time.ParseInLocation("1/2", strings.TrimSpace(p), now.Location())
+		if err != nil {
+			return time.Time{}, time.Time{}, errBadVacation
+		}
+		days[i] = time.Date(today.Year(), d.Month(), d.Day(), 0, 0, 0, 0, now.Location())
+	}
+	from, last := days[0], days[1]
+	if last.Before(from) {
+		last = last.AddDate(1, 0, 0) // over the new year
+	}
+	if last.Before(today) {
+		from, last = from.AddDate(1, 0, 0), last.AddDate(1, 0, 0)
+	}
+	until := last.AddDate(0, 0, 1)
+	if from.AddDate(0, 0, maxPauseDays).Before(until) {
+		return time.Time{}, time.Time{}, errBadVacation
+	}
+	return from, until, nil
+}
+
+// cancels the user's sessions, so no one is alerted while they are away
+func cancelSessionsOf(l *logging.Logger, userId string) {
+	for _, to := range listSessions() {
+		if to.GetMonitoringUserId() != userId {
+			continue
+		}
+		to.Stop()
+		deleteSession(to.RoomId)
+		recordOutcome(to, to.RoomId, userId, "cancelled")
+		l.Info("snooze cancelled. the user is paused.", "session_id", to.Id)
+	}
+}
+
+// the welcome back message, the first time the user is woken up after a
+// pause ended
+func welcomeBack(l *logging.Logger, userId string) (string, bool) {
+	now := clk.Now()
+	if p := users.User(userId).Pause; p == nil || now.Before(p.Until) {
+		return "", false
+	}
+
+	var p *store.Pause
+	_, err := users.UpdateUser(userId, func(u *store.User) {
+		if u.Pause != nil && !now.Before(u.Pause.Until) {
+			p, u.Pause = u.Pause, nil
+		}
+	})
+	if err != nil {
+		l.Error("failed to clear the pause", "err", err)
+	}
+	if p == nil {
+		return "", false
+	}
+	l.Info("welcomed back", "from", p.From, "until", p.Until)
+	_, lc := lookupUser(userId)
+	return lc.T("pause.welcome_back", "Days", int(math.Round(p.Until.Sub(p.From).Hours()/24))), true
+}
+
+func setPause(l *logging.Logger, u store.User, from, until time.Time, lc *i18n.Locale) string {
+	u, err := users.UpdateUser(u.Id, func(u *store.User) { u.Pause = &store.Pause{From: from, Until: until} })
+	if err != nil {
+		l.Error("failed to save pause", "err", err)
+		return lc.T("save_failed")
+	}
+	l.Info("paused", "from", from, "until", until)
+	scheduleAlarm(u)
+	if paused(u, clk.Now()) {
+		cancelSessionsOf(l, u.Id)
+	}
+	return pauseStatus(u, lc)
+}
+
+func pauseStatus(u store.User, lc *i18n.Locale) string {
+	now := clk.Now()
+	if u.Pause == nil || !now.Before(u.Pause.Until) {
+		return lc.T("pause.none")
+	}
+	loc := userLocation(u)
+	key := "pause.scheduled"
+	if paused(u, now) {
+		key = "pause.paused"
+	}
+	return lc.T(key, "From", formatDate(lc, u.Pause.From.In(loc)),
+		"Last", formatDate(lc, u.Pause.Until.In(loc).AddDate(0, 0, -1)), "Back", formatDate(lc, u.Pause.Until.In(loc)))
+}
+
+// /vacation shows the pause, /vacation 10/20-10/27 sets one and
+// /vacation off is /resume
+func onVacationCommand(event *linebot.Event, args []string, lc *i18n.Locale) string {
+	u := users.User(event.Source.UserID)
+	if len(args) == 0 {
+		return pauseStatus(u, lc)
+	}
+	if args[0] == "off" {
+		return onResumeCommand(event, nil, lc)
+	}
+
+	from, until, err := parseVacation(args[0], clk.Now().In(userLocation(u)))
+	if err != nil {
+		return lc.T("pause.bad_vacation", "Max", maxPauseDays)
+	}
+	return setPause(logger.With("user_id", u.Id), u, from, until, lc)
+}
+
+// /pause [days], from now to the end of the last day, today by default
+func onPauseCommand(event *linebot.Event, args []string, lc *i18n.Locale) string {
+	u := users.User(event.Source.UserID)
+	days := 1
+	if len(args) > 0 {
+		n, err := strconv.Atoi(strings.TrimRight(args[0], "d日"))
+		if err != nil || n < 1 || n > maxPauseDays {
+			return lc.T("pause.bad_days", "Max", maxPauseDays)
+		}
+		days = n
+	}
+
+	now := clk.Now().In(userLocation(u))
+	return setPause(logger.With("user_id", u.Id), u, now, startOfDay(now).AddDate(0, 0, days), lc)
+}
+
+// /resume ends the pause now
+func onResumeCommand(event *linebot.Event, args []string, lc *i18n.Locale) string {
+	u := users.User(event.Source.UserID)
+	if u.Pause == nil {
+		return lc.T("pause.none")
+	}
+
+	u, err := users.UpdateUser(u.Id, func(u *store.User) { u.Pause = nil })
+	if err != nil {
+		logger.Error("failed to clear the pause", "user_id", u.Id, "err", err)
+		return lc.T("save_failed")
+	}
+	logger.Info("resumed", "user_id", u.Id)
+	scheduleAlarm(u)
+
+	next := ""
+	if alarm, at := nextAlarm(u, clk.Now()); alarm != nil {
+		next = formatDateTime(lc, at.In(userLocation(u)))
+	}
+	return lc.T("pause.resumed", "Next", next)
+}
@@ -0,0 +1,172 @@
+// Package persona loads what the bot says from message catalogs, one JSON
+// file per persona, so lines can be changed without rebuilding.
+package persona
+
+import (
+	"awake-bot/config"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Vars are available to every message template.
+type Vars struct {
+	Name        string // display name of the user, may be empty
+	Date        string // e.g. 10/19(月)
+	Holiday     string // name of today's public holiday, if any
+	Weather     string // today's and tomorrow's forecast
+	Repeated    int    // snoozes so far
+	MaxRepeats  int
+	AlertRoomId string
+}
+
+// Keys every catalog must define.
+var Keys = []string{
+	"hello",      // when chosen with /persona
+	"alarm",      // an alarm rings
+	"snooze",     // the user does not answer yet
+	"give_up",    // the user did not answer at all
+	"alert_info", // telling the room the alert room is notified
+	"alert",      // to the alert room
+	"awake",      // the user answered
+	"forecast",
+}
+
+type file struct {
+	Name        string                    `json:"name"`
+	Description string                    `json:"description"`
+	Messages    map[string]string         `json:"messages"`
+	Stickers    map[string]config.Sticker `json:"stickers"`
+}
+
+type Persona struct {
+	Id          string // the file name without .json
+	Name        string
+	Description string
+
+	messages map[string]*template.Template
+	stickers map[string]config.Sticker
+}
+
+// Text renders the message for key.
+func (p *Persona) Text(key string, v Vars) (string, error) {
+	t, ok := p.messages[key]
+	if !ok {
+		return "", fmt.Errorf("persona %s: no message %q", p.Id, key)
+	}
+
+	var b bytes.Buffer
+	if err := t.Execute(&b, v); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// Sticker returns the sticker sent along with key, if the persona has one.
+func (p *Persona) Sticker(key string) (config.Sticker, bool) {
+	s, ok := p.stickers[key]
+	return s, ok
+}
+
+type Catalog struct {
+	personas map[string]*Persona
+	def      string
+}
+
+// Load reads every dir/*.json. def must be one of them.
+func Load(dir string, def string) (*Catalog, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Catalog{personas: map[string]*Persona{}, def: def}
+	errs := config.Errors{}
+	for _, path := range paths {
+		p, err := loadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.personas[p.Id] = p
+	}
+
+	if _, ok := c.personas[def]; !ok && len(errs) == 0 {
+		errs = append(errs, fmt.Errorf("persona %q is not found in %s", def, dir))
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return c, nil
+}
+
+func loadFile(path string) (*Persona, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var pf file
+	if err := json.NewDecoder(f).Decode(&pf); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	p := &Persona{
+		Id:          strings.TrimSuffix(filepath.Base(path), ".json"),
+		Name:        pf.Name,
+		Description: pf.Description,
+		messages:    map[string]*template.Template{},
+		stickers:    pf.Stickers,
+	}
+	if p.Name == "" {
+		p.Name = p.Id
+	}
+
+	for _, key := range Keys {
+		text, ok := pf.Messages[key]
+		if !ok {
+			return nil, fmt.Errorf("%s: message %q is missing", path, key)
+		}
+
+		t, err := template.New(key).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		// catches unknown variables now rather than at 7 am
+		if err := t.Execute(&bytes.Buffer{}, Vars{}); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		p.messages[key] = t
+	}
+
+	return p, nil
+}
+
+// Get returns the persona, or the default one when id is unknown.
+func (c *Catalog) Get(id string) *Persona {
+	if p, ok := c.personas[id]; ok {
+		return p
+	}
+	return c.personas[c.def]
+}
+
+func (c *Catalog) Has(id string) bool {
+	_, ok := c.personas[id]
+	return ok
+}
+
+// List returns every persona ordered by id.
+func (c *Catalog) List() []*Persona {
+	list := []*Persona{}
+	for _, p := range c.personas {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list
+}
@@ -0,0 +1,91 @@
+package persona
+
+import (
+	"awake-bot/config"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBundledPersonas(t *testing.T) {
+	c, err := Load("../personas/ja", "tsundere")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{}
+	for _, p := range c.List() {
+		ids = append(ids, p.Id)
+	}
+	if strings.Join(ids, " ") != "drill_sergeant gentle tsundere" {
+		t.Errorf("personas = %v", ids)
+	}
+
+	p := c.Get("tsundere")
+	if text, _ := p.Text("give_up", Vars{Name: "あずさ"}); text != "もう知らない！\nあずさのバカ！！" {
+		t.Errorf("give_up = %q", text)
+	}
+	if text, _ := p.Text("forecast", Vars{Date: "11/23(月)", Holiday: "勤労感謝の日", Weather: "今日は 晴れ"}); text != "11/23(月)（勤労感謝の日）の天気\n今日は 晴れ" {
+		t.Errorf("forecast = %q", text)
+	}
+	if _, ok := p.Sticker("snooze"); ok {
+		t.Error("tsundere uses the configured stickers")
+	}
+
+	if s, ok := c.Get("gentle").Sticker("awake"); !ok || s != (config.Sticker{PackageId: "11537", StickerId: "52002734"}) {
+		t.Errorf("gentle awake sticker = %v, %v", s, ok)
+	}
+	if c.Get("unknown") != p {
+		t.Error("unknown persona does not fall back to the default")
+	}
+}
+
+func TestLoadRejectsBrokenCatalogs(t *testing.T) {
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "typo.json"), []byte(`{"messages": {"hello": "{{.Nmae}}"}}`), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "short.json"), []byte(`{"messages": {}}`), 0600)
+
+	_, err := Load(dir, "typo")
+	if err == nil {
+		t.Fatal("broken catalogs are accepted")
+	}
+	for _, s := range []string{"typo.json", "Nmae", "short.json", `"hello" is missing`} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("%q is not reported in\n%s", s, err)
+		}
+	}
+
+	if _, err := Load("../personas/ja", "nobody"); err == nil {
+		t.Error("unknown default is accepted")
+	}
+}
+
+func TestEveryLocaleHasThePersonas(t *testing.T) {
+	dirs, _ := filepath.Glob("../personas/*")
+	if len(dirs) < 2 {
+		t.Fatalf("locales = %v", dirs)
+	}
+
+	ids := func(c *Catalog) string {
+		s := []string{}
+		for _, p := range c.List() {
+			s = append(s, p.Id)
+		}
+		return strings.Join(s, " ")
+	}
+
+	want := ""
+	for _, dir := range dirs {
+		c, err := Load(dir, "tsundere")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want == "" {
+			want = ids(c)
+		}
+		if got := ids(c); got != want {
+			t.Errorf("%s has %s, want %s", dir, got, want)
+		}
+	}
+}
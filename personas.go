@@ -0,0 +1,119 @@
+package main
+
+import (
+	"awake-bot/config"
+	"awake-bot/i18n"
+	"awake-bot/persona"
+	"awake-bot/store"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+	"github.com/pinzolo/flagday"
+)
+
+var personas map[string]*persona.Catalog // locale tag
+
+// loads the locale bundles and the personas of every locale
+func loadMessages() error {
+	b, err := i18n.Load(conf.Locale.Dir, conf.Locale.Default)
+	if err != nil {
+		return err
+	}
+
+	catalogs := map[string]*persona.Catalog{}
+	for _, tag := range b.Tags() {
+		c, err := persona.Load(filepath.Join(conf.Persona.Dir, tag), conf.Persona.Default)
+		if err != nil {
+			return err
+		}
+		catalogs[tag] = c
+	}
+
+	locales, personas = b, catalogs
+	return nil
+}
+
+// the persona talking in roomId
+func roomPersona(roomId string, lc *i18n.Locale) *persona.Persona {
+	return personas[lc.Tag].Get(users.Room(roomId).Persona)
+}
+
+// name of the public holiday in Japan, if t is one
+func holidayName(t time.Time) string {
+	h, err := flagday.PublicHolidayTimeOf(t)
+	if err != nil || h == nil {
+		return ""
+	}
+	return h.Name()
+}
+
+// renders key, falling back to the default persona when the room's one is broken
+func say(p *persona.Persona, key string, v persona.Vars) string {
+	text, err := p.Text(key, v)
+	if err != nil {
+		logger.Error("failed to render message", "persona", p.Id, "key", key, "err", err)
+		text, _ = personas[locales.Get("").Tag].Get("").Text(key, v)
+	}
+	return text
+}
+
+// the text for key and the sticker that goes with it
+func sayWithSticker(p *persona.Persona, key string, v persona.Vars) []linebot.SendingMessage {
+	messages := []linebot.SendingMessage{newTextMessage(say(p, key, v))}
+	if s, ok := stickerFor(p, key); ok {
+		messages = append(messages, newSticker(s))
+	}
+	return messages
+}
+
+// the persona's sticker for key, or the configured one
+func stickerFor(p *persona.Persona, key string) (config.Sticker, bool) {
+	if s, ok := p.Sticker(key); ok {
+		return s, true
+	}
+	s, ok := defaultStickers()[key]
+	return s, ok
+}
+
+func defaultStickers() map[string]config.Sticker {
+	return map[string]config.Sticker{
+		"snooze":  conf.Snooze.Sticker,
+		"give_up": conf.Snooze.GiveUpSticker,
+		"awake":   conf.Snooze.AwakeSticker,
+	}
+}
+
+// /persona [id]
+func onPersonaCommand(event *linebot.Event, args []string, lc *i18n.Locale) string {
+	roomId := sourceId(event.Source)
+	current := roomPersona(roomId, lc)
+
+	if len(args) == 0 {
+		lines := []string{lc.T("persona.current", "Name", current.Name)}
+		for _, p := range personas[lc.Tag].List() {
+			mark := "  "
+			if p == current {
+				mark = "▶ "
+			}
+			lines = append(lines, fmt.Sprintf("%s%s (%s) %s", mark, p.Id, p.Name, p.Description))
+		}
+		lines = append(lines, lc.T("persona.usage"))
+		return strings.Join(lines, "\n")
+	}
+
+	if !personas[lc.Tag].Has(args[0]) {
+		return lc.T("persona.unknown", "Id", args[0])
+	}
+
+	_, err := users.UpdateRoom(roomId, func(r *store.Room) { r.Persona = args[0] })
+	if err != nil {
+		logger.Error("failed to save persona", "room_id", roomId, "err", err)
+		return lc.T("save_failed")
+	}
+
+	name, _ := lookupUser(event.Source.UserID)
+	return say(personas[lc.Tag].Get(args[0]), "hello", persona.Vars{Name: name})
+}
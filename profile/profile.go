@@ -0,0 +1,109 @@
+// Package profile reads user profiles from the Messaging API, including the
+// language, which the vendored SDK does not decode.
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const APIEndpointProfile = "/v2/bot/profile/"
+
+// Profile of a user who added the bot or shares a chat with it.
+type Profile struct {
+	UserID        string `json:"userId"`
+	DisplayName   string `json:"displayName"`
+	PictureURL    string `json:"pictureUrl,omitempty"`
+	StatusMessage string `json:"statusMessage,omitempty"`
+	Language      string `json:"language,omitempty"` // e.g. ja or en-US, empty when not shared
+}
+
+// Client reads profiles from the Messaging API.
+type Client struct {
+	http         *http.Client
+	endpointBase string
+	channelToken string
+}
+
+func NewClient(c *http.Client, endpointBase string, channelToken string) *Client {
+	return &Client{c, endpointBase, channelToken}
+}
+
+func (c *Client) Get(userId string) (*Profile, error) {
+	req, err := http.NewRequest(http.MethodGet, c.endpointBase+APIEndpointProfile+url.PathEscape(userId), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.channelToken)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("profile: unexpected status %s", res.Status)
+	}
+	p := &Profile{}
+	if err := json.NewDecoder(res.Body).Decode(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Cache keeps profiles for ttl so that every message does not cost a call.
+// It is safe for concurrent use.
+type Cache struct {
+	client *Client
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	entries map[string]entry // userId
+}
+
+type entry struct {
+	profile *Profile
+	expires time.Time
+}
+
+func NewCache(c *Client, ttl time.Duration) *Cache {
+	return &Cache{client: c, ttl: ttl, entries: map[string]entry{}}
+}
+
+// Get returns the cached profile, or fetches it when there is none or it is
+// older than the ttl. Failures are not cached.
+func (c *Cache) Get(userId string, now time.Time) (*Profile, error) {
+	c.mu.Lock()
+	e, ok := c.entries[userId]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.profile, nil
+	}
+
+	p, err := c.client.Get(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, id)
+		}
+	}
+	c.entries[userId] = entry{p, now.Add(c.ttl)}
+	return p, nil
+}
+
+// Forget drops the cached profile, e.g. when the user may have changed it.
+func (c *Cache) Forget(userId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, userId)
+}
@@ -0,0 +1,60 @@
+package profile
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGet(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/bot/profile/U1" || r.Header.Get("Authorization") != "Bearer token" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"userId":"U1","displayName":"Alex","pictureUrl":"https://example.com/a.png","language":"en-US"}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(http.DefaultClient, srv.URL, "token")
+	p, err := c.Get("U1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.DisplayName != "Alex" || p.Language != "en-US" {
+		t.Errorf("profile = %+v", p)
+	}
+
+	if _, err := c.Get("U2"); err == nil {
+		t.Error("unknown user has a profile")
+	}
+}
+
+func TestCache(t *testing.T) {
+	calls := 0
+	name := "Alex"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"userId":"U1","displayName":"` + name + `"}`))
+	}))
+	defer srv.Close()
+
+	c := NewCache(NewClient(http.DefaultClient, srv.URL, "token"), time.Hour)
+	now := time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC)
+
+	c.Get("U1", now)
+	name = "Sam"
+	if p, _ := c.Get("U1", now.Add(59*time.Minute)); p.DisplayName != "Alex" || calls != 1 {
+		t.Errorf("name = %q after %d calls", p.DisplayName, calls)
+	}
+	if p, _ := c.Get("U1", now.Add(time.Hour)); p.DisplayName != "Sam" || calls != 2 {
+		t.Errorf("name = %q after %d calls, want a fetch after the ttl", p.DisplayName, calls)
+	}
+
+	name = "Kim"
+	c.Forget("U1")
+	if p, _ := c.Get("U1", now.Add(time.Hour)); p.DisplayName != "Kim" || calls != 3 {
+		t.Errorf("name = %q after %d calls, want a fetch after Forget", p.DisplayName, calls)
+	}
+}
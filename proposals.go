@@ -0,0 +1,157 @@
+package main
+
+import (
+	"awake-bot/logging"
+	"awake-bot/schedule"
+	"awake-bot/store"
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// how long the confirm buttons work
+const proposalTTL = 10 * time.Minute
+
+// an alarm typed in plain Japanese, waiting for the user to confirm it
+type proposal struct {
+	Id      string
+	Alarm   *schedule.Alarm
+	Expires time.Time
+}
+
+var (
+	proposalsMu sync.Mutex
+	proposals   = map[string]proposal{} // userId, only the latest one
+)
+
+// reads text like 明日7時に起こして and asks the user whether to set it.
+// In groups only text asking to be woken up is read, not every chat.
+func proposeAlarm(l *logging.Logger, event *linebot.Event, text string) bool {
+	userId, roomId := event.Source.UserID, sourceId(event.Source)
+	if userId == "" || roomId != userId && !strings.Contains(text, "起こして") {
+		return false
+	}
+
+	u := users.User(userId)
+	loc := userLocation(u)
+	now := clk.Now()
+	_, lc := lookupUser(userId)
+
+	alarm, err := schedule.ParseJapanese(text, now, loc)
+	switch err {
+	case nil:
+	case schedule.ErrBadTime:
+		bot.ReplyMessage(event.ReplyToken, newTextMessage(lc.T("alarm.no_such_time"))).Do()
+		return true
+	case schedule.ErrPast:
+		bot.ReplyMessage(event.ReplyToken, newTextMessage(lc.T("alarm.past"))).Do()
+		return true
+	default:
+		return false
+	}
+	if roomId != userId {
+		alarm.RoomId = roomId
+	}
+
+	p := proposal{Id: newId(), Alarm: alarm, Expires: now.Add(proposalTTL)}
+	proposalsMu.Lock()
+	proposals[userId] = p
+	proposalsMu.Unlock()
+
+	question := lc.T("alarm.confirm", "Alarm", formatAlarm(lc, alarm), "Next", formatDateTime(lc, alarm.Next(now, loc)))
+	old := u.Alarm
+	if alarm.Once() {
+		old = u.Once
+	}
+	if old != nil && !old.Next(now, loc).IsZero() {
+		question += lc.T("alarm.replaces", "Alarm", formatAlarm(lc, old))
+	}
+
+	yes, no := lc.T("alarm.yes"), lc.T("alarm.no")
+	confirm := linebot.NewConfirmTemplate(question,
+		linebot.NewPostbackAction(yes, "alarm=confirm&id="+p.Id, "", yes),
+		linebot.NewPostbackAction(no, "alarm=cancel&id="+p.Id, "", no))
+	if _, err := bot.ReplyMessage(event.ReplyToken, linebot.NewTemplateMessage(question, confirm)).Do(); err != nil {
+		l.Error("failed to reply", "err", err)
+	}
+
+	l.Info("alarm proposed", "user_id", userId, "alarm", alarm.Clock(), "date", alarm.Date)
+	return true
+}
+
+// the buttons of proposeAlarm
+func onPostback(l *logging.Logger, event *linebot.Event) {
+	if event.Postback == nil {
+		return
+	}
+	v, err := url.ParseQuery(event.Postback.Data)
+	if err != nil || v.Get("alarm") == "" {
+		l.Warn("unknown postback", "data", event.Postback.Data)
+		return
+	}
+
+	userId := event.Source.UserID
+	_, lc := lookupUser(userId)
+	reply := func(key string, args ...interface{}) {
+		bot.ReplyMessage(event.ReplyToken, newTextMessage(lc.T(key, args...))).Do()
+	}
+
+	// buttons work once, and only for whom they were shown
+	proposalsMu.Lock()
+	p, ok := proposals[userId]
+	if ok && p.Id == v.Get("id") {
+		delete(proposals, userId)
+	}
+	proposalsMu.Unlock()
+
+	now := clk.Now()
+	if !ok || p.Id != v.Get("id") || now.After(p.Expires) {
+		reply("alarm.expired")
+		return
+	}
+	if v.Get("alarm") != "confirm" {
+		reply("alarm.cancelled")
+		return
+	}
+
+	u := users.User(userId)
+	loc := userLocation(u)
+	next := p.Alarm.Next(now, loc)
+	if next.IsZero() {
+		reply("alarm.past")
+		return
+	}
+
+	u, err = users.UpdateUser(userId, func(u *store.User) {
+		if p.Alarm.Once() {
+			u.Once = p.Alarm
+			return
+		}
+		if u.Alarm != nil {
+			p.Alarm.Window = u.Alarm.Window
+		}
+		u.Alarm = p.Alarm
+	})
+	if err != nil {
+		l.Error("failed to save alarm", "user_id", userId, "err", err)
+		reply("save_failed")
+		return
+	}
+	scheduleAlarm(u)
+
+	l.Info("alarm confirmed", "user_id", userId, "alarm", p.Alarm.Clock(), "date", p.Alarm.Date)
+	reply("alarm.set", "Alarm", formatAlarm(lc, p.Alarm), "TimeZone", loc,
+		"Next", formatDateTime(lc, next), "Until", formatUntil(lc, next.Sub(now)))
+}
+
+// random, for things users refer to later
+func newId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
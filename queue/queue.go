@@ -0,0 +1,166 @@
+// Package queue runs jobs on a fixed pool of workers, keeping the order of
+// jobs which share a key, and remembers recently seen ids to drop repeats.
+package queue
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrFull   = errors.New("queue: full")
+	ErrClosed = errors.New("queue: closed")
+)
+
+// Pool hashes each key to one worker, so jobs with the same key run one at a
+// time in the order they were pushed.
+type Pool struct {
+	lanes   []chan func()
+	pending sync.WaitGroup // pushed jobs not done yet
+	workers sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewPool starts workers, each queueing up to size jobs.
+func NewPool(workers int, size int) *Pool {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	p := &Pool{lanes: make([]chan func(), workers)}
+	for i := range p.lanes {
+		lane := make(chan func(), size)
+		p.lanes[i] = lane
+		p.workers.Add(1)
+		go func() {
+			defer p.workers.Done()
+			for job := range lane {
+				job()
+				p.pending.Done()
+			}
+		}()
+	}
+	return p
+}
+
+// Push queues job without blocking. It fails with ErrFull when the key's
+// worker is too far behind.
+func (p *Pool) Push(key string, job func()) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrClosed
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	lane := p.lanes[h.Sum32()%uint32(len(p.lanes))]
+
+	p.pending.Add(1)
+	select {
+	case lane <- job:
+		return nil
+	default:
+		p.pending.Done()
+		return ErrFull
+	}
+}
+
+// Len returns how many jobs wait for a worker.
+func (p *Pool) Len() int {
+	n := 0
+	for _, lane := range p.lanes {
+		n += len(lane)
+	}
+	return n
+}
+
+// Wait blocks until every job pushed so far is done.
+func (p *Pool) Wait() {
+	p.pending.Wait()
+}
+
+// Close stops taking jobs and waits for the queued ones until ctx is done.
+func (p *Pool) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, lane := range p.lanes {
+			close(lane)
+		}
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Seen remembers ids for a while. It is safe for concurrent use.
+type Seen struct {
+	window time.Duration
+
+	mu    sync.Mutex
+	ids   map[string]time.Time
+	order []string // oldest first, for expiring
+}
+
+func NewSeen(window time.Duration) *Seen {
+	return &Seen{window: window, ids: map[string]time.Time{}}
+}
+
+// Add records id at now and reports whether it was new within the window.
+func (s *Seen) Add(id string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(now)
+	if _, ok := s.ids[id]; ok {
+		return false
+	}
+	s.ids[id] = now
+	s.order = append(s.order, id)
+	return true
+}
+
+// Forget drops id, e.g. when its event could not be queued and will come again.
+func (s *Seen) Forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.ids, id)
+	for i, v := range s.order {
+		if v == id {
+			s.order = append(s.order[:i:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *Seen) expire(now time.Time) {
+	i := 0
+	for ; i < len(s.order); i++ {
+		id := s.order[i]
+		at, ok := s.ids[id]
+		if ok && now.Sub(at) < s.window {
+			break
+		}
+		if ok {
+			delete(s.ids, id)
+		}
+	}
+	s.order = s.order[i:]
+}
@@ -0,0 +1,107 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolKeepsOrderPerKey(t *testing.T) {
+	p := NewPool(3, 200)
+	defer p.Close(context.Background())
+
+	var mu sync.Mutex
+	got := map[string][]int{}
+	for i := 0; i < 50; i++ {
+		for _, key := range []string{"G1", "G2", "U1", "U2"} {
+			key, i := key, i
+			if err := p.Push(key, func() {
+				mu.Lock()
+				defer mu.Unlock()
+				got[key] = append(got[key], i)
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	p.Wait()
+
+	for key, seq := range got {
+		if len(seq) != 50 {
+			t.Errorf("%s ran %d jobs", key, len(seq))
+		}
+		for i, n := range seq {
+			if n != i {
+				t.Errorf("%s ran out of order: %v", key, seq)
+				break
+			}
+		}
+	}
+}
+
+func TestPoolFullAndClosed(t *testing.T) {
+	p := NewPool(1, 1)
+	block := make(chan struct{})
+
+	p.Push("a", func() { <-block }) // taken by the worker
+	waitFor(t, func() bool { return p.Len() == 0 })
+	if err := p.Push("a", func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Push("a", func() {}); err != ErrFull {
+		t.Errorf("err = %v, want ErrFull", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Close(ctx); err == nil {
+		t.Error("Close does not wait for running jobs")
+	}
+	close(block)
+	if err := p.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if err := p.Push("a", func() {}); err != ErrClosed {
+		t.Errorf("err = %v, want ErrClosed", err)
+	}
+}
+
+func TestSeen(t *testing.T) {
+	s := NewSeen(10 * time.Minute)
+	now := time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC)
+
+	log := []string{}
+	for _, step := range []struct {
+		id    string
+		after time.Duration
+	}{
+		{"a", 0}, {"b", time.Minute}, {"a", 5 * time.Minute}, {"a", 10 * time.Minute}, {"b", 10*time.Minute + time.Second}, {"b", 11 * time.Minute},
+	} {
+		log = append(log, fmt.Sprintf("%s:%v", step.id, s.Add(step.id, now.Add(step.after))))
+	}
+	if got := strings.Join(log, " "); got != "a:true b:true a:false a:true b:false b:true" {
+		t.Errorf("got %s", got)
+	}
+
+	s.Forget("a")
+	if !s.Add("a", now.Add(12*time.Minute)) {
+		t.Error("a forgotten id is still seen")
+	}
+	if len(s.order) != 2 || s.order[1] != "a" {
+		t.Errorf("order = %v, the forgotten a is kept", s.order)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}
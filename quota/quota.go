@@ -0,0 +1,152 @@
+// Package quota tracks how much of the channel's monthly message quota is used.
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	APIEndpointQuota       = "/v2/bot/message/quota"
+	APIEndpointConsumption = "/v2/bot/message/quota/consumption"
+)
+
+// Usage of this month. Limit is 0 when the plan has no limit.
+type Usage struct {
+	Limit int64
+	Used  int64
+}
+
+// Ratio is how much of the limit is used, 0 without a limit.
+func (u Usage) Ratio() float64 {
+	if u.Limit <= 0 {
+		return 0
+	}
+	return float64(u.Used) / float64(u.Limit)
+}
+
+// Client reads the quota from the Messaging API.
+type Client struct {
+	http         *http.Client
+	endpointBase string
+	channelToken string
+}
+
+func NewClient(c *http.Client, endpointBase string, channelToken string) *Client {
+	return &Client{c, endpointBase, channelToken}
+}
+
+func (c *Client) Fetch() (Usage, error) {
+	var q struct {
+		Type  string `json:"type"` // none or limited
+		Value int64  `json:"value"`
+	}
+	if err := c.get(APIEndpointQuota, &q); err != nil {
+		return Usage{}, err
+	}
+
+	var consumption struct {
+		TotalUsage int64 `json:"totalUsage"`
+	}
+	if err := c.get(APIEndpointConsumption, &consumption); err != nil {
+		return Usage{}, err
+	}
+
+	u := Usage{Used: consumption.TotalUsage}
+	if q.Type == "limited" {
+		u.Limit = q.Value
+	}
+	return u, nil
+}
+
+func (c *Client) get(endpoint string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.endpointBase+endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.channelToken)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("quota: %s: unexpected status %s", endpoint, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// Tracker counts messages sent between fetches and reports each threshold
+// once a month. It is safe for concurrent use.
+type Tracker struct {
+	mu         sync.Mutex
+	usage      Usage
+	month      string // 2006-01, the quota resets monthly
+	thresholds []float64
+	reported   map[float64]bool
+}
+
+// NewTracker reports when usage reaches each of thresholds, e.g. 0.8 for 80%.
+func NewTracker(thresholds []float64) *Tracker {
+	t := append([]float64{}, thresholds...)
+	sort.Float64s(t)
+	return &Tracker{thresholds: t, reported: map[float64]bool{}}
+}
+
+// Sync adopts fetched usage and returns thresholds newly reached. now must
+// be in the timezone the quota resets in.
+func (t *Tracker) Sync(u Usage, now time.Time) []float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rollover(now)
+	// the API lags behind, so never go below what was counted here
+	if u.Used < t.usage.Used {
+		u.Used = t.usage.Used
+	}
+	t.usage = u
+	return t.reached()
+}
+
+// Add counts n messages and returns thresholds newly reached.
+func (t *Tracker) Add(n int64, now time.Time) []float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rollover(now)
+	t.usage.Used += n
+	return t.reached()
+}
+
+func (t *Tracker) Usage() Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.usage
+}
+
+func (t *Tracker) rollover(now time.Time) {
+	month := now.Format("2006-01")
+	if month != t.month {
+		t.month = month
+		t.usage.Used = 0
+		t.reported = map[float64]bool{}
+	}
+}
+
+func (t *Tracker) reached() []float64 {
+	r := []float64{}
+	ratio := t.usage.Ratio()
+	for _, th := range t.thresholds {
+		if ratio >= th && !t.reported[th] {
+			t.reported[th] = true
+			r = append(r, th)
+		}
+	}
+	return r
+}
@@ -0,0 +1,62 @@
+package quota
+
+import (
+	"awake-bot/linetest"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+func TestFetch(t *testing.T) {
+	s := linetest.NewServer("token")
+	defer s.Close()
+
+	c := NewClient(http.DefaultClient, s.URL, "token")
+	if u, err := c.Fetch(); err != nil || u != (Usage{0, 0}) {
+		t.Errorf("unlimited = %+v, %v", u, err)
+	}
+
+	s.SetQuota(500, 120)
+	bot, _ := s.Client("secret")
+	bot.PushMessage("U1", linebot.NewTextMessage("hi")).Do()
+
+	if u, err := c.Fetch(); err != nil || u != (Usage{500, 121}) {
+		t.Errorf("limited = %+v, %v", u, err)
+	}
+
+	if _, err := NewClient(http.DefaultClient, s.URL, "wrong").Fetch(); err == nil {
+		t.Error("fetched with a wrong token")
+	}
+}
+
+func TestTracker(t *testing.T) {
+	now := time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC)
+	tr := NewTracker([]float64{0.9, 0.5})
+
+	if r := tr.Sync(Usage{Limit: 10, Used: 4}, now); len(r) != 0 {
+		t.Errorf("reached %v at 40%%", r)
+	}
+	if r := tr.Add(1, now); len(r) != 1 || r[0] != 0.5 {
+		t.Errorf("reached %v at 50%%", r)
+	}
+	if r := tr.Add(1, now); len(r) != 0 {
+		t.Errorf("50%% is reported twice: %v", r)
+	}
+
+	// the API has not caught up with what was sent
+	tr.Sync(Usage{Limit: 10, Used: 5}, now)
+	if u := tr.Usage(); u.Used != 6 {
+		t.Errorf("used = %d, want 6", u.Used)
+	}
+
+	if r := tr.Add(4, now); len(r) != 1 || r[0] != 0.9 {
+		t.Errorf("reached %v at 100%%", r)
+	}
+
+	next := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	if r := tr.Add(5, next); len(r) != 1 || r[0] != 0.5 || tr.Usage().Used != 5 {
+		t.Errorf("after the monthly reset: reached %v, usage %+v", r, tr.Usage())
+	}
+}
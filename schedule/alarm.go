@@ -0,0 +1,216 @@
+// Package schedule computes when recurring alarms ring in a user's timezone.
+package schedule
+
+import (
+	"awake-bot/solar"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Alarm rings at Hour:Minute local time on the given weekdays, or once on
+// Date. With Sun it rings Offset minutes from the sunrise or sunset at
+// Lat/Lon instead, kept between Earliest and Latest, and at Hour:Minute
+// only on days the sun doesn't rise or set.
+type Alarm struct {
+	Hour         int
+	Minute       int
+	Weekdays     []time.Weekday `json:",omitempty"` // every day when empty
+	SkipHolidays bool           `json:",omitempty"`
+	RoomId       string         `json:",omitempty"` // the user's own chat when empty
+	Date         string         `json:",omitempty"` // 2006-01-02, for alarms ringing once
+	Window       int            `json:",omitempty"` // minutes before Hour:Minute it may ring to end a sleep cycle
+	Sun          string         `json:",omitempty"` // Sunrise or Sunset
+	Offset       int            `json:",omitempty"` // minutes after the sun, negative before
+	Earliest     string         `json:",omitempty"` // H:MM, for Sun
+	Latest       string         `json:",omitempty"` // H:MM, for Sun
+	Lat          float64        `json:",omitempty"`
+	Lon          float64        `json:",omitempty"`
+}
+
+const (
+	Sunrise = "sunrise"
+	Sunset  = "sunset"
+
+	maxOffset = 180 // minutes
+)
+
+// Date is written like this
+const DateLayout = "2006-01-02"
+
+var Weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+
+// ParseClock parses "7:00" or "07:30".
+func ParseClock(s string) (int, int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("time must be like 7:00, got %q", s)
+	}
+
+	h, err := strconv.Atoi(parts[0])
+	if err != nil || h < 0 || h > 23 {
+		return 0, 0, fmt.Errorf("invalid hour %q", parts[0])
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || m < 0 || m > 59 || len(parts[1]) != 2 {
+		return 0, 0, fmt.Errorf("invalid minute %q", parts[1])
+	}
+
+	return h, m, nil
+}
+
+// ParseSun parses "sunrise", "sunrise-30", "sunset+15" or the same with
+// 日の出 and 日の入り.
+func ParseSun(s string) (string, int, error) {
+	for _, name := range []struct{ prefix, sun string }{
+		{Sunrise, Sunrise}, {"日の出", Sunrise}, {Sunset, Sunset}, {"日の入り", Sunset}, {"日没", Sunset},
+	} {
+		if !strings.HasPrefix(s, name.prefix) {
+			continue
+		}
+		rest := strings.TrimSuffix(s[len(name.prefix):], "分")
+		if rest == "" {
+			return name.sun, 0, nil
+		}
+		offset, err := strconv.Atoi(rest)
+		if err != nil || rest[0] != '-' && rest[0] != '+' || offset < -maxOffset || offset > maxOffset {
+			return "", 0, fmt.Errorf("offset must be minutes like -30 up to %d, got %q", maxOffset, rest)
+		}
+		return name.sun, offset, nil
+	}
+	return "", 0, fmt.Errorf("not sunrise or sunset: %q", s)
+}
+
+// ParseRange parses "5:00-6:30", "5:00-" or "-6:30", the times a sun alarm
+// is kept between.
+func ParseRange(s string) (string, string, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 || parts[0] == "" && parts[1] == "" {
+		return "", "", fmt.Errorf("range must be like 5:00-6:30, got %q", s)
+	}
+	clocks := make([]int, 2)
+	for i, p := range parts {
+		if p == "" {
+			continue
+		}
+		h, m, err := ParseClock(p)
+		if err != nil {
+			return "", "", err
+		}
+		parts[i] = fmt.Sprintf("%d:%02d", h, m)
+		clocks[i] = h*60 + m
+	}
+	if parts[0] != "" && parts[1] != "" && clocks[0] > clocks[1] {
+		return "", "", fmt.Errorf("%s is later than %s", parts[0], parts[1])
+	}
+	return parts[0], parts[1], nil
+}
+
+func (a Alarm) Clock() string {
+	return fmt.Sprintf("%d:%02d", a.Hour, a.Minute)
+}
+
+// Time is Clock, or like sunrise-30 for sun alarms. ParseSun reads it back.
+func (a Alarm) Time() string {
+	if a.Sun == "" {
+		return a.Clock()
+	}
+	if a.Offset == 0 {
+		return a.Sun
+	}
+	return fmt.Sprintf("%s%+d", a.Sun, a.Offset)
+}
+
+func (a Alarm) RingsOn(d time.Weekday) bool {
+	if len(a.Weekdays) == 0 {
+		return true
+	}
+	for _, w := range a.Weekdays {
+		if w == d {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the first ring time after t, evaluated as wall clock time in
+// loc. Days where Hour:Minute does not exist because of a DST gap ring at
+// the equivalent instant right after the gap.
+// A one-off alarm returns the zero time once it has rung.
+func (a Alarm) Next(t time.Time, loc *time.Location) time.Time {
+	if a.Date != "" {
+		day, err := time.ParseInLocation(DateLayout, a.Date, loc)
+		if err != nil {
+			return time.Time{}
+		}
+		y, m, d := day.Date()
+		if at := a.on(y, m, d, loc); at.After(t) {
+			return at
+		}
+		return time.Time{}
+	}
+
+	local := t.In(loc)
+	y, m, d := local.Date()
+
+	// a week always contains a matching day, DST never moves more than a day
+	for i := 0; i <= 8; i++ {
+		at := a.on(y, m, d+i, loc)
+		if at.After(t) && a.RingsOn(at.Weekday()) {
+			return at
+		}
+	}
+
+	return time.Time{}
+}
+
+// when the alarm rings on the day
+func (a Alarm) on(y int, m time.Month, d int, loc *time.Location) time.Time {
+	if a.Sun != "" {
+		if at, ok := a.sun(y, m, d, loc); ok {
+			return at
+		}
+	}
+	return clockOn(y, m, d, a.Hour, a.Minute, loc)
+}
+
+// Offset from the sun on the day, to the minute, between Earliest and Latest
+func (a Alarm) sun(y int, m time.Month, d int, loc *time.Location) (time.Time, bool) {
+	rise, set, ok := solar.Times(time.Date(y, m, d, 12, 0, 0, 0, loc), a.Lat, a.Lon)
+	if !ok {
+		return time.Time{}, false
+	}
+	at := rise
+	if a.Sun == Sunset {
+		at = set
+	}
+	at = at.Add(time.Duration(a.Offset) * time.Minute).Round(time.Minute)
+
+	if h, min, err := ParseClock(a.Earliest); err == nil {
+		if earliest := clockOn(y, m, d, h, min, loc); at.Before(earliest) {
+			at = earliest
+		}
+	}
+	if h, min, err := ParseClock(a.Latest); err == nil {
+		if latest := clockOn(y, m, d, h, min, loc); at.After(latest) {
+			at = latest
+		}
+	}
+	return at, true
+}
+
+// h:min on the day
+func clockOn(y int, m time.Month, d int, h int, min int, loc *time.Location) time.Time {
+	at := time.Date(y, m, d, h, min, 0, 0, loc)
+	if want, got := h*60+min, at.Hour()*60+at.Minute(); got != want {
+		// time.Date puts it before the gap
+		at = at.Add(time.Duration(want-got) * time.Minute)
+	}
+	return at
+}
+
+// Once tells whether the alarm rings only on Date.
+func (a Alarm) Once() bool {
+	return a.Date != ""
+}
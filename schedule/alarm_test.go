@@ -0,0 +1,199 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestNext(t *testing.T) {
+	tokyo := mustLoad(t, "Asia/Tokyo")
+	singapore := mustLoad(t, "Asia/Singapore")
+	newYork := mustLoad(t, "America/New_York")
+
+	tests := []struct {
+		name  string
+		alarm Alarm
+		after time.Time
+		loc   *time.Location
+		want  time.Time
+	}{
+		{
+			name:  "later today",
+			alarm: Alarm{Hour: 7},
+			after: time.Date(2026, 10, 19, 6, 0, 0, 0, tokyo),
+			loc:   tokyo,
+			want:  time.Date(2026, 10, 19, 7, 0, 0, 0, tokyo),
+		},
+		{
+			name:  "exactly now rings tomorrow",
+			alarm: Alarm{Hour: 7},
+			after: time.Date(2026, 10, 19, 7, 0, 0, 0, tokyo),
+			loc:   tokyo,
+			want:  time.Date(2026, 10, 20, 7, 0, 0, 0, tokyo),
+		},
+		{
+			name:  "weekdays skip the weekend",
+			alarm: Alarm{Hour: 6, Minute: 30, Weekdays: Weekdays},
+			after: time.Date(2026, 10, 23, 8, 0, 0, 0, tokyo), // Friday
+			loc:   tokyo,
+			want:  time.Date(2026, 10, 26, 6, 30, 0, 0, tokyo),
+		},
+		{
+			name:  "evaluated in the user's timezone",
+			alarm: Alarm{Hour: 7},
+			after: time.Date(2026, 10, 19, 7, 30, 0, 0, tokyo), // 6:30 in Singapore
+			loc:   singapore,
+			want:  time.Date(2026, 10, 19, 7, 0, 0, 0, singapore),
+		},
+		{
+			name:  "spring forward keeps the wall clock",
+			alarm: Alarm{Hour: 7},
+			after: time.Date(2026, 3, 7, 8, 0, 0, 0, newYork),
+			loc:   newYork,
+			want:  time.Date(2026, 3, 8, 7, 0, 0, 0, newYork),
+		},
+		{
+			name:  "a time inside the DST gap rings right after it",
+			alarm: Alarm{Hour: 2, Minute: 30},
+			after: time.Date(2026, 3, 7, 8, 0, 0, 0, newYork),
+			loc:   newYork,
+			want:  time.Date(2026, 3, 8, 7, 30, 0, 0, time.UTC), // 3:30 EDT
+		},
+		{
+			name:  "once on the date",
+			alarm: Alarm{Hour: 7, Date: "2026-10-21"},
+			after: time.Date(2026, 10, 19, 8, 0, 0, 0, tokyo),
+			loc:   tokyo,
+			want:  time.Date(2026, 10, 21, 7, 0, 0, 0, tokyo),
+		},
+		{
+			name:  "once is never again",
+			alarm: Alarm{Hour: 7, Date: "2026-10-21"},
+			after: time.Date(2026, 10, 21, 7, 0, 0, 0, tokyo),
+			loc:   tokyo,
+			want:  time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.alarm.Next(tt.after, tt.loc); !got.Equal(tt.want) {
+				t.Errorf("Next = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextAcrossFallBack(t *testing.T) {
+	newYork := mustLoad(t, "America/New_York")
+	a := Alarm{Hour: 7}
+
+	first := a.Next(time.Date(2026, 10, 31, 6, 0, 0, 0, newYork), newYork)
+	second := a.Next(first, newYork)
+
+	if d := second.Sub(first); d != 25*time.Hour {
+		t.Errorf("interval across fall back = %v, want 25h", d)
+	}
+	if h := second.In(newYork).Hour(); h != 7 {
+		t.Errorf("rings at %d o'clock", h)
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	for s, want := range map[string][2]int{"7:00": {7, 0}, "06:30": {6, 30}, "23:59": {23, 59}} {
+		h, m, err := ParseClock(s)
+		if err != nil || h != want[0] || m != want[1] {
+			t.Errorf("ParseClock(%q) = %d, %d, %v", s, h, m, err)
+		}
+	}
+
+	for _, s := range []string{"7", "24:00", "7:60", "7:5", "a:00"} {
+		if _, _, err := ParseClock(s); err == nil {
+			t.Errorf("ParseClock(%q) is accepted", s)
+		}
+	}
+}
+
+func TestNextSun(t *testing.T) {
+	tokyo := mustLoad(t, "Asia/Tokyo")
+	oslo := mustLoad(t, "Europe/Oslo")
+	sunrise := Alarm{Sun: Sunrise, Offset: -30, Lat: 35.6895, Lon: 139.6917} // the sun rises at 5:50 on 10/19
+
+	tests := []struct {
+		name  string
+		alarm Alarm
+		after time.Time
+		loc   *time.Location
+		want  string
+	}{
+		{"before sunrise", sunrise, time.Date(2026, 10, 19, 3, 0, 0, 0, tokyo), tokyo, "10/19 05:20"},
+		{"tomorrow", sunrise, time.Date(2026, 10, 19, 7, 0, 0, 0, tokyo), tokyo, "10/20 05:21"},
+		{"not before", Alarm{Sun: Sunrise, Offset: -30, Earliest: "5:30", Lat: 35.6895, Lon: 139.6917},
+			time.Date(2026, 10, 19, 3, 0, 0, 0, tokyo), tokyo, "10/19 05:30"},
+		{"not after", Alarm{Sun: Sunrise, Latest: "5:00", Lat: 35.6895, Lon: 139.6917},
+			time.Date(2026, 10, 19, 3, 0, 0, 0, tokyo), tokyo, "10/19 05:00"},
+		{"sunset", Alarm{Sun: Sunset, Lat: 35.6895, Lon: 139.6917}, time.Date(2026, 10, 19, 7, 0, 0, 0, tokyo), tokyo, "10/19 17:02"},
+		{"weekday", Alarm{Sun: Sunrise, Weekdays: Weekdays, Lat: 35.6895, Lon: 139.6917},
+			time.Date(2026, 10, 24, 3, 0, 0, 0, tokyo), tokyo, "10/26 05:57"},
+		// tromsø in the midnight sun
+		{"no sunrise", Alarm{Hour: 7, Sun: Sunrise, Lat: 69.65, Lon: 18.96}, time.Date(2026, 6, 21, 3, 0, 0, 0, oslo), oslo, "06/21 07:00"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.alarm.Next(tt.after, tt.loc).In(tt.loc).Format("01/02 15:04"); got != tt.want {
+			t.Errorf("%s: Next = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseSun(t *testing.T) {
+	for s, want := range map[string]Alarm{
+		"sunrise":     {Sun: Sunrise},
+		"sunrise-30":  {Sun: Sunrise, Offset: -30},
+		"sunset+15":   {Sun: Sunset, Offset: 15},
+		"日の出-30分":     {Sun: Sunrise, Offset: -30},
+		"日の入り":        {Sun: Sunset},
+		"sunrise+180": {Sun: Sunrise, Offset: 180},
+	} {
+		sun, offset, err := ParseSun(s)
+		if err != nil || sun != want.Sun || offset != want.Offset {
+			t.Errorf("ParseSun(%q) = %s, %d, %v", s, sun, offset, err)
+		}
+	}
+
+	for _, s := range []string{"sunrise", "sunrise-30", "sunset+15"} {
+		sun, offset, _ := ParseSun(s)
+		if got := (Alarm{Sun: sun, Offset: offset}).Time(); got != s {
+			t.Errorf("Time() = %s, want %s", got, s)
+		}
+	}
+
+	for _, s := range []string{"7:00", "sunrise30", "sunrise-181", "sunrise-a", "noon"} {
+		if _, _, err := ParseSun(s); err == nil {
+			t.Errorf("ParseSun(%q) is accepted", s)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	for s, want := range map[string][2]string{"5:00-6:30": {"5:00", "6:30"}, "05:00-": {"5:00", ""}, "-6:30": {"", "6:30"}} {
+		earliest, latest, err := ParseRange(s)
+		if err != nil || earliest != want[0] || latest != want[1] {
+			t.Errorf("ParseRange(%q) = %s, %s, %v", s, earliest, latest, err)
+		}
+	}
+
+	for _, s := range []string{"-", "5:00", "6:30-5:00", "5:00-6:30-7:00", "a-6:30"} {
+		if _, _, err := ParseRange(s); err == nil {
+			t.Errorf("ParseRange(%q) is accepted", s)
+		}
+	}
+}
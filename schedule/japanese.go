@@ -0,0 +1,249 @@
+package schedule
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotAlarm = errors.New("schedule: not an alarm")
+	ErrBadTime  = errors.New("schedule: no such time")
+	ErrPast     = errors.New("schedule: the time has passed")
+)
+
+// half-width digits and punctuation, no spaces
+var normalizer = strings.NewReplacer(
+	"０", "0", "１", "1", "２", "2", "３", "3", "４", "4",
+	"５", "5", "６", "6", "７", "7", "８", "8", "９", "9",
+	"：", ":", "／", "/", "，", ",", "　", "", " ", "",
+)
+
+var (
+	kanjiNumber = regexp.MustCompile(`[〇一二三四五六七八九十]+`)
+
+	// what people put around the time: 起こして, お願い, よろしく, ね...
+	tail = regexp.MustCompile(`(?:に|で|には)?(?:起こして|おこして|起して|起きる|起きたい|アラーム|鳴らして|セット)?(?:して)?(?:ください|下さい|くれ|ほしい|欲しい|お願い(?:します)?|おねがい(?:します)?|よろしく|ね|な|よ)*[。．.！!？?〜~ー♪]*$`)
+
+	relative = regexp.MustCompile(`^(あと)?(?:(\d+)時間(半)?)?(?:(\d+)分)?(後)?$`)
+
+	absolute = regexp.MustCompile(`^` +
+		`(?:(今日|きょう|明日|あした|あす|明後日|あさって|毎日|まいにち|毎朝|平日|へいじつ|週末|土日)` +
+		`|(毎週|今度の|次の|今週の|来週の)?((?:[月火水木金土日](?:曜日|曜)?(?:と|・|、|,)?)+)` +
+		`|(\d{1,2})[/月](\d{1,2})日?` +
+		`|(\d{1,2})日)?` +
+		`(?:は|に|の|も|だけ|のみ|、|,)*` +
+		`(朝|午前|午後|夜|夕方|AM|PM|am|pm)?` +
+		`(?:(\d{1,2})時(?:(\d{1,2})分|(半))?|(\d{1,2}):(\d{2}))$`)
+)
+
+var weekdayNames = map[rune]time.Weekday{
+	'日': time.Sunday, '月': time.Monday, '火': time.Tuesday, '水': time.Wednesday,
+	'木': time.Thursday, '金': time.Friday, '土': time.Saturday,
+}
+
+// ParseJapanese reads alarm requests like 明日7時に起こして, 平日は6時半,
+// 30分後に起こして or 土曜だけ8時. Days and times refer to now in loc.
+// One-off alarms have Date set.
+func ParseJapanese(text string, now time.Time, loc *time.Location) (*Alarm, error) {
+	s := normalizer.Replace(text)
+	s = kanjiNumber.ReplaceAllStringFunc(s, kanjiToDigits)
+	s = tail.ReplaceAllString(s, "")
+	if s == "" {
+		return nil, ErrNotAlarm
+	}
+	now = now.In(loc)
+
+	// 30分後, 1時間半後, あと10分
+	if m := relative.FindStringSubmatch(s); m != nil && (m[2] != "" || m[4] != "") && (m[1] != "" || m[5] != "") {
+		d := time.Duration(atoi(m[2]))*time.Hour + time.Duration(atoi(m[4]))*time.Minute
+		if m[3] != "" {
+			d += 30 * time.Minute
+		}
+		if d <= 0 || d > 24*time.Hour {
+			return nil, ErrBadTime
+		}
+		at := now.Add(d).Round(time.Minute)
+		return &Alarm{Hour: at.Hour(), Minute: at.Minute(), Date: at.Format(DateLayout)}, nil
+	}
+
+	m := absolute.FindStringSubmatch(s)
+	if m == nil {
+		return nil, ErrNotAlarm
+	}
+	word, prefix, days, month, dayOfMonth, day := m[1], m[2], m[3], m[4], m[5], m[6]
+	period := m[7]
+
+	h, min := atoi(m[8]), atoi(m[9])
+	if m[10] != "" {
+		min = 30
+	}
+	if m[11] != "" {
+		h, min = atoi(m[11]), atoi(m[12])
+	}
+	switch period {
+	case "午後", "夕方", "PM", "pm":
+		if h > 12 {
+			return nil, ErrBadTime
+		}
+		if h < 12 {
+			h += 12
+		}
+	case "夜":
+		if h > 12 {
+			return nil, ErrBadTime
+		}
+		h = (h + 12) % 24 // 夜12時 is midnight
+	case "朝", "午前", "AM", "am":
+		if h > 12 {
+			return nil, ErrBadTime
+		}
+		h %= 12
+	}
+	if h > 23 || min > 59 {
+		return nil, ErrBadTime
+	}
+
+	a := &Alarm{Hour: h, Minute: min}
+	y, mo, d := now.Date()
+	once := func(y int, m time.Month, d int) (*Alarm, error) {
+		at := a.on(y, m, d, loc)
+		if !at.After(now) {
+			return nil, ErrPast
+		}
+		a.Date = at.Format(DateLayout)
+		return a, nil
+	}
+
+	switch {
+	case word != "":
+		switch word {
+		case "今日", "きょう":
+			return once(y, mo, d)
+		case "明日", "あした", "あす":
+			return once(y, mo, d+1)
+		case "明後日", "あさって":
+			return once(y, mo, d+2)
+		case "毎日", "まいにち", "毎朝":
+			return a, nil
+		case "平日", "へいじつ":
+			a.Weekdays, a.SkipHolidays = Weekdays, true
+			return a, nil
+		default: // 週末, 土日
+			a.Weekdays = []time.Weekday{time.Saturday, time.Sunday}
+			return a, nil
+		}
+
+	case days != "":
+		list := parseWeekdays(days)
+		switch prefix {
+		case "", "毎週":
+			a.Weekdays = list
+			return a, nil
+		}
+		if len(list) != 1 {
+			return nil, ErrNotAlarm
+		}
+		offset := int(list[0]+7-now.Weekday()) % 7
+		switch prefix {
+		case "今週の":
+			// the week starts on Monday
+			if (list[0]+6)%7 < (now.Weekday()+6)%7 {
+				return nil, ErrPast
+			}
+		case "来週の":
+			offset = 7 - (int(now.Weekday())+6)%7 + (int(list[0])+6)%7
+		default: // 今度の, 次の
+			if offset == 0 {
+				offset = 7
+			}
+		}
+		return once(y, mo, d+offset)
+
+	case month != "":
+		m, dd := time.Month(atoi(month)), atoi(dayOfMonth)
+		if m < 1 || m > 12 || !exists(y, m, dd) && !exists(y+1, m, dd) {
+			return nil, ErrBadTime
+		}
+		if exists(y, m, dd) {
+			if a, err := once(y, m, dd); err == nil {
+				return a, nil
+			}
+		}
+		if !exists(y+1, m, dd) {
+			return nil, ErrPast // 2/29 of a leap year
+		}
+		return once(y+1, m, dd)
+
+	case day != "":
+		dd := atoi(day)
+		if dd < 1 || dd > 31 {
+			return nil, ErrBadTime
+		}
+		// this month's, or next month's when it has passed
+		for i := 0; i < 3; i++ {
+			if exists(y, mo+time.Month(i), dd) {
+				if a, err := once(y, mo+time.Month(i), dd); err == nil {
+					return a, nil
+				}
+			}
+		}
+		return nil, ErrBadTime
+	}
+
+	// just the time: its next occurrence
+	if a, err := once(y, mo, d); err == nil {
+		return a, nil
+	}
+	return once(y, mo, d+1)
+}
+
+// whether the day is in the month, m may be past December
+func exists(y int, m time.Month, d int) bool {
+	return d >= 1 && time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Day() == d
+}
+
+// 月水金, 土曜と日曜, 月・木
+func parseWeekdays(s string) []time.Weekday {
+	seen := map[time.Weekday]bool{}
+	list := []time.Weekday{}
+	prev := ' '
+	for _, r := range s {
+		// 日 of 曜日 is not Sunday
+		if d, ok := weekdayNames[r]; ok && !(r == '日' && prev == '曜') && !seen[d] {
+			seen[d] = true
+			list = append(list, d)
+		}
+		prev = r
+	}
+	// Monday first, like 週末 and 土日
+	sort.Slice(list, func(i, j int) bool { return (list[i]+6)%7 < (list[j]+6)%7 })
+	return list
+}
+
+var kanjiDigits = map[rune]int{'〇': 0, '一': 1, '二': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+
+// 七 → 7, 十五 → 15, 二十三 → 23
+func kanjiToDigits(s string) string {
+	n, cur := 0, 0
+	for _, r := range s {
+		if r == '十' {
+			if cur == 0 {
+				cur = 1
+			}
+			n += cur * 10
+			cur = 0
+			continue
+		}
+		cur = cur*10 + kanjiDigits[r]
+	}
+	return strconv.Itoa(n + cur)
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,169 @@
+package schedule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseJapanese(t *testing.T) {
+	tokyo := mustLoad(t, "Asia/Tokyo")
+	now := time.Date(2026, 10, 19, 7, 0, 20, 0, tokyo) // Monday
+
+	weekend := []time.Weekday{time.Saturday, time.Sunday}
+	sat := []time.Weekday{time.Saturday}
+
+	tests := []struct {
+		text string
+		want Alarm
+	}{
+		// the examples
+		{"明日7時に起こして", Alarm{Hour: 7, Date: "2026-10-20"}},
+		{"平日は6時半", Alarm{Hour: 6, Minute: 30, Weekdays: Weekdays, SkipHolidays: true}},
+		{"30分後に起こして", Alarm{Hour: 7, Minute: 30, Date: "2026-10-19"}},
+		{"土曜だけ8時", Alarm{Hour: 8, Weekdays: sat}},
+
+		// one-off days
+		{"今日の9時", Alarm{Hour: 9, Date: "2026-10-19"}},
+		{"きょう21時", Alarm{Hour: 21, Date: "2026-10-19"}},
+		{"あした7時", Alarm{Hour: 7, Date: "2026-10-20"}},
+		{"あす6:45", Alarm{Hour: 6, Minute: 45, Date: "2026-10-20"}},
+		{"明後日の5時", Alarm{Hour: 5, Date: "2026-10-21"}},
+		{"あさって10時15分", Alarm{Hour: 10, Minute: 15, Date: "2026-10-21"}},
+		{"明日の朝7時に起こしてください", Alarm{Hour: 7, Date: "2026-10-20"}},
+		{"明日は7時でお願いします", Alarm{Hour: 7, Date: "2026-10-20"}},
+		{"明日7時によろしく！", Alarm{Hour: 7, Date: "2026-10-20"}},
+		{"明日 7時 に起こして", Alarm{Hour: 7, Date: "2026-10-20"}},
+		{"明日　７時に起こして", Alarm{Hour: 7, Date: "2026-10-20"}},
+		{"明日７：３０", Alarm{Hour: 7, Minute: 30, Date: "2026-10-20"}},
+		{"明日七時", Alarm{Hour: 7, Date: "2026-10-20"}},
+		{"明日七時半", Alarm{Hour: 7, Minute: 30, Date: "2026-10-20"}},
+		{"明日六時四十五分", Alarm{Hour: 6, Minute: 45, Date: "2026-10-20"}},
+		{"明日二十三時", Alarm{Hour: 23, Date: "2026-10-20"}},
+
+		// just a time is its next occurrence
+		{"8時", Alarm{Hour: 8, Date: "2026-10-19"}},
+		{"7時に起こして", Alarm{Hour: 7, Date: "2026-10-20"}},
+		{"6時半に起こしてね", Alarm{Hour: 6, Minute: 30, Date: "2026-10-20"}},
+		{"7:01", Alarm{Hour: 7, Minute: 1, Date: "2026-10-19"}},
+		{"朝7時", Alarm{Hour: 7, Date: "2026-10-20"}},
+		{"午前9時", Alarm{Hour: 9, Date: "2026-10-19"}},
+		{"午後1時", Alarm{Hour: 13, Date: "2026-10-19"}},
+		{"夜11時", Alarm{Hour: 23, Date: "2026-10-19"}},
+		{"夕方5時", Alarm{Hour: 17, Date: "2026-10-19"}},
+		{"午前12時", Alarm{Hour: 0, Date: "2026-10-20"}},
+		{"午後12時", Alarm{Hour: 12, Date: "2026-10-19"}},
+		{"夜12時", Alarm{Hour: 0, Date: "2026-10-20"}},
+		{"PM3時", Alarm{Hour: 15, Date: "2026-10-19"}},
+		{"0時", Alarm{Hour: 0, Date: "2026-10-20"}},
+
+		// relative
+		{"1時間後", Alarm{Hour: 8, Date: "2026-10-19"}},
+		{"1時間半後に起こして", Alarm{Hour: 8, Minute: 30, Date: "2026-10-19"}},
+		{"2時間15分後", Alarm{Hour: 9, Minute: 15, Date: "2026-10-19"}},
+		{"あと10分", Alarm{Hour: 7, Minute: 10, Date: "2026-10-19"}},
+		{"あと5分で起こして", Alarm{Hour: 7, Minute: 5, Date: "2026-10-19"}},
+		{"三十分後", Alarm{Hour: 7, Minute: 30, Date: "2026-10-19"}},
+		{"17時間後", Alarm{Hour: 0, Date: "2026-10-20"}},
+		{"24時間後", Alarm{Hour: 7, Date: "2026-10-20"}},
+
+		// recurring
+		{"毎日7時", Alarm{Hour: 7}},
+		{"毎朝6時半", Alarm{Hour: 6, Minute: 30}},
+		{"まいにち7時に起こして", Alarm{Hour: 7}},
+		{"平日6:30", Alarm{Hour: 6, Minute: 30, Weekdays: Weekdays, SkipHolidays: true}},
+		{"へいじつは7時", Alarm{Hour: 7, Weekdays: Weekdays, SkipHolidays: true}},
+		{"週末は9時", Alarm{Hour: 9, Weekdays: weekend}},
+		{"土日は10時", Alarm{Hour: 10, Weekdays: weekend}},
+		{"土曜日は8時", Alarm{Hour: 8, Weekdays: sat}},
+		{"土曜8時", Alarm{Hour: 8, Weekdays: sat}},
+		{"毎週土曜8時", Alarm{Hour: 8, Weekdays: sat}},
+		{"日曜日だけ9時に起こして", Alarm{Hour: 9, Weekdays: []time.Weekday{time.Sunday}}},
+		{"月水金は6時", Alarm{Hour: 6, Weekdays: []time.Weekday{time.Monday, time.Wednesday, time.Friday}}},
+		{"月・木は6時", Alarm{Hour: 6, Weekdays: []time.Weekday{time.Monday, time.Thursday}}},
+		{"火曜と木曜は7時", Alarm{Hour: 7, Weekdays: []time.Weekday{time.Tuesday, time.Thursday}}},
+		{"日曜と土曜は9時", Alarm{Hour: 9, Weekdays: weekend}},
+
+		// one-off weekdays
+		{"今度の土曜8時", Alarm{Hour: 8, Date: "2026-10-24"}},
+		{"次の月曜は7時", Alarm{Hour: 7, Date: "2026-10-26"}},
+		{"今週の金曜6時", Alarm{Hour: 6, Date: "2026-10-23"}},
+		{"今週の月曜23時", Alarm{Hour: 23, Date: "2026-10-19"}},
+		{"来週の月曜7時", Alarm{Hour: 7, Date: "2026-10-26"}},
+		{"来週の日曜の朝9時", Alarm{Hour: 9, Date: "2026-11-01"}},
+
+		// dates
+		{"10月25日7時", Alarm{Hour: 7, Date: "2026-10-25"}},
+		{"10/25の7時", Alarm{Hour: 7, Date: "2026-10-25"}},
+		{"1月2日の9時", Alarm{Hour: 9, Date: "2027-01-02"}},
+		{"10月19日6時", Alarm{Hour: 6, Date: "2027-10-19"}},
+		{"25日の7時", Alarm{Hour: 7, Date: "2026-10-25"}},
+		{"19日6時", Alarm{Hour: 6, Date: "2026-11-19"}},
+		{"31日7時", Alarm{Hour: 7, Date: "2026-10-31"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := ParseJapanese(tt.text, now, tokyo)
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJapaneseRejects(t *testing.T) {
+	tokyo := mustLoad(t, "Asia/Tokyo")
+	now := time.Date(2026, 10, 19, 7, 0, 0, 0, tokyo) // Monday
+
+	tests := []struct {
+		text string
+		want error
+	}{
+		{"おはよう", ErrNotAlarm},
+		{"", ErrNotAlarm},
+		{"起こして", ErrNotAlarm},
+		{"7時ごろ行くね", ErrNotAlarm},
+		{"明日は雨", ErrNotAlarm},
+		{"30分", ErrNotAlarm},
+		{"明日7時に会議がある", ErrNotAlarm},
+		{"今度の月水7時", ErrNotAlarm},
+		{"25時", ErrBadTime},
+		{"7時60分", ErrBadTime},
+		{"午後13時", ErrBadTime},
+		{"7:75", ErrBadTime},
+		{"13月1日7時", ErrBadTime},
+		{"2月30日7時", ErrBadTime},
+		{"32日7時", ErrBadTime},
+		{"25時間後", ErrBadTime},
+		{"0分後", ErrBadTime},
+		{"今日6時", ErrPast},
+		{"今日7時", ErrPast},
+		{"今週の日曜は過ぎてない", ErrNotAlarm},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got, err := ParseJapanese(tt.text, now, tokyo); err != tt.want {
+				t.Errorf("got %+v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJapaneseInTimeZone(t *testing.T) {
+	tokyo := mustLoad(t, "Asia/Tokyo")
+	london := mustLoad(t, "Europe/London")
+	now := time.Date(2026, 10, 19, 7, 0, 0, 0, tokyo) // Sunday 23:00 in London
+
+	got, err := ParseJapanese("明日7時", now, london)
+	if err != nil || got.Date != "2026-10-19" {
+		t.Errorf("got %+v, %v", got, err)
+	}
+	if next := got.Next(now, london); !next.Equal(time.Date(2026, 10, 19, 7, 0, 0, 0, london)) {
+		t.Errorf("rings at %v", next)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"awake-bot/timeout"
+	"sort"
+	"sync"
+)
+
+var sessionsMu sync.Mutex // guards snooze
+
+func getSession(roomId string) (*timeout.Timeout, bool) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	to, ok := snooze[roomId]
+	return to, ok
+}
+
+// putSession registers to unless the room already has a session.
+func putSession(to *timeout.Timeout) bool {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	if _, exists := snooze[to.RoomId]; exists {
+		return false
+	}
+
+	snooze[to.RoomId] = to
+	activeSessions.Set(float64(len(snooze)))
+	return true
+}
+
+func deleteSession(roomId string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	delete(snooze, roomId)
+	activeSessions.Set(float64(len(snooze)))
+}
+
+// returns every live session ordered by room id
+func listSessions() []*timeout.Timeout {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	list := []*timeout.Timeout{}
+	for _, to := range snooze {
+		list = append(list, to)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].RoomId < list[j].RoomId })
+	return list
+}
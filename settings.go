@@ -0,0 +1,473 @@
+package main
+
+import (
+	"awake-bot/forecast"
+	"awake-bot/i18n"
+	"awake-bot/logging"
+	"awake-bot/persona"
+	"awake-bot/schedule"
+	"awake-bot/store"
+	"awake-bot/timeout"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// limits of what users may set
+const (
+	maxUserRepeats    = 20
+	maxSnoozeMinutes  = 60
+	maxAckPhrases     = 10
+	maxAckPhraseRunes = 20
+	maxContacts       = 5
+	maxKnownRooms     = 30 // remembered per user, the oldest are forgotten
+)
+
+var chatId = regexp.MustCompile(`^[A-Za-z0-9]{1,64}$`)
+
+func userMaxRepeats(u store.User) int {
+	if u.MaxRepeats > 0 {
+		return u.MaxRepeats
+	}
+	return conf.Snooze.MaxRepeats
+}
+
+// sec between the snoozes of the user's alarm
+func alarmTimeout(u store.User) int {
+	if u.SnoozeSec > 0 {
+		return u.SnoozeSec
+	}
+	return conf.Alarm.Timeout
+}
+
+func userCity(u store.User) int {
+	if u.City > 0 {
+		return u.City
+	}
+	return conf.Forecast.City
+}
+
+// where the sun rises for the user
+func userCoords(u store.User) (float64, float64) {
+	if c, ok := forecast.FindCity(userCity(u)); ok {
+		return c.Lat, c.Lon
+	}
+	return conf.Forecast.Latitude, conf.Forecast.Longitude
+}
+
+// sun alarms watch the sun over the user's city, and ring at Hour:Minute,
+// about when they would, on days it doesn't rise
+func placeSunAlarm(u store.User, a *schedule.Alarm) {
+	if a == nil || a.Sun == "" {
+		return
+	}
+	a.Lat, a.Lon = userCoords(u)
+	loc := userLocation(u)
+	if at := a.Next(clk.Now(), loc); !at.IsZero() {
+		a.Hour, a.Minute = at.In(loc).Hour(), at.In(loc).Minute()
+	}
+}
+
+// whether text tells the bot the user is awake
+func isAck(u store.User, text string) bool {
+	if ackPattern.MatchString(text) {
+		return true
+	}
+	text = strings.TrimSpace(text)
+	for _, p := range u.AckPhrases {
+		if strings.HasPrefix(text, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// who is told when the session gives up: the alert room /push was given and
+// the user's contacts
+func alertRooms(to *timeout.Timeout, u store.User) []string {
+	list := []string{}
+	seen := map[string]bool{to.RoomId: true}
+	for _, id := range append([]string{to.AlertRoomId}, u.Contacts...) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			list = append(list, id)
+		}
+	}
+	return list
+}
+
+// what the settings page edits, as typed
+type settingsForm struct {
+	Clock         string // H:MM or like sunrise-30, empty when the alarm is off
+	Between       string // 5:00-6:30, for sun alarms
+	Days          [7]bool
+	SkipHolidays  bool
+	WindowMinutes int // before Clock, 0 to ring on time
+	TimeZone      string
+	Lang          string // empty to follow LINE
+	City          int    // 0 for the configured one
+	Persona       string // of the 1:1 chat, empty for the default one
+	MaxRepeats    int
+	SnoozeMinutes int
+	AckPhrases    string // one per line
+	Contacts      string // one per line
+}
+
+func userSettings(u store.User) settingsForm {
+	f := settingsForm{
+		TimeZone:      userLocation(u).String(),
+		Lang:          u.Lang,
+		City:          u.City,
+		Persona:       users.Room(u.Id).Persona,
+		MaxRepeats:    userMaxRepeats(u),
+		SnoozeMinutes: alarmTimeout(u) / 60,
+		AckPhrases:    strings.Join(u.AckPhrases, "\n"),
+		Contacts:      strings.Join(u.Contacts, "\n"),
+	}
+	days, skip := schedule.Weekdays, true
+	if a := u.Alarm; a != nil {
+		f.Clock = a.Time()
+		if a.Earliest != "" || a.Latest != "" {
+			f.Between = a.Earliest + "-" + a.Latest
+		}
+		f.WindowMinutes = a.Window
+		days, skip = a.Weekdays, a.SkipHolidays
+	}
+	f.SkipHolidays = skip
+	for d := range f.Days {
+		f.Days[d] = len(days) == 0
+	}
+	for _, d := range days {
+		f.Days[d] = true
+	}
+	return f
+}
+
+func readSettingsForm(c *gin.Context) settingsForm {
+	f := settingsForm{
+		Clock:        strings.TrimSpace(c.PostForm("clock")),
+		Between:      strings.TrimSpace(c.PostForm("between")),
+		SkipHolidays: c.PostForm("skip_holidays") != "",
+		TimeZone:     strings.TrimSpace(c.PostForm("timezone")),
+		Lang:         c.PostForm("lang"),
+		Persona:      c.PostForm("persona"),
+		AckPhrases:   c.PostForm("ack_phrases"),
+		Contacts:     c.PostForm("contacts"),
+	}
+	for _, v := range c.Request.PostForm["day"] {
+		if d, err := strconv.Atoi(v); err == nil && d >= 0 && d < 7 {
+			f.Days[d] = true
+		}
+	}
+	// unparsable numbers are left 0, which is out of range below
+	f.City, _ = strconv.Atoi(c.PostForm("city"))
+	f.MaxRepeats, _ = strconv.Atoi(c.PostForm("max_repeats"))
+	f.SnoozeMinutes, _ = strconv.Atoi(c.PostForm("snooze_minutes"))
+	f.WindowMinutes, _ = strconv.Atoi(c.PostForm("window_minutes"))
+	return f
+}
+
+func lines(s string) []string {
+	list := []string{}
+	for _, l := range strings.Split(s, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			list = append(list, l)
+		}
+	}
+	return list
+}
+
+// applies the form to the fields it edits of u, and tells what is wrong
+// with it. It does not read the store, so it can run inside UpdateUser.
+func (f settingsForm) apply(u *store.User, lc *i18n.Locale) []string {
+	errs := []string{}
+
+	old := u.Alarm
+	u.Alarm = nil
+	if f.Clock != "" {
+		a := &schedule.Alarm{SkipHolidays: f.SkipHolidays, Window: f.WindowMinutes}
+		var err error
+		if a.Hour, a.Minute, err = schedule.ParseClock(f.Clock); err != nil {
+			if a.Sun, a.Offset, err = schedule.ParseSun(f.Clock); err != nil {
+				errs = append(errs, lc.T("settings.bad_clock"))
+			}
+		}
+		if f.Between != "" {
+			if a.Earliest, a.Latest, err = schedule.ParseRange(f.Between); err != nil {
+				errs = append(errs, lc.T("settings.bad_between"))
+			} else if a.Sun == "" {
+				errs = append(errs, lc.T("settings.between_not_sun"))
+			}
+		}
+		if max := int(conf.Sleep.MaxWindow.Minutes()); f.WindowMinutes < 0 || f.WindowMinutes > max {
+			errs = append(errs, lc.T("settings.bad_window", "Max", max))
+		}
+		for d, on := range f.Days {
+			if on {
+				a.Weekdays = append(a.Weekdays, time.Weekday(d))
+			}
+		}
+		switch len(a.Weekdays) {
+		case 0:
+			errs = append(errs, lc.T("settings.no_days"))
+		case 7:
+			a.Weekdays = nil
+		}
+		if old != nil {
+			a.RoomId = old.RoomId // set by /alarm in a group
+		}
+		u.Alarm = a
+	}
+
+	loc, err := time.LoadLocation(f.TimeZone)
+	if err != nil || f.TimeZone == "" || strings.EqualFold(f.TimeZone, "local") {
+		errs = append(errs, lc.T("settings.bad_timezone", "TimeZone", f.TimeZone))
+	} else {
+		u.TimeZone = loc.String()
+	}
+
+	if f.Lang != "" && !locales.Has(f.Lang) {
+		errs = append(errs, lc.T("settings.bad_lang", "Lang", f.Lang))
+	}
+	u.Lang = f.Lang
+
+	if _, ok := forecast.CityName(f.City); f.City != 0 && !ok {
+		errs = append(errs, lc.T("settings.bad_city"))
+	}
+	u.City = f.City
+	placeSunAlarm(*u, u.Alarm)
+
+	if f.Persona != "" && !personas[locales.Get(f.Lang).Tag].Has(f.Persona) {
+		errs = append(errs, lc.T("settings.bad_persona", "Id", f.Persona))
+	}
+
+	if f.MaxRepeats < 1 || f.MaxRepeats > maxUserRepeats {
+		errs = append(errs, lc.T("settings.bad_repeats", "Max", maxUserRepeats))
+	}
+	u.MaxRepeats = f.MaxRepeats
+	if f.SnoozeMinutes < 1 || f.SnoozeMinutes > maxSnoozeMinutes {
+		errs = append(errs, lc.T("settings.bad_snooze", "Max", maxSnoozeMinutes))
+	}
+	u.SnoozeSec = f.SnoozeMinutes * 60
+
+	u.AckPhrases = lines(f.AckPhrases)
+	if len(u.AckPhrases) > maxAckPhrases {
+		errs = append(errs, lc.T("settings.too_many_acks", "Max", maxAckPhrases))
+	}
+	for _, p := range u.AckPhrases {
+		if len([]rune(p)) > maxAckPhraseRunes {
+			errs = append(errs, lc.T("settings.long_ack", "Phrase", p, "Max", maxAckPhraseRunes))
+		}
+	}
+
+	u.Contacts = lines(f.Contacts)
+	if len(u.Contacts) > maxContacts {
+		errs = append(errs, lc.T("settings.too_many_contacts", "Max", maxContacts))
+	}
+	for _, id := range u.Contacts {
+		switch {
+		case !chatId.MatchString(id):
+			errs = append(errs, lc.T("settings.bad_contact", "Id", id))
+		case id == u.Id:
+			errs = append(errs, lc.T("settings.self_contact"))
+		case !hasString(u.Rooms, id):
+			errs = append(errs, lc.T("settings.unknown_contact", "Id", id))
+		}
+	}
+
+	return errs
+}
+
+func hasString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// remembers the chats the user talks in or is pushed to, which are the ones
+// they may choose contacts from
+func noteRooms(l *logging.Logger, userId string, roomIds ...string) {
+	known := users.User(userId).Rooms
+	added := false
+	for _, id := range roomIds {
+		if id != "" && id != userId && !hasString(known, id) {
+			added = true
+		}
+	}
+	if !added {
+		return
+	}
+
+	_, err := users.UpdateUser(userId, func(u *store.User) {
+		for _, id := range roomIds {
+			if id != "" && id != userId && !hasString(u.Rooms, id) {
+				u.Rooms = append(u.Rooms, id)
+			}
+		}
+		if n := len(u.Rooms) - maxKnownRooms; n > 0 {
+			u.Rooms = u.Rooms[n:]
+		}
+	})
+	if err != nil {
+		l.Error("failed to save the user's rooms", "err", err)
+	}
+}
+
+// a message the bot would send with the settings
+type previewMessage struct {
+	When, To string
+	Text     string
+	Sticker  string
+}
+
+func previewMessages(u store.User, personaId string) []previewMessage {
+	name, lc := lookupUser(u.Id)
+	if u.Lang != "" {
+		lc = locales.Get(u.Lang)
+	}
+	p := personas[lc.Tag].Get(personaId)
+	max := userMaxRepeats(u)
+	room := alarmRoom(u, u.Alarm)
+
+	list := []previewMessage{}
+	add := func(when, to, key string, vars persona.Vars) {
+		m := previewMessage{When: when, To: to, Text: say(p, key, vars)}
+		if s, ok := stickerFor(p, key); ok {
+			m.Sticker = s.String()
+		}
+		list = append(list, m)
+	}
+
+	if u.Alarm != nil {
+		day := u.Alarm.Next(clk.Now(), userLocation(u)).In(userLocation(u))
+		add(formatDateTime(lc, day), room, "alarm", persona.Vars{Name: name, Date: formatDate(lc, day), Holiday: holidayName(day)})
+		if city, ok := forecast.CityName(userCity(u)); ok {
+			list = append(list, previewMessage{To: room, Text: lc.T("settings.preview_forecast", "City", city)})
+		}
+	}
+
+	interval := time.Duration(alarmTimeout(u)) * time.Second
+	vars := persona.Vars{Name: name, MaxRepeats: max, Repeated: 1, AlertRoomId: strings.Join(u.Contacts, ", ")}
+	add(lc.N("settings.preview_snooze", max, "Minutes", int(math.Round(interval.Minutes()))), room, "snooze", vars)
+
+	vars.Repeated = max
+	add(lc.T("settings.preview_give_up", "Minutes", int(math.Round((time.Duration(max+1)*interval).Minutes()))), room, "give_up", vars)
+	if len(u.Contacts) > 0 {
+		add("", room, "alert_info", vars)
+	}
+	for _, id := range u.Contacts {
+		list = append(list, previewMessage{To: id, Text: say(roomPersona(id, lc), "alert", vars)})
+	}
+
+	add(lc.T("settings.preview_awake", "Replies", ackExample(u, lc)), room, "awake", persona.Vars{Name: name})
+	return list
+}
+
+// how the user tells they are up, for the preview
+func ackExample(u store.User, lc *i18n.Locale) string {
+	list := []string{conf.Snooze.AckPattern}
+	for _, p := range u.AckPhrases {
+		list = append(list, strconv.Quote(p))
+	}
+	return strings.Join(list, lc.T("settings.preview_or"))
+}
+
+type dayOption struct {
+	Value   int
+	Name    string
+	Checked bool
+}
+
+type personaOption struct {
+	Id, Name, Description string
+}
+
+func renderSettings(c *gin.Context, status int, u store.User, f settingsForm, errs []string, preview []previewMessage) {
+	lc := locales.Get(f.Lang)
+	days := []dayOption{}
+	for _, d := range []time.Weekday{1, 2, 3, 4, 5, 6, 0} {
+		days = append(days, dayOption{int(d), d.String()[:3], f.Days[d]})
+	}
+	list := []personaOption{}
+	for _, p := range personas[lc.Tag].List() {
+		list = append(list, personaOption{p.Id, p.Name, p.Description})
+	}
+	defaultCity, _ := forecast.CityName(conf.Forecast.City)
+
+	c.HTML(status, "settings.tmpl.html", gin.H{
+		"Page":        "settings",
+		"Admin":       isAdmin(u.Id),
+		"UserId":      u.Id,
+		"Form":        f,
+		"Days":        days,
+		"Langs":       locales.Tags(),
+		"Cities":      forecast.Cities,
+		"DefaultCity": defaultCity,
+		"MaxWindow":   int(conf.Sleep.MaxWindow.Minutes()),
+		"Personas":    list,
+		"Preview":     preview,
+		"Errors":      errs,
+		"Message":     c.Query("message"),
+	})
+}
+
+// GET /settings
+func onSettings(c *gin.Context) {
+	u := users.User(c.MustGet("userId").(string))
+	f := userSettings(u)
+	renderSettings(c, http.StatusOK, u, f, nil, previewMessages(u, f.Persona))
+}
+
+// POST /settings: saves the form, or only previews it with action=preview
+func onSaveSettings(c *gin.Context) {
+	l := requestLog(c)
+	u := users.User(c.MustGet("userId").(string))
+	_, lc := lookupUser(u.Id)
+	f := readSettingsForm(c)
+
+	updated := u
+	if errs := f.apply(&updated, lc); len(errs) > 0 {
+		renderSettings(c, http.StatusBadRequest, u, f, errs, nil)
+		return
+	}
+	if c.PostForm("action") == "preview" {
+		renderSettings(c, http.StatusOK, u, f, nil, previewMessages(updated, f.Persona))
+		return
+	}
+
+	// applied again to what is stored by now, so what changed meanwhile,
+	// like timers or the sleep log, is kept. That may have made the form
+	// invalid, e.g. when a contact's room was forgotten.
+	var errs []string
+	u, err := users.UpdateUser(u.Id, func(u *store.User) {
+		updated := *u
+		if errs = f.apply(&updated, lc); len(errs) == 0 {
+			*u = updated
+		}
+	})
+	if err == nil && len(errs) > 0 {
+		renderSettings(c, http.StatusBadRequest, u, f, errs, nil)
+		return
+	}
+	if err == nil {
+		_, err = users.UpdateRoom(u.Id, func(r *store.Room) { r.Persona = f.Persona })
+	}
+	if err != nil {
+		l.Error("failed to save settings", "user_id", u.Id, "err", err)
+		renderSettings(c, http.StatusInternalServerError, u, f, []string{lc.T("save_failed")}, nil)
+		return
+	}
+	scheduleAlarm(u)
+
+	l.Info("settings saved", "user_id", u.Id)
+	c.Redirect(http.StatusSeeOther, "/settings?message="+url.QueryEscape(lc.T("settings.saved")))
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"awake-bot/timeout"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// pushes and timeout callbacks running outside of HTTP handlers
+var inflight = &tracker{}
+
+type tracker struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (t *tracker) Add() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.n++
+}
+
+func (t *tracker) Done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.n--
+}
+
+// Go runs f in a goroutine which shutdown waits for.
+func (t *tracker) Go(f func()) {
+	t.Add()
+	go func() {
+		defer t.Done()
+		f()
+	}()
+}
+
+func (t *tracker) Wait(ctx context.Context) error {
+	for {
+		t.mu.Lock()
+		n := t.n
+		t.mu.Unlock()
+
+		if n == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// runServer runs srv until SIGTERM or SIGINT, then shuts it down gracefully.
+func runServer(srv *http.Server, path string) {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+
+	select {
+	case err := <-errc:
+		logger.Fatal("server stopped", "err", err)
+	case s := <-sig:
+		logger.Info("shutting down", "signal", s)
+	}
+
+	if err := shutdown(srv, conf.ShutdownTimeout, path); err != nil {
+		logger.Fatal("failed to shut down", "err", err)
+	}
+	logger.Info("shut down")
+}
+
+// shutdown ends the event streams, stops accepting requests, handles the
+// events already queued, stops every live session, waits for in-flight
+// pushes and checkpoints the sessions to path. Pushes waiting for a retry
+// stay in the outbox file.
+func shutdown(srv *http.Server, d time.Duration, path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	feed.Close() // event streams would keep the server busy
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Warn("requests did not finish", "err", err)
+	}
+	if err := webhooks.Close(ctx); err != nil {
+		logger.Warn("queued events were not handled", "err", err)
+	}
+
+	sessions := listSessions()
+	for _, to := range sessions {
+		to.Stop()
+	}
+
+	if err := inflight.Wait(ctx); err != nil {
+		logger.Warn("in-flight pushes did not finish", "err", err)
+	}
+	outgoing.Stop()
+
+	return checkpointSessions(path, sessions)
+}
+
+// writes the sessions to local disk, which only helps when it outlives the
+// process; a restarted Heroku dyno gets a fresh disk and resumes nothing
+func checkpointSessions(path string, sessions []*timeout.Timeout) error {
+	snapshots := []timeout.Snapshot{}
+	for _, to := range sessions {
+		snapshots = append(snapshots, to.Snapshot())
+	}
+
+	b, err := json.MarshalIndent(snapshots, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	logger.Info("sessions checkpointed", "count", len(snapshots), "path", path)
+	return nil
+}
+
+// restoreSessions resumes the sessions checkpointed at path and removes it.
+func restoreSessions(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	snapshots := []timeout.Snapshot{}
+	if err := json.Unmarshal(b, &snapshots); err != nil {
+		return err
+	}
+
+	for _, s := range snapshots {
+		to := timeout.Resume(clk, onTimeout, s)
+		if !putSession(to) {
+			to.Stop()
+			continue
+		}
+		sessionLog(to).Info("session resumed", "repeated", s.Repeated, "remaining", s.Remaining)
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"awake-bot/clock"
+	"awake-bot/config"
+	"awake-bot/forecast"
+	"awake-bot/linetest"
+	"awake-bot/logging"
+	"awake-bot/profile"
+	"awake-bot/store"
+	"awake-bot/timeout"
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const (
+	simulatorChannelSecret = "simulator-channel-secret"
+	simulatorChannelToken  = "simulator-channel-token"
+	simulatorBotToken      = "simulator-bot-token"
+)
+
+const simulatorHelp = `type a message to send it as the current user, or:
+  :as <userId>             switch the speaking user
+  :room <roomId>           switch the room (":room -" for a 1:1 chat)
+  :sticker <pkg> <id>      send a sticker
+  :postback <data>         tap a button, e.g. one the bot's confirmations show
+  :alert <roomId>          alert room used by :push ("-" to clear)
+  :push [timeout] <text>   trigger /push for the current user and room
+  :sessions                list running snoozes
+  :help                    show this help
+  :quit                    exit`
+
+// simulator drives the same handlers as the server from a terminal.
+type simulator struct {
+	router *gin.Engine
+	fake   *linetest.Server
+	out    io.Writer
+
+	mu     sync.Mutex
+	user   string
+	room   string
+	alert  string
+	tokens map[string]string // reply token -> room or user id
+}
+
+// runs the bot against an interactive terminal instead of LINE
+func simulate(args []string) {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	speed := fs.Float64("speed", 1, "make snooze intervals this many times shorter")
+	verbose := fs.Bool("v", false, "show server logs")
+	fs.Parse(args)
+
+	if !*verbose {
+		logger = logging.New(ioutil.Discard, logging.Error, logging.Logfmt, nil)
+	}
+	gin.SetMode(gin.ReleaseMode)
+
+	sim := newSimulator(os.Stdout, *speed)
+	defer sim.close()
+
+	fmt.Fprintln(sim.out, simulatorHelp)
+	sim.run(os.Stdin)
+}
+
+// sets the bot up as main does, with the configured behaviour talking to a
+// fake LINE
+func newSimulator(out io.Writer, speed float64) *simulator {
+	if c, err := config.Load(config.Path()); err == nil {
+		conf = c
+	}
+	clk = clock.Scaled(clock.Real(), speed)
+
+	sim := &simulator{out: out, user: "U0001", tokens: map[string]string{}}
+
+	sim.fake = linetest.NewServer(simulatorChannelToken)
+	sim.fake.Notify = sim.print
+
+	lb, err := newBot(simulatorChannelSecret, simulatorChannelToken, linebot.WithEndpointBase(sim.fake.URL))
+	if err != nil {
+		logger.Fatal("failed to create LINE client", "err", err)
+	}
+
+	bot = lb
+	profiles = profile.NewCache(profile.NewClient(http.DefaultClient, sim.fake.URL, simulatorChannelToken), profileTTL)
+	snooze = map[string]*timeout.Timeout{}
+	outgoing, _ = openOutbox("")
+	startWebhooks()
+	startFeed()
+	startWeb()
+
+	users, _ = store.Open("") // alarms and timezones last until :quit
+	scheduleAlarms()
+	scheduleTimers()
+
+	requestForecast = func(code int) ([]forecast.Forecast, error) {
+		return []forecast.Forecast{
+			{Date: "今日", Name: "晴れ", TempHigh: "20", TempLow: "12"},
+			{Date: "明日", Name: "晴時々曇"},
+		}, nil
+	}
+	conf.Push.Token = simulatorBotToken
+	if err := loadMessages(); err != nil {
+		logger.Fatal("failed to load messages", "err", err)
+	}
+	if err := compilePatterns(); err != nil {
+		logger.Fatal("failed to compile patterns", "err", err)
+	}
+
+	sim.router = newRouter()
+	return sim
+}
+
+func (sim *simulator) close() {
+	for _, to := range listSessions() {
+		to.Stop()
+	}
+	webhooks.Close(context.Background())
+	feed.Close()
+	sim.fake.Close()
+}
+
+func (sim *simulator) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+
+	for {
+		fmt.Fprint(sim.out, sim.prompt())
+		if !scanner.Scan() {
+			fmt.Fprintln(sim.out)
+			return
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, ":") {
+			sim.send(linetest.TextEvent(sim.user, sim.room, line))
+			continue
+		}
+
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case ":as":
+			if len(fields) == 2 {
+				sim.user = fields[1]
+			}
+		case ":room":
+			if len(fields) == 2 {
+				sim.room = strings.TrimPrefix(fields[1], "-")
+			}
+		case ":alert":
+			if len(fields) == 2 {
+				sim.alert = strings.TrimPrefix(fields[1], "-")
+			}
+		case ":sticker":
+			if len(fields) == 3 {
+				sim.send(linetest.StickerEvent(sim.user, sim.room, fields[1], fields[2]))
+			}
+		case ":postback":
+			if len(fields) > 1 {
+				data := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+				sim.send(linetest.PostbackEvent(sim.user, sim.room, data))
+			}
+		case ":push":
+			sim.push(fields[1:])
+		case ":sessions":
+			sim.sessions()
+		case ":help":
+			fmt.Fprintln(sim.out, simulatorHelp)
+		case ":quit":
+			return
+		default:
+			fmt.Fprintf(sim.out, "unknown command %s\n", fields[0])
+		}
+	}
+}
+
+func (sim *simulator) prompt() string {
+	if sim.room == "" {
+		return sim.user + "> "
+	}
+	return sim.user + "@" + sim.room + "> "
+}
+
+func (sim *simulator) target() string {
+	if sim.room == "" {
+		return sim.user
+	}
+	return sim.room
+}
+
+func (sim *simulator) send(event *linebot.Event) {
+	sim.mu.Lock()
+	sim.tokens[event.ReplyToken] = sim.target()
+	sim.mu.Unlock()
+
+	w := httptest.NewRecorder()
+	sim.router.ServeHTTP(w, linetest.NewWebhookRequest(simulatorChannelSecret, "/message", event))
+
+	if w.Code != http.StatusOK {
+		fmt.Fprintf(sim.out, "(webhook answered %d)\n", w.Code)
+	}
+}
+
+func (sim *simulator) push(args []string) {
+	form := url.Values{
+		"token":         {simulatorBotToken},
+		"user_id":       {sim.user},
+		"room_id":       {sim.room},
+		"alert_room_id": {sim.alert},
+	}
+
+	if len(args) > 0 {
+		if _, err := strconv.Atoi(args[0]); err == nil {
+			form.Set("timeout", args[0])
+			args = args[1:]
+		}
+	}
+	form.Set("message", strings.Join(args, " "))
+
+	req := httptest.NewRequest(http.MethodPost, "/push", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	sim.router.ServeHTTP(w, req)
+	fmt.Fprintf(sim.out, "(push answered %d)\n", w.Code)
+}
+
+func (sim *simulator) sessions() {
+	list := listSessions()
+
+	if len(list) == 0 {
+		fmt.Fprintln(sim.out, "(no snooze running)")
+	}
+	for _, to := range list {
+		fmt.Fprintf(sim.out, "%s: user %s, repeated %d, every %d sec\n", to.RoomId, to.GetMonitoringUserId(), to.GetRepeated(), to.Sec)
+	}
+}
+
+// prints what the bot said, called by the fake LINE server
+func (sim *simulator) print(call linetest.Call) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	to := call.To
+	if call.Endpoint == linebot.APIEndpointReplyMessage {
+		to = sim.tokens[call.ReplyToken]
+		delete(sim.tokens, call.ReplyToken)
+	}
+
+	for _, m := range call.Messages {
+		switch m.Type {
+		case "sticker":
+			fmt.Fprintf(sim.out, "\r[bot -> %s] (sticker %s/%s)\n", to, m.PackageID, m.StickerID)
+		case "template":
+			fmt.Fprintf(sim.out, "\r[bot -> %s] %s\n", to, m.AltText)
+			if m.Template == nil {
+				continue
+			}
+			for _, a := range m.Template.Actions {
+				if a.Type == "postback" {
+					fmt.Fprintf(sim.out, "    [%s] :postback %s\n", a.Label, a.Data)
+				} else {
+					fmt.Fprintf(sim.out, "    [%s] %s\n", a.Label, a.Text)
+				}
+			}
+		default:
+			fmt.Fprintf(sim.out, "\r[bot -> %s] %s\n", to, strings.Replace(m.Text, "\n", "\n    ", -1))
+		}
+	}
+	fmt.Fprint(sim.out, sim.prompt())
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"awake-bot/i18n"
+	"awake-bot/logging"
+	"awake-bot/schedule"
+	"awake-bot/sleep"
+	"awake-bot/store"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// nights /sleep stats sums up
+const statsNights = 7
+
+var (
+	remindedMu sync.Mutex
+	reminded   = map[string]time.Time{} // userId, the bedtime last reminded of
+)
+
+func sleepTarget(u store.User) time.Duration {
+	if u.SleepTarget > 0 {
+		return time.Duration(u.SleepTarget) * time.Minute
+	}
+	return conf.Sleep.Target
+}
+
+// the chats where the bot talks about the user's sleep: the 1:1 chat and
+// the group the alarm rings in
+func sleepChat(u store.User, roomId string) bool {
+	return roomId == u.Id || u.Alarm != nil && u.Alarm.RoomId == roomId
+}
+
+// whether the message says the user goes to bed
+func isBedtime(message linebot.Message) bool {
+	switch m := message.(type) {
+	case *linebot.TextMessage:
+		return bedPattern.MatchString(m.Text)
+	case *linebot.StickerMessage:
+		for _, s := range conf.Sleep.BedStickers {
+			if s.PackageId == m.PackageID && s.StickerId == m.StickerID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// when the user should go to bed to sleep enough before the next alarm
+func bedtime(u store.User, now time.Time) (time.Time, bool) {
+	alarm, next := nextAlarm(u, now)
+	if alarm == nil {
+		return time.Time{}, false
+	}
+	return next.Add(-sleepTarget(u)), true
+}
+
+// bedtime messages, the wake-up reply outside of a session and the
+// bedtime reminder. false when the message is none of them.
+func onSleepMessage(l *logging.Logger, event *linebot.Event) bool {
+	userId := event.Source.UserID
+	if userId == "" {
+		return false
+	}
+	u := users.User(userId)
+	if !sleepChat(u, sourceId(event.Source)) {
+		return false
+	}
+	_, lc := lookupUser(userId)
+	now := clk.Now()
+
+	if isBedtime(event.Message) {
+		bot.ReplyMessage(event.ReplyToken, newTextMessage(goToBed(l, u, now, lc))).Do()
+		return true
+	}
+
+	if text, ok := event.Message.(*linebot.TextMessage); ok && u.BedAt != nil && isAck(u, text.Text) {
+		if n, ok := wakeUpFromSleep(l, userId, now); ok {
+			bot.ReplyMessage(event.ReplyToken, newTextMessage(lc.T("sleep.slept", "Sleep", formatSleep(lc, n.Duration())))).Do()
+			return true
+		}
+		return false
+	}
+
+	// still up past bedtime, told once a night
+	at, ok := bedtime(u, now)
+	if !conf.Sleep.Remind || !ok || u.BedAt != nil || now.Before(at) {
+		return false
+	}
+	remindedMu.Lock()
+	done := !reminded[userId].Before(at)
+	reminded[userId] = at
+	remindedMu.Unlock()
+	if done {
+		return false
+	}
+
+	loc := userLocation(u)
+	alarm, next := nextAlarm(u, now)
+	l.Info("bedtime reminder", "user_id", userId, "bedtime", at)
+	bot.ReplyMessage(event.ReplyToken, newTextMessage(lc.T("sleep.remind",
+		"Bedtime", schedule.Alarm{Hour: at.In(loc).Hour(), Minute: at.In(loc).Minute()}.Clock(),
+		"Alarm", formatAlarm(lc, alarm), "Next", formatDateTime(lc, next.In(loc)),
+		"Sleep", formatSleep(lc, next.Sub(now))))).Do()
+	return true
+}
+
+// records the bedtime and says good night
+func goToBed(l *logging.Logger, u store.User, now time.Time, lc *i18n.Locale) string {
+	u, err := users.UpdateUser(u.Id, func(u *store.User) { u.BedAt = &now })
+	if err != nil {
+		l.Error("failed to save bedtime", "user_id", u.Id, "err", err)
+		return lc.T("save_failed")
+	}
+	l.Info("went to bed", "user_id", u.Id)
+	scheduleAlarm(u) // an alarm with a window rings at the end of a sleep cycle
+
+	alarm, next := nextAlarm(u, now)
+	if alarm == nil {
+		return lc.T("sleep.bed")
+	}
+	at := wakeTime(u, alarm, next)
+	return lc.T("sleep.bed_alarm", "Next", formatDateTime(lc, at.In(userLocation(u))), "Sleep", formatSleep(lc, at.Sub(now)))
+}
+
+// ends the night started by the bedtime message. false without one, or
+// when it was too short or too long to be a night.
+func wakeUpFromSleep(l *logging.Logger, userId string, now time.Time) (sleep.Night, bool) {
+	var n sleep.Night
+	ok := false
+	_, err := users.UpdateUser(userId, func(u *store.User) {
+		if u.BedAt == nil {
+			return
+		}
+		n = sleep.Night{Bed: *u.BedAt, Wake: now}
+		u.BedAt = nil
+		if ok = n.Valid(); ok {
+			u.Nights = sleep.Add(u.Nights, n, conf.Sleep.Nights)
+		}
+	})
+	if err != nil {
+		l.Error("failed to save sleep", "user_id", userId, "err", err)
+		return n, false
+	}
+	if ok {
+		l.Info("woke up", "user_id", userId, "slept", n.Duration())
+		sleepSeconds.Observe(n.Duration().Seconds())
+	}
+	return n, ok
+}
+
+// e.g. 7時間30分
+func formatSleep(lc *i18n.Locale, d time.Duration) string {
+	d = d.Round(time.Minute)
+	return lc.T("sleep.duration", "Hours", int(d.Hours()), "Minutes", int(d.Minutes())%60)
+}
+
+// /sleep goes to bed, /sleep stats sums up the last week and
+// /sleep target [H:MM] shows or sets the sleep wanted a night
+func onSleepCommand(event *linebot.Event, args []string, lc *i18n.Locale) string {
+	u := users.User(event.Source.UserID)
+	l := logger.With("user_id", u.Id)
+
+	if len(args) == 0 {
+		return goToBed(l, u, clk.Now(), lc)
+	}
+
+	switch args[0] {
+	case "stats":
+		nights := u.Nights
+		if len(nights) > statsNights {
+			nights = nights[len(nights)-statsNights:]
+		}
+		if len(nights) == 0 {
+			return lc.T("sleep.no_stats")
+		}
+		target := sleepTarget(u)
+		s := sleep.Summarize(nights, target, userLocation(u))
+		return lc.T("sleep.stats", "Nights", s.Nights, "Average", formatSleep(lc, s.Average),
+			"Debt", formatSleep(lc, s.Debt), "Target", formatSleep(lc, target),
+			"Bedtime", schedule.Alarm{Hour: int(s.Bedtime.Hours()), Minute: int(s.Bedtime.Minutes()) % 60}.Clock(),
+			"Spread", int(s.Spread.Minutes()))
+
+	case "target":
+		if len(args) == 1 {
+			return lc.T("sleep.target", "Target", formatSleep(lc, sleepTarget(u)))
+		}
+		h, m, err := schedule.ParseClock(args[1])
+		minutes := h*60 + m
+		if err != nil || minutes < 60 || minutes > 12*60 {
+			return lc.T("sleep.bad_target")
+		}
+		if _, err := users.UpdateUser(u.Id, func(u *store.User) { u.SleepTarget = minutes }); err != nil {
+			l.Error("failed to save sleep target", "err", err)
+			return lc.T("save_failed")
+		}
+		return lc.T("sleep.target_set", "Target", formatSleep(lc, time.Duration(minutes)*time.Minute))
+	}
+
+	return lc.T("command.usage", "Usage", lc.T("help.sleep"))
+}
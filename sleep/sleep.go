@@ -0,0 +1,97 @@
+// Package sleep keeps the nights users log and sums them up.
+package sleep
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// shorter is a nap or a mistake, longer is a forgotten morning
+	MinNight = 30 * time.Minute
+	MaxNight = 16 * time.Hour
+)
+
+// Night is from going to bed to waking up.
+type Night struct {
+	Bed  time.Time
+	Wake time.Time
+}
+
+func (n Night) Duration() time.Duration {
+	return n.Wake.Sub(n.Bed)
+}
+
+// Valid tells whether the night looks like a real one.
+func (n Night) Valid() bool {
+	d := n.Duration()
+	return d >= MinNight && d <= MaxNight
+}
+
+// Add appends n, keeping the last keep nights.
+func Add(nights []Night, n Night, keep int) []Night {
+	nights = append(nights, n)
+	if len(nights) > keep {
+		nights = append([]Night{}, nights[len(nights)-keep:]...)
+	}
+	return nights
+}
+
+type Stats struct {
+	Nights  int
+	Average time.Duration // sleep a night
+	Debt    time.Duration // sleep missing against the target, 0 when there is none
+	Bedtime time.Duration // average, since midnight in loc
+	Spread  time.Duration // standard deviation of bedtimes, the smaller the more regular
+}
+
+// Summarize sums up nights against target sleep a night.
+func Summarize(nights []Night, target time.Duration, loc *time.Location) Stats {
+	s := Stats{Nights: len(nights)}
+	if len(nights) == 0 {
+		return s
+	}
+
+	var total time.Duration
+	// bedtimes since noon, so 23:00 and 1:00 are two hours apart
+	offsets := []float64{}
+	for _, n := range nights {
+		total += n.Duration()
+		bed := n.Bed.In(loc)
+		since := time.Duration(bed.Hour())*time.Hour + time.Duration(bed.Minute())*time.Minute
+		offsets = append(offsets, float64((since+12*time.Hour)%(24*time.Hour)))
+	}
+	s.Average = total / time.Duration(len(nights))
+	if debt := target*time.Duration(len(nights)) - total; debt > 0 {
+		s.Debt = debt
+	}
+
+	mean := 0.0
+	for _, o := range offsets {
+		mean += o / float64(len(offsets))
+	}
+	variance := 0.0
+	for _, o := range offsets {
+		variance += (o - mean) * (o - mean) / float64(len(offsets))
+	}
+	s.Bedtime = (time.Duration(mean) + 12*time.Hour) % (24 * time.Hour)
+	s.Spread = time.Duration(math.Sqrt(variance))
+	return s
+}
+
+// WakeTime picks when to wake someone who went to bed at bed, no later than
+// latest and at most window before it: when the last sleep cycle ending in
+// the window ends, so they wake from light sleep. Sleep starts onset after
+// bed. latest when no cycle ends in the window.
+func WakeTime(bed, latest time.Time, window, onset, cycle time.Duration) time.Time {
+	asleep := bed.Add(onset)
+	if window <= 0 || cycle <= 0 || !asleep.Before(latest) {
+		return latest
+	}
+
+	at := asleep.Add(latest.Sub(asleep) / cycle * cycle)
+	if at.Before(latest.Add(-window)) {
+		return latest
+	}
+	return at
+}
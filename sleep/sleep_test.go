@@ -0,0 +1,98 @@
+package sleep
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	night := func(day, bedHour, bedMin int, d time.Duration) Night {
+		bed := time.Date(2026, 10, day, bedHour, bedMin, 0, 0, tokyo)
+		return Night{Bed: bed, Wake: bed.Add(d)}
+	}
+
+	nights := []Night{
+		night(18, 23, 0, 7*time.Hour),
+		night(20, 1, 0, 5*time.Hour),
+		night(20, 23, 30, 6*time.Hour+30*time.Minute),
+	}
+	s := Summarize(nights, 7*time.Hour, tokyo)
+
+	if s.Nights != 3 || s.Average != 6*time.Hour+10*time.Minute {
+		t.Errorf("average = %v over %d", s.Average, s.Nights)
+	}
+	if s.Debt != 2*time.Hour+30*time.Minute {
+		t.Errorf("debt = %v", s.Debt)
+	}
+	// 23:00, 1:00 and 23:30 average across midnight
+	if s.Bedtime != 23*time.Hour+50*time.Minute {
+		t.Errorf("bedtime = %v", s.Bedtime)
+	}
+	if s.Spread < 50*time.Minute || s.Spread > 55*time.Minute {
+		t.Errorf("spread = %v", s.Spread)
+	}
+
+	if s := Summarize(nights[:1], 6*time.Hour, tokyo); s.Debt != 0 || s.Spread != 0 {
+		t.Errorf("one long night = %+v", s)
+	}
+	if s := Summarize(nil, 7*time.Hour, tokyo); s != (Stats{}) {
+		t.Errorf("no nights = %+v", s)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	var nights []Night
+	for i := 0; i < 5; i++ {
+		nights = Add(nights, Night{Bed: time.Unix(int64(i), 0)}, 3)
+	}
+	if len(nights) != 3 || nights[0].Bed.Unix() != 2 || nights[2].Bed.Unix() != 4 {
+		t.Errorf("nights = %+v", nights)
+	}
+}
+
+func TestValid(t *testing.T) {
+	bed := time.Date(2026, 10, 19, 23, 0, 0, 0, time.UTC)
+	for d, want := range map[time.Duration]bool{
+		10 * time.Minute: false,
+		MinNight:         true,
+		7 * time.Hour:    true,
+		20 * time.Hour:   false,
+	} {
+		if got := (Night{Bed: bed, Wake: bed.Add(d)}).Valid(); got != want {
+			t.Errorf("%v: valid = %v", d, got)
+		}
+	}
+}
+
+func TestWakeTime(t *testing.T) {
+	latest := time.Date(2026, 10, 20, 7, 0, 0, 0, time.UTC)
+	at := func(h, m int) time.Time {
+		d := 20
+		if h > 12 {
+			d = 19
+		}
+		return time.Date(2026, 10, d, h, m, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		bed    time.Time
+		window time.Duration
+		want   time.Time
+	}{
+		// asleep 23:15, cycles end 0:45 2:15 3:45 5:15 6:45 8:15
+		{at(23, 0), 30 * time.Minute, at(6, 45)},
+		{at(23, 0), 10 * time.Minute, latest},
+		{at(23, 10), 30 * time.Minute, at(6, 55)},
+		{at(23, 20), 30 * time.Minute, latest}, // 7:05 is too late, 5:35 too early
+		{at(23, 20), 90 * time.Minute, at(5, 35)},
+		{at(23, 0), 0, latest},
+		{at(6, 50), 30 * time.Minute, latest}, // not asleep yet
+		{at(5, 0), 60 * time.Minute, at(6, 45)},
+	}
+	for _, tt := range tests {
+		if got := WakeTime(tt.bed, latest, tt.window, 15*time.Minute, 90*time.Minute); !got.Equal(tt.want) {
+			t.Errorf("bed %s, window %v: got %s, want %s", tt.bed.Format("15:04"), tt.window, got.Format("15:04"), tt.want.Format("15:04"))
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Package solar computes sunrise and sunset offline, with the sunrise
+// equation NOAA uses. Good to about a minute away from the poles.
+package solar
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	j2000 = 2451545.0 // julian day of 2000-01-01 12:00 UTC
+	unix  = 2440587.5 // julian day of 1970-01-01 00:00 UTC
+
+	// the upper limb touching the horizon, refraction included
+	horizon = -0.833
+	tilt    = 23.4397
+)
+
+// Sunrise returns when the sun rises on the day of date in its location, at
+// lat and lon in degrees (north and east positive). false when the sun
+// does not rise or set that day.
+func Sunrise(date time.Time, lat, lon float64) (time.Time, bool) {
+	rise, _, ok := Times(date, lat, lon)
+	return rise, ok
+}
+
+// Sunset is Sunrise for the evening.
+func Sunset(date time.Time, lat, lon float64) (time.Time, bool) {
+	_, set, ok := Times(date, lat, lon)
+	return set, ok
+}
+
+// Times returns both sunrise and sunset on the day of date, in date's
+// location.
+func Times(date time.Time, lat, lon float64) (time.Time, time.Time, bool) {
+	y, m, d := date.Date()
+	loc := date.Location()
+
+	// days since J2000 at the solar noon of the day
+	n := math.Round(float64(time.Date(y, m, d, 12, 0, 0, 0, time.UTC).Unix())/86400 + unix - j2000)
+	noon := n - lon/360
+
+	anomaly := math.Mod(357.5291+0.98560028*noon, 360)
+	center := 1.9148*sin(anomaly) + 0.02*sin(2*anomaly) + 0.0003*sin(3*anomaly)
+	ecliptic := math.Mod(anomaly+center+180+102.9372, 360)
+	transit := j2000 + noon + 0.0053*sin(anomaly) - 0.0069*sin(2*ecliptic)
+
+	declination := math.Asin(sin(ecliptic) * sin(tilt))
+	cosHour := (sin(horizon) - sin(lat)*math.Sin(declination)) / (cos(lat) * math.Cos(declination))
+	if cosHour < -1 || cosHour > 1 {
+		return time.Time{}, time.Time{}, false // midnight sun or polar night
+	}
+	hour := math.Acos(cosHour) * 180 / math.Pi / 360
+
+	return julian(transit-hour, loc), julian(transit+hour, loc), true
+}
+
+func julian(j float64, loc *time.Location) time.Time {
+	sec := (j - unix) * 86400
+	return time.Unix(0, int64(sec*1e9)).Round(time.Second).In(loc)
+}
+
+func sin(deg float64) float64 { return math.Sin(deg * math.Pi / 180) }
+func cos(deg float64) float64 { return math.Cos(deg * math.Pi / 180) }
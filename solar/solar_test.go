@@ -0,0 +1,50 @@
+package solar
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimes(t *testing.T) {
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	london, _ := time.LoadLocation("Europe/London")
+
+	// against the almanacs, to the minute
+	for _, c := range []struct {
+		name     string
+		date     time.Time
+		lat, lon float64
+		rise     string
+		set      string
+	}{
+		{"tokyo", time.Date(2026, 10, 19, 23, 0, 0, 0, tokyo), 35.6895, 139.6917, "05:50", "17:02"},
+		{"sapporo winter", time.Date(2026, 12, 21, 0, 0, 0, 0, tokyo), 43.0642, 141.3469, "07:03", "16:02"},
+		{"naha", time.Date(2026, 1, 1, 0, 0, 0, 0, tokyo), 26.2124, 127.6809, "07:17", "17:48"},
+		{"london summer", time.Date(2026, 6, 21, 0, 0, 0, 0, london), 51.5074, -0.1278, "04:43", "21:21"},
+	} {
+		rise, set, ok := Times(c.date, c.lat, c.lon)
+		if !ok {
+			t.Errorf("%s: no sunrise", c.name)
+			continue
+		}
+		if got := rise.Round(time.Minute).Format("15:04"); got != c.rise {
+			t.Errorf("%s: sunrise = %s, want %s", c.name, got, c.rise)
+		}
+		if got := set.Round(time.Minute).Format("15:04"); got != c.set {
+			t.Errorf("%s: sunset = %s, want %s", c.name, got, c.set)
+		}
+		if y, m, d := c.date.Date(); rise.Location() != c.date.Location() || rise.Day() != d || rise.Month() != m || rise.Year() != y {
+			t.Errorf("%s: sunrise on %v", c.name, rise)
+		}
+	}
+}
+
+func TestPolar(t *testing.T) {
+	// tromsø
+	if _, ok := Sunrise(time.Date(2026, 6, 21, 0, 0, 0, 0, time.UTC), 69.65, 18.96); ok {
+		t.Error("the sun set in the midnight sun")
+	}
+	if _, ok := Sunset(time.Date(2026, 12, 21, 0, 0, 0, 0, time.UTC), 69.65, 18.96); ok {
+		t.Error("the sun rose in the polar night")
+	}
+}
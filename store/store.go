@@ -0,0 +1,218 @@
+// Package store keeps per-user and per-room settings in a JSON file.
+package store
+
+import (
+	"awake-bot/schedule"
+	"awake-bot/sleep"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type User struct {
+	Id       string
+	TimeZone string          `json:",omitempty"` // IANA name, the configured default when empty
+	Lang     string          `json:",omitempty"` // locale tag, the LINE language when empty
+	Alarm    *schedule.Alarm `json:",omitempty"`
+	Once     *schedule.Alarm `json:",omitempty"` // a one-off alarm, ringing besides Alarm
+
+	City       int      `json:",omitempty"` // forecast city code, the configured one when 0
+	MaxRepeats int      `json:",omitempty"` // snoozes before giving up, the configured number when 0
+	SnoozeSec  int      `json:",omitempty"` // between the alarm's snoozes, alarm.timeout when 0
+	AckPhrases []string `json:",omitempty"` // replies which wake the user besides snooze.ack_pattern
+	Contacts   []string `json:",omitempty"` // user, group or room ids told when the user does not wake up
+	Rooms      []string `json:",omitempty"` // chats the user talked in or was pushed to, oldest first; contacts are chosen from them
+
+	Timers []Timer `json:",omitempty"` // ordered by At
+
+	BedAt       *time.Time    `json:",omitempty"` // went to bed and not up yet
+	Nights      []sleep.Night `json:",omitempty"` // oldest first
+	SleepTarget int           `json:",omitempty"` // minutes a night, sleep.target when 0
+
+	Pause *Pause `json:",omitempty"` // kept until the user is welcomed back
+}
+
+// Pause suspends a user's alarms and escalations, set by /vacation or /pause.
+type Pause struct {
+	From  time.Time
+	Until time.Time // the midnight after the last day
+}
+
+// Timer is a one-off /nap, /timer or /remind.
+type Timer struct {
+	Id     string
+	Kind   string // nap, timer or remind
+	At     time.Time
+	RoomId string // where it fires
+	Label  string `json:",omitempty"`
+}
+
+// Room is a group, a multi-person chat or a user's 1:1 chat.
+type Room struct {
+	Id          string
+	Persona     string `json:",omitempty"` // the default one when empty
+	Owner       string `json:",omitempty"` // user id, for groups; see onClaimCommand
+	Unreachable bool   `json:",omitempty"` // blocked by the user or removed from the group
+}
+
+type data struct {
+	Users map[string]*User
+	Rooms map[string]*Room
+}
+
+// Store is safe for concurrent use. Values returned are copies; change them
+// with the Update methods, which write the file before returning.
+type Store struct {
+	mu   sync.Mutex
+	path string
+	data data
+}
+
+// Open reads path, which does not need to exist yet. An empty path keeps
+// everything in memory.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, data: data{Users: map[string]*User{}, Rooms: map[string]*Room{}}}
+	if path == "" {
+		return s, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &s.data); err != nil {
+		return nil, err
+	}
+	if s.data.Users == nil {
+		s.data.Users = map[string]*User{}
+	}
+	if s.data.Rooms == nil {
+		s.data.Rooms = map[string]*Room{}
+	}
+	return s, nil
+}
+
+// User returns the user, or an empty one with the id.
+func (s *Store) User(id string) User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.data.Users[id]; ok {
+		return *u
+	}
+	return User{Id: id}
+}
+
+// Users returns every known user ordered by id.
+func (s *Store) Users() []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := []User{}
+	for _, u := range s.data.Users {
+		list = append(list, *u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list
+}
+
+// UpdateUser applies f to the user, creating it if needed, and saves.
+func (s *Store) UpdateUser(id string, f func(*User)) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.data.Users[id]
+	if !ok {
+		u = &User{Id: id}
+	}
+	updated := *u
+	f(&updated)
+	updated.Id = id
+
+	old := s.data.Users[id]
+	s.data.Users[id] = &updated
+	if err := s.save(); err != nil {
+		if old == nil {
+			delete(s.data.Users, id)
+		} else {
+			s.data.Users[id] = old
+		}
+		return *u, err
+	}
+
+	return updated, nil
+}
+
+// Room returns the room, or an empty one with the id.
+func (s *Store) Room(id string) Room {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.data.Rooms[id]; ok {
+		return *r
+	}
+	return Room{Id: id}
+}
+
+// UpdateRoom applies f to the room, creating it if needed, and saves.
+func (s *Store) UpdateRoom(id string, f func(*Room)) (Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.data.Rooms[id]
+	if !ok {
+		r = &Room{Id: id}
+	}
+	updated := *r
+	f(&updated)
+	updated.Id = id
+
+	old := s.data.Rooms[id]
+	s.data.Rooms[id] = &updated
+	if err := s.save(); err != nil {
+		if old == nil {
+			delete(s.data.Rooms, id)
+		} else {
+			s.data.Rooms[id] = old
+		}
+		return *r, err
+	}
+
+	return updated, nil
+}
+
+// writes atomically; called with mu held
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
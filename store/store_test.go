@@ -0,0 +1,70 @@
+package store
+
+import (
+	"awake-bot/schedule"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateUserPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u := s.User("U1"); u.Id != "U1" || u.TimeZone != "" {
+		t.Errorf("unknown user = %+v", u)
+	}
+
+	_, err = s.UpdateUser("U1", func(u *User) {
+		u.TimeZone = "Asia/Singapore"
+		u.Alarm = &schedule.Alarm{Hour: 7, Weekdays: schedule.Weekdays}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := reopened.User("U1")
+	if u.TimeZone != "Asia/Singapore" || u.Alarm == nil || u.Alarm.Hour != 7 || len(u.Alarm.Weekdays) != 5 {
+		t.Errorf("reopened user = %+v", u)
+	}
+	if len(reopened.Users()) != 1 {
+		t.Errorf("users = %+v", reopened.Users())
+	}
+}
+
+func TestUserIsACopy(t *testing.T) {
+	s, _ := Open("")
+	s.UpdateUser("U1", func(u *User) { u.TimeZone = "Asia/Tokyo" })
+
+	u := s.User("U1")
+	u.TimeZone = "UTC"
+
+	if s.User("U1").TimeZone != "Asia/Tokyo" {
+		t.Error("store is changed through a returned user")
+	}
+}
+
+func TestUpdateRoom(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	s, _ := Open(path)
+
+	if _, err := s.UpdateRoom("G1", func(r *Room) { r.Persona = "gentle" }); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, _ := Open(path)
+	if r := reopened.Room("G1"); r.Persona != "gentle" {
+		t.Errorf("room = %+v", r)
+	}
+	if r := reopened.Room("G2"); r.Id != "G2" || r.Persona != "" {
+		t.Errorf("unknown room = %+v", r)
+	}
+}
@@ -0,0 +1,179 @@
+// Package stream fans session events out to live subscribers and keeps the
+// latest ones, so a subscriber coming back can pick up where it left off.
+package stream
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is something which happened to a session.
+type Event struct {
+	Id        int64     `json:"id"`
+	Type      string    `json:"type"` // started, snoozed, acknowledged, escalated or cancelled
+	At        time.Time `json:"at"`
+	RoomId    string    `json:"roomId"`
+	UserId    string    `json:"userId,omitempty"`
+	SessionId string    `json:"sessionId,omitempty"`
+	Repeated  int       `json:"repeated"`
+}
+
+// Broker keeps the last events in a ring buffer and hands new ones to every
+// subscriber. A subscriber which does not keep up is dropped rather than
+// slowing down the publisher; it can subscribe again from its last id.
+type Broker struct {
+	mu     sync.Mutex
+	ring   []Event
+	start  int // index of the oldest event in ring
+	n      int
+	nextId int64
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// NewBroker keeps up to size events for resuming.
+func NewBroker(size int) *Broker {
+	if size <= 0 {
+		size = 1
+	}
+	return &Broker{
+		ring:   make([]Event, size),
+		nextId: 1,
+		subs:   map[*Subscription]struct{}{},
+	}
+}
+
+// Subscription receives events on C until it is closed. C is closed when the
+// subscriber fell behind or the broker was closed.
+type Subscription struct {
+	C <-chan Event
+
+	// events after the requested id which were still buffered
+	Backlog []Event
+	// some events after the requested id are not buffered anymore
+	Gap bool
+
+	c       chan Event
+	room    string
+	b       *Broker
+	dropped bool
+}
+
+// Subscribe starts receiving events of room, or of every room when it is
+// empty. Events after lastId are put into Backlog; 0 asks for none. size is
+// how many events may wait for the subscriber before it is dropped.
+func (b *Broker) Subscribe(lastId int64, room string, size int) *Subscription {
+	if size <= 0 {
+		size = 1
+	}
+	c := make(chan Event, size)
+	s := &Subscription{C: c, c: c, room: room, b: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if lastId > 0 {
+		s.Backlog, s.Gap = b.since(lastId, room)
+	}
+	if b.closed {
+		close(c)
+		return s
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// buffered events after lastId. b.mu is held.
+func (b *Broker) since(lastId int64, room string) ([]Event, bool) {
+	list := []Event{}
+	oldest := b.nextId - int64(b.n)
+	// an id from before a restart, or older than the buffer
+	gap := lastId >= b.nextId || lastId < oldest-1
+	for i := 0; i < b.n; i++ {
+		e := b.ring[(b.start+i)%len(b.ring)]
+		if (gap || e.Id > lastId) && matches(room, e) {
+			list = append(list, e)
+		}
+	}
+	return list, gap
+}
+
+func matches(room string, e Event) bool {
+	return room == "" || e.RoomId == room
+}
+
+// Publish numbers e, buffers it and sends it to the subscribers of its room.
+func (b *Broker) Publish(e Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e.Id = b.nextId
+	b.nextId++
+	if b.n < len(b.ring) {
+		b.ring[(b.start+b.n)%len(b.ring)] = e
+		b.n++
+	} else {
+		b.ring[b.start] = e
+		b.start = (b.start + 1) % len(b.ring)
+	}
+
+	for s := range b.subs {
+		if !matches(s.room, e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			s.dropped = true
+			b.remove(s)
+		}
+	}
+	return e
+}
+
+// LastId is the id of the latest event, 0 when there was none.
+func (b *Broker) LastId() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.nextId - 1
+}
+
+// Subscribers counts the live subscriptions.
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// Close ends every subscription. Later ones are closed at once.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subs {
+		b.remove(s)
+	}
+}
+
+// b.mu is held
+func (b *Broker) remove(s *Subscription) {
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.c)
+	}
+}
+
+// Close stops receiving events.
+func (s *Subscription) Close() {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	s.b.remove(s)
+}
+
+// Dropped tells whether C was closed because the subscriber fell behind.
+func (s *Subscription) Dropped() bool {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	return s.dropped
+}
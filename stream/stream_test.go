@@ -0,0 +1,138 @@
+package stream
+
+import (
+	"testing"
+)
+
+func ids(events []Event) []int64 {
+	list := []int64{}
+	for _, e := range events {
+		list = append(list, e.Id)
+	}
+	return list
+}
+
+func equal(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPublishFiltersByRoom(t *testing.T) {
+	b := NewBroker(10)
+	all := b.Subscribe(0, "", 10)
+	g1 := b.Subscribe(0, "G1", 10)
+	defer all.Close()
+	defer g1.Close()
+
+	b.Publish(Event{Type: "started", RoomId: "G1"})
+	b.Publish(Event{Type: "started", RoomId: "U1"})
+	b.Publish(Event{Type: "snoozed", RoomId: "G1"})
+
+	if e := <-all.C; e.Id != 1 || e.Type != "started" {
+		t.Errorf("first event is %+v", e)
+	}
+	if e := <-all.C; e.Id != 2 || e.RoomId != "U1" {
+		t.Errorf("second event is %+v", e)
+	}
+	if e := <-g1.C; e.Id != 1 {
+		t.Errorf("G1 got %+v", e)
+	}
+	if e := <-g1.C; e.Id != 3 || e.Type != "snoozed" {
+		t.Errorf("G1 got %+v", e)
+	}
+	if len(g1.C) != 0 {
+		t.Errorf("G1 got events of other rooms")
+	}
+}
+
+func TestResume(t *testing.T) {
+	b := NewBroker(3)
+	for _, room := range []string{"G1", "U1", "G1", "U1", "G1"} {
+		b.Publish(Event{Type: "started", RoomId: room})
+	}
+	// 3, 4 and 5 are buffered
+
+	tests := []struct {
+		lastId int64
+		room   string
+		want   []int64
+		gap    bool
+	}{
+		{0, "", []int64{}, false},
+		{5, "", []int64{}, false},
+		{3, "", []int64{4, 5}, false},
+		{2, "", []int64{3, 4, 5}, false},
+		{2, "G1", []int64{3, 5}, false},
+		{1, "", []int64{3, 4, 5}, true},
+		{9, "", []int64{3, 4, 5}, true}, // from before a restart
+	}
+	for _, tt := range tests {
+		s := b.Subscribe(tt.lastId, tt.room, 1)
+		if !equal(ids(s.Backlog), tt.want) || s.Gap != tt.gap {
+			t.Errorf("Subscribe(%d, %q) = %v gap %v, want %v gap %v", tt.lastId, tt.room, ids(s.Backlog), s.Gap, tt.want, tt.gap)
+		}
+		s.Close()
+	}
+
+	if b.LastId() != 5 {
+		t.Errorf("LastId() = %d", b.LastId())
+	}
+}
+
+func TestSlowSubscriberDropped(t *testing.T) {
+	b := NewBroker(10)
+	slow := b.Subscribe(0, "", 2)
+	fast := b.Subscribe(0, "", 10)
+	defer fast.Close()
+
+	for i := 0; i < 3; i++ {
+		b.Publish(Event{Type: "snoozed", RoomId: "G1"})
+	}
+
+	got := []Event{}
+	for e := range slow.C {
+		got = append(got, e)
+	}
+	if !slow.Dropped() || len(got) != 2 {
+		t.Errorf("slow subscriber got %v, dropped %v", ids(got), slow.Dropped())
+	}
+	if fast.Dropped() || len(fast.C) != 3 {
+		t.Errorf("fast subscriber has %d events, dropped %v", len(fast.C), fast.Dropped())
+	}
+	if b.Subscribers() != 1 {
+		t.Errorf("%d subscribers", b.Subscribers())
+	}
+
+	// catching up from the last one it got
+	again := b.Subscribe(got[len(got)-1].Id, "", 2)
+	defer again.Close()
+	if !equal(ids(again.Backlog), []int64{3}) {
+		t.Errorf("resumed with %v", ids(again.Backlog))
+	}
+}
+
+func TestClose(t *testing.T) {
+	b := NewBroker(10)
+	s := b.Subscribe(0, "", 1)
+	b.Close()
+
+	if _, ok := <-s.C; ok {
+		t.Error("subscription was not closed")
+	}
+	if s.Dropped() {
+		t.Error("closing the broker dropped the subscriber")
+	}
+	s.Close() // twice is fine
+
+	later := b.Subscribe(0, "", 1)
+	if _, ok := <-later.C; ok {
+		t.Error("subscribed to a closed broker")
+	}
+}
@@ -1,58 +1,204 @@
 package timeout
 
 import (
-	"log"
+	"awake-bot/clock"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
 	"time"
 )
 
 type Timeout struct {
+	Id          string // for correlating logs of a session
 	onTimeout   func(*Timeout)
+	clock       clock.Clock
 	Sec         int
 	RoomId      string
 	userId      string
 	AlertRoomId string
-	Repeated    int
-	canceled    bool
+	Repeated    int // guarded by mu once started, read it with GetRepeated
+	StartedAt   time.Time
+	WakeAt      time.Time // chosen inside the wake window, zero for alarms ringing on time
+	Latest      time.Time // the end of the wake window
+
+	mu       sync.Mutex
+	timer    clock.Timer
+	fireAt   time.Time
+	canceled bool
+	pending  bool // the wait is not over yet
+	gen      int  // of the timer, so one stopped too late does not fire
 }
 
-func New(f func(*Timeout), timeout int, roomId string, userId string, alertRoomId string) *Timeout {
-	to := Timeout{f, timeout, roomId, userId, alertRoomId, 0, false}
-	go setTimeout(&to)
-	return &to
+// Snapshot is what is needed to resume a Timeout after a restart.
+type Snapshot struct {
+	Id             string
+	RoomId         string
+	UserId         string
+	AlertRoomId    string
+	Sec            int
+	Repeated       int
+	StartedAt      time.Time
+	WakeAt         time.Time
+	Latest         time.Time
+	CheckpointedAt time.Time
+	Remaining      time.Duration
 }
 
-func NewTimeout(f func(), timeout int) {
-	go invokeAfter(f, timeout)
+func New(clk clock.Clock, f func(*Timeout), timeout int, roomId string, userId string, alertRoomId string) *Timeout {
+	to := &Timeout{Id: newId(), onTimeout: f, clock: clk, Sec: timeout, RoomId: roomId, userId: userId, AlertRoomId: alertRoomId, StartedAt: clk.Now()}
+	to.setTimeout()
+	return to
 }
 
-func invokeAfter(f func(), timeout int) {
-	time.Sleep(time.Duration(timeout) * time.Second)
+// Resume restarts a checkpointed Timeout, firing when its remaining time has passed.
+func Resume(clk clock.Clock, f func(*Timeout), s Snapshot) *Timeout {
+	to := &Timeout{Id: s.Id, onTimeout: f, clock: clk, Sec: s.Sec, RoomId: s.RoomId, userId: s.UserId, AlertRoomId: s.AlertRoomId, Repeated: s.Repeated, StartedAt: s.StartedAt, WakeAt: s.WakeAt, Latest: s.Latest}
 
-	f()
+	wait := s.CheckpointedAt.Add(s.Remaining).Sub(clk.Now())
+	if wait < 0 {
+		wait = 0
+	}
+	to.start(wait)
+	return to
 }
 
-// invoke func after timeout sec
-func setTimeout(to *Timeout) {
-	time.Sleep(time.Duration(to.Sec) * time.Second)
+// invoke func after timeout sec. Stop the returned timer to cancel it.
+func NewTimeout(clk clock.Clock, f func(), timeout int) clock.Timer {
+	return clk.AfterFunc(time.Duration(timeout)*time.Second, f)
+}
 
-	if to.canceled {
-		return
-	}
+// invoke onTimeout after timeout sec
+func (to *Timeout) setTimeout() {
+	to.start(time.Duration(to.Sec) * time.Second)
+}
 
-	log.Printf("[info] Timed-out %d", to.Sec)
-	to.onTimeout(to)
+func (to *Timeout) start(d time.Duration) {
+	to.mu.Lock()
+	defer to.mu.Unlock()
+
+	to.startLocked(d)
+}
+
+func (to *Timeout) startLocked(d time.Duration) {
+	to.gen++
+	gen := to.gen
+	to.pending = true
+	to.fireAt = to.clock.Now().Add(d)
+	to.timer = to.clock.AfterFunc(d, func() {
+		if to.take(gen) {
+			to.onTimeout(to)
+		}
+	})
+}
+
+// whether the wait of the timer gen is over and not taken by FireNow or
+// cancelled, taking it when so
+func (to *Timeout) take(gen int) bool {
+	to.mu.Lock()
+	defer to.mu.Unlock()
+
+	return to.takeLocked(gen)
+}
+
+func (to *Timeout) takeLocked(gen int) bool {
+	if to.canceled || !to.pending || gen != to.gen {
+		return false
+	}
+	to.pending = false
+	return true
 }
 
 func (to *Timeout) GetMonitoringUserId() string {
 	return to.userId
 }
 
+// GetRepeated returns the snoozes so far.
+func (to *Timeout) GetRepeated() int {
+	to.mu.Lock()
+	defer to.mu.Unlock()
+
+	return to.Repeated
+}
+
+// SetWakeWindow records when the smart alarm chose to ring and the latest
+// it could have.
+func (to *Timeout) SetWakeWindow(wakeAt, latest time.Time) {
+	to.mu.Lock()
+	defer to.mu.Unlock()
+
+	to.WakeAt, to.Latest = wakeAt, latest
+}
+
+// GetWakeWindow returns what SetWakeWindow recorded.
+func (to *Timeout) GetWakeWindow() (wakeAt, latest time.Time) {
+	to.mu.Lock()
+	defer to.mu.Unlock()
+
+	return to.WakeAt, to.Latest
+}
+
 func (to *Timeout) Snooze() {
+	to.mu.Lock()
+	defer to.mu.Unlock()
+
 	to.Repeated++
-	go setTimeout(to)
+	to.startLocked(time.Duration(to.Sec) * time.Second)
+}
+
+// FireNow calls onTimeout right away instead of when the wait is over. It
+// does nothing once the wait is over or the timeout is stopped.
+func (to *Timeout) FireNow() {
+	to.mu.Lock()
+	if to.timer != nil {
+		to.timer.Stop()
+	}
+	taken := to.takeLocked(to.gen)
+	to.mu.Unlock()
+	if !taken {
+		return
+	}
+
+	to.onTimeout(to)
 }
 
 func (to *Timeout) Stop() {
-	log.Printf("[info] Snooze canceled.")
+	to.mu.Lock()
+	defer to.mu.Unlock()
+
 	to.canceled = true
+	if to.timer != nil {
+		to.timer.Stop()
+	}
+}
+
+// Snapshot returns the state of the timeout. Stop it first to keep it from changing.
+func (to *Timeout) Snapshot() Snapshot {
+	to.mu.Lock()
+	defer to.mu.Unlock()
+
+	now := to.clock.Now()
+	remaining := to.fireAt.Sub(now)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return Snapshot{
+		Id:             to.Id,
+		RoomId:         to.RoomId,
+		UserId:         to.userId,
+		AlertRoomId:    to.AlertRoomId,
+		Sec:            to.Sec,
+		Repeated:       to.Repeated,
+		StartedAt:      to.StartedAt,
+		WakeAt:         to.WakeAt,
+		Latest:         to.Latest,
+		CheckpointedAt: now,
+		Remaining:      remaining,
+	}
+}
+
+func newId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,68 @@
+package timeout
+
+import (
+	"awake-bot/clock"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFireNowFiresOnce(t *testing.T) {
+	c := clock.NewFake(time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC))
+	fired := 0
+	to := New(c, func(*Timeout) { fired++ }, 300, "G1", "U1", "")
+
+	to.FireNow()
+	c.Advance(10 * time.Minute)
+	if fired != 1 {
+		t.Errorf("fired %d times after FireNow and the wait", fired)
+	}
+
+	// over already
+	to.FireNow()
+	if fired != 1 {
+		t.Errorf("fired %d times after the wait was taken", fired)
+	}
+
+	// a snooze waits again
+	to.Snooze()
+	to.FireNow()
+	c.Advance(10 * time.Minute)
+	if fired != 2 || to.GetRepeated() != 1 {
+		t.Errorf("fired %d times, repeated %d", fired, to.GetRepeated())
+	}
+
+	to.Snooze()
+	to.Stop()
+	to.FireNow()
+	c.Advance(10 * time.Minute)
+	if fired != 2 {
+		t.Errorf("fired %d times after Stop", fired)
+	}
+}
+
+func TestSnoozeWhileSnapshotting(t *testing.T) {
+	c := clock.NewFake(time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC))
+	to := New(c, func(*Timeout) {}, 300, "G1", "U1", "")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			to.Snooze()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			to.Snapshot()
+			to.GetRepeated()
+		}
+	}()
+	wg.Wait()
+
+	if s := to.Snapshot(); s.Repeated != 100 {
+		t.Errorf("repeated = %d", s.Repeated)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"awake-bot/clock"
+	"awake-bot/i18n"
+	"awake-bot/schedule"
+	"awake-bot/store"
+	"awake-bot/timeout"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const (
+	maxTimers         = 10 // per user
+	maxTimerWait      = 24 * time.Hour
+	defaultNapMinutes = 20
+	maxNapMinutes     = 180
+)
+
+var (
+	timersMu     sync.Mutex
+	activeTimers = map[string]clock.Timer{} // timer id
+
+	errTooManyTimers = errors.New("too many timers")
+)
+
+func scheduleTimers() {
+	for _, u := range users.Users() {
+		for _, t := range u.Timers {
+			armTimer(u.Id, t)
+		}
+	}
+}
+
+// fires right away when the bot was down at the time
+func armTimer(userId string, t store.Timer) {
+	timersMu.Lock()
+	defer timersMu.Unlock()
+
+	if old, ok := activeTimers[t.Id]; ok {
+		old.Stop()
+	}
+	sec := int(math.Ceil(t.At.Sub(clk.Now()).Seconds()))
+	if sec < 0 {
+		sec = 0
+	}
+	id := t.Id
+	activeTimers[id] = timeout.NewTimeout(clk, func() { fireTimer(userId, id) }, sec)
+
+	logger.Debug("timer scheduled", "user_id", userId, "timer_id", id, "kind", t.Kind, "at", t.At)
+}
+
+func disarmTimer(id string) {
+	timersMu.Lock()
+	defer timersMu.Unlock()
+
+	if t, ok := activeTimers[id]; ok {
+		t.Stop()
+		delete(activeTimers, id)
+	}
+}
+
+func fireTimer(userId, id string) {
+	inflight.Add()
+	defer inflight.Done()
+
+	timersMu.Lock()
+	delete(activeTimers, id)
+	timersMu.Unlock()
+
+	// gone before firing, so it never fires twice
+	var fired *store.Timer
+	u, err := users.UpdateUser(userId, func(u *store.User) {
+		for i, t := range u.Timers {
+			if t.Id == id {
+				fired = &t
+				u.Timers = append(u.Timers[:i:i], u.Timers[i+1:]...)
+				return
+			}
+		}
+	})
+	if fired == nil {
+		return // cancelled
+	}
+	l := logger.With("user_id", userId, "room_id", fired.RoomId, "timer_id", id, "kind", fired.Kind)
+	if err != nil {
+		l.Error("failed to remove the timer", "err", err)
+	}
+	if late := clk.Now().Sub(fired.At); late > time.Minute {
+		l.Warn("timer fired late", "late", late)
+	}
+	if users.Room(fired.RoomId).Unreachable {
+		l.Warn("the room blocked or removed the bot. timer dropped.")
+		return
+	}
+
+	if fired.Kind == "nap" {
+		// a nap wakes up like an alarm, and is skipped like one
+		if paused(u, clk.Now()) {
+			l.Info("the user is paused. nap skipped.")
+			return
+		}
+		wakeUp(l, u, fired.RoomId, false)
+		return
+	}
+
+	_, lc := lookupUser(userId)
+	if err := pushMessage(fired.RoomId, strings.TrimSpace(lc.T(fired.Kind+".fired", "Label", fired.Label))); err != nil {
+		l.Error("failed to push timer", "err", err)
+		return
+	}
+	l.Info("timer fired")
+}
+
+// saves and arms a timer firing in the chat of the event
+func addTimer(event *linebot.Event, kind string, at time.Time, label string) (store.Timer, error) {
+	userId := event.Source.UserID
+	t := store.Timer{Id: newId(), Kind: kind, At: at, RoomId: sourceId(event.Source), Label: label}
+
+	full := false
+	_, err := users.UpdateUser(userId, func(u *store.User) {
+		if len(u.Timers) >= maxTimers {
+			full = true
+			return
+		}
+		// a copy, as the old array is shared with copies of the user
+		ts := append(append([]store.Timer{}, u.Timers...), t)
+		sort.SliceStable(ts, func(i, j int) bool { return ts[i].At.Before(ts[j].At) })
+		u.Timers = ts
+	})
+	if err != nil {
+		logger.Error("failed to save timer", "user_id", userId, "err", err)
+		return t, err
+	}
+	if full {
+		return t, errTooManyTimers
+	}
+
+	armTimer(userId, t)
+	return t, nil
+}
+
+func timerReply(lc *i18n.Locale, err error) string {
+	if err == errTooManyTimers {
+		return lc.T("timer.full", "Max", maxTimers)
+	}
+	return lc.T("save_failed")
+}
+
+// /nap [minutes]
+func onNapCommand(event *linebot.Event, args []string, lc *i18n.Locale) string {
+	minutes := defaultNapMinutes
+	if len(args) > 0 {
+		n, err := strconv.Atoi(strings.TrimSuffix(args[0], "分"))
+		if err != nil || n < 1 || n > maxNapMinutes {
+			return lc.T("nap.bad_minutes", "Max", maxNapMinutes)
+		}
+		minutes = n
+	}
+
+	now := clk.Now()
+	t, err := addTimer(event, "nap", now.Add(time.Duration(minutes)*time.Minute), "")
+	if err != nil {
+		return timerReply(lc, err)
+	}
+	loc := userLocation(users.User(event.Source.UserID))
+	return lc.T("nap.set", "Minutes", minutes, "At", formatDateTime(lc, t.At.In(loc)))
+}
+
+// /timer 3m [label]
+func onTimerCommand(event *linebot.Event, args []string, lc *i18n.Locale) string {
+	d, err := parseTimerDuration(args[0])
+	if err != nil || d < time.Second || d > maxTimerWait {
+		return lc.T("timer.bad_duration", "Hours", int(maxTimerWait.Hours()))
+	}
+
+	now := clk.Now()
+	t, err := addTimer(event, "timer", now.Add(d), strings.Join(args[1:], " "))
+	if err != nil {
+		return timerReply(lc, err)
+	}
+	loc := userLocation(users.User(event.Source.UserID))
+	return lc.T("timer.set", "At", formatDateTime(lc, t.At.In(loc)), "Until", formatUntil(lc, d))
+}
+
+// 3m, 1h30m, 90s or plain minutes like 3 and 3分
+func parseTimerDuration(s string) (time.Duration, error) {
+	if n, err := strconv.Atoi(strings.TrimSuffix(s, "分")); err == nil {
+		return time.Duration(n) * time.Minute, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// /remind 15:00 label
+func onRemindCommand(event *linebot.Event, args []string, lc *i18n.Locale) string {
+	h, m, err := schedule.ParseClock(args[0])
+	if err != nil {
+		return lc.T("alarm.bad_clock")
+	}
+
+	loc := userLocation(users.User(event.Source.UserID))
+	at := schedule.Alarm{Hour: h, Minute: m}.Next(clk.Now(), loc)
+	label := strings.Join(args[1:], " ")
+	if _, err := addTimer(event, "remind", at, label); err != nil {
+		return timerReply(lc, err)
+	}
+	return lc.T("remind.set", "At", formatDateTime(lc, at.In(loc)), "Label", label)
+}
+
+// /timers lists them, /timers off [n] cancels one or all
+func onTimersCommand(event *linebot.Event, args []string, lc *i18n.Locale) string {
+	u := users.User(event.Source.UserID)
+
+	if len(args) == 0 {
+		if len(u.Timers) == 0 {
+			return lc.T("timers.none")
+		}
+		loc := userLocation(u)
+		lines := []string{lc.T("timers.header")}
+		for i, t := range u.Timers {
+			lines = append(lines, lc.T("timers."+t.Kind, "N", i+1, "At", formatDateTime(lc, t.At.In(loc)), "Label", t.Label))
+		}
+		lines = append(lines, lc.T("timers.usage"))
+		return strings.Join(lines, "\n")
+	}
+
+	if args[0] != "off" {
+		return lc.T("command.usage", "Usage", lc.T("help.timers"))
+	}
+
+	n := 0 // all of them
+	if len(args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 1 || n > len(u.Timers) {
+			return lc.T("timers.unknown", "N", args[1])
+		}
+	}
+
+	var cancelled []store.Timer
+	_, err := users.UpdateUser(u.Id, func(u *store.User) {
+		if n == 0 {
+			cancelled, u.Timers = u.Timers, nil
+			return
+		}
+		if n <= len(u.Timers) {
+			cancelled = []store.Timer{u.Timers[n-1]}
+			u.Timers = append(u.Timers[:n-1:n-1], u.Timers[n:]...)
+		}
+	})
+	if err != nil {
+		logger.Error("failed to cancel timers", "user_id", u.Id, "err", err)
+		return lc.T("save_failed")
+	}
+	for _, t := range cancelled {
+		disarmTimer(t.Id)
+	}
+
+	if n == 0 {
+		return lc.T("timers.off_all")
+	}
+	return lc.T("timers.off", "N", n)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"awake-bot/logging"
+	"awake-bot/queue"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+var (
+	webhooks   *queue.Pool // events waiting to be handled, in order per chat
+	seenEvents *queue.Seen
+)
+
+func startWebhooks() {
+	webhooks = queue.NewPool(conf.Webhook.Workers, conf.Webhook.QueueSize)
+	seenEvents = queue.NewSeen(conf.Webhook.DedupWindow)
+}
+
+// an event with what the vendored SDK does not decode of it
+type webhookEvent struct {
+	*linebot.Event
+	id         string // webhookEventId, empty from older platforms
+	redelivery bool
+}
+
+// checks the signature and decodes the events of a webhook, in order
+func parseWebhook(r *http.Request) ([]webhookEvent, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	events, err := bot.ParseRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw struct {
+		Events []struct {
+			WebhookEventID  string `json:"webhookEventId"`
+			DeliveryContext struct {
+				IsRedelivery bool `json:"isRedelivery"`
+			} `json:"deliveryContext"`
+		} `json:"events"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+
+	parsed := make([]webhookEvent, len(events))
+	for i, e := range events {
+		parsed[i].Event = e
+		if i < len(raw.Events) {
+			parsed[i].id = raw.Events[i].WebhookEventID
+			parsed[i].redelivery = raw.Events[i].DeliveryContext.IsRedelivery
+		}
+	}
+	return parsed, nil
+}
+
+// queues event to be handled after LINE got its answer. false when the
+// queue is full and LINE should deliver it again.
+func enqueueEvent(l *logging.Logger, event webhookEvent) bool {
+	id := eventId(event)
+	if !seenEvents.Add(id, clk.Now()) {
+		l.Info("duplicate event dropped", "event_id", id, "redelivery", event.redelivery)
+		duplicateEvents.Inc()
+		return true
+	}
+
+	err := webhooks.Push(sourceId(event.Source), func() {
+		defer func() {
+			if r := recover(); r != nil {
+				l.Error("event handler panicked", "err", fmt.Sprint(r))
+			}
+		}()
+		onEvent(l, event.Event)
+		queuedEvents.Set(float64(webhooks.Len()))
+	})
+	if err != nil {
+		seenEvents.Forget(id)
+		// lost unless webhook redelivery is turned on for the channel
+		l.Error("event dropped, the queue is full", "event_id", id, "err", err)
+		return false
+	}
+
+	queuedEvents.Set(float64(webhooks.Len()))
+	return true
+}
+
+// webhookEventId, or what identifies the event when LINE did not send one
+func eventId(e webhookEvent) string {
+	if e.id != "" {
+		return e.id
+	}
+
+	id := string(e.Type) + "/" + sourceId(e.Source) + "/" + strconv.FormatInt(e.Timestamp.UnixNano(), 10)
+	switch m := e.Message.(type) {
+	case *linebot.TextMessage:
+		id += "/" + m.ID
+	case *linebot.StickerMessage:
+		id += "/" + m.ID
+	}
+	return id
+}